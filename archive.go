@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	archiveIndexFile  = "index.jsonl"
+	archiveDayLayout  = "2006/01/02"
+	archivePruneEvery = time.Minute
+
+	defaultArchiveLimit = 500
+	maxArchiveLimit     = 5000
+)
+
+// archiveFilePattern matches the frame files store writes, relative to the
+// archive root; nothing else below it is served
+var archiveFilePattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}/\d{6}\.\d{3}(-\d+)?\.(png|jpg)$`)
+
+// archiveEntry is one line of a day's sidecar index
+type archiveEntry struct {
+	File        string    `json:"file"` // relative to the archive root, slash separated
+	Time        time.Time `json:"time"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+}
+
+type archiveDay struct {
+	dir   string    // relative to the archive root, e.g. 2026/10/17
+	date  time.Time // local midnight
+	bytes int64
+}
+
+// frameArchive stores frames on disk below root in one directory per local
+// day (root/2026/10/17/150405.000.png), each with an index.jsonl listing its
+// frames in capture order. Retention removes the oldest frames first once
+// they are older than maxAge or the archive grows beyond maxBytes.
+type frameArchive struct {
+	root     string
+	maxAge   time.Duration
+	maxBytes int64
+	now      func() time.Time
+
+	mu        sync.Mutex
+	days      []*archiveDay // oldest first
+	bytes     int64
+	lastPrune time.Time
+}
+
+func openArchive(config ArchiveConfig) (*frameArchive, error) {
+	root := config.Path
+	if root == "" {
+		root = "archive"
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	a := &frameArchive{
+		root:     root,
+		maxAge:   time.Duration(config.MaxAgeDays) * 24 * time.Hour,
+		maxBytes: int64(config.MaxMB) * 1024 * 1024,
+		now:      time.Now,
+	}
+	if err := a.scan(); err != nil {
+		return nil, err
+	}
+	a.prune()
+
+	return a, nil
+}
+
+// scan rebuilds the per-day totals from the sidecar indexes
+func (a *frameArchive) scan() error {
+	indexes, err := filepath.Glob(filepath.Join(a.root, "*", "*", "*", archiveIndexFile))
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		dir, err := filepath.Rel(a.root, filepath.Dir(index))
+		if err != nil {
+			continue
+		}
+		dir = filepath.ToSlash(dir)
+		date, err := time.ParseInLocation(archiveDayLayout, dir, time.Local)
+		if err != nil {
+			continue
+		}
+
+		entries, err := a.readIndex(dir)
+		if err != nil {
+			return err
+		}
+		day := &archiveDay{dir: dir, date: date}
+		for _, entry := range entries {
+			day.bytes += entry.Size
+		}
+		a.days = append(a.days, day)
+		a.bytes += day.bytes
+	}
+
+	sort.Slice(a.days, func(i, j int) bool { return a.days[i].date.Before(a.days[j].date) })
+	return nil
+}
+
+// store writes a frame into its day directory and records it in the index
+func (a *frameArchive) store(data []byte, contentType string, captured time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	local := captured.Local()
+	dir := local.Format(archiveDayLayout)
+	if err := os.MkdirAll(filepath.Join(a.root, filepath.FromSlash(dir)), 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	ext := ".png"
+	if contentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	base := local.Format("150405.000")
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(a.root, filepath.FromSlash(dir), name)); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+
+	entry := archiveEntry{
+		File:        path.Join(dir, name),
+		Time:        captured,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+	}
+	if err := os.WriteFile(filepath.Join(a.root, filepath.FromSlash(entry.File)), data, 0644); err != nil {
+		return fmt.Errorf("failed to write archive frame: %v", err)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(a.indexPath(dir), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive index: %v", err)
+	}
+	_, err = index.Write(append(line, '\n'))
+	if closeErr := index.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive index: %v", err)
+	}
+
+	day := a.dayLocked(dir, local)
+	day.bytes += entry.Size
+	a.bytes += entry.Size
+
+	if (a.maxBytes > 0 && a.bytes > a.maxBytes) || a.now().Sub(a.lastPrune) >= archivePruneEvery {
+		a.pruneLocked()
+	}
+
+	return nil
+}
+
+func (a *frameArchive) dayLocked(dir string, local time.Time) *archiveDay {
+	for i := len(a.days) - 1; i >= 0; i-- {
+		if a.days[i].dir == dir {
+			return a.days[i]
+		}
+	}
+
+	day := &archiveDay{
+		dir:  dir,
+		date: time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local),
+	}
+	a.days = append(a.days, day)
+	sort.Slice(a.days, func(i, j int) bool { return a.days[i].date.Before(a.days[j].date) })
+	return day
+}
+
+// run applies the retention policy every archivePruneEvery until stop is
+// closed, so that frames past max_age_days also go while none are stored
+func (a *frameArchive) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(archivePruneEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.prune()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (a *frameArchive) prune() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneLocked()
+}
+
+// pruneLocked applies the retention policy, deleting the oldest frames first
+func (a *frameArchive) pruneLocked() {
+	a.lastPrune = a.now()
+
+	var cutoff time.Time
+	if a.maxAge > 0 {
+		cutoff = a.now().Add(-a.maxAge)
+	}
+
+	for len(a.days) > 0 {
+		day := a.days[0]
+		overQuota := a.maxBytes > 0 && a.bytes > a.maxBytes
+		expired := !cutoff.IsZero() && day.date.Before(cutoff)
+		if !overQuota && !expired {
+			return
+		}
+
+		// Whole days past the cutoff go at once
+		if !cutoff.IsZero() && day.date.AddDate(0, 0, 1).Before(cutoff) {
+			a.removeDayLocked(day)
+			continue
+		}
+
+		if err := a.pruneDayLocked(day, cutoff); err != nil {
+			fmt.Printf("Archive: failed to apply retention to %s: %v\n", day.dir, err)
+			return
+		}
+		// A day that still has frames stopped at the first one worth keeping
+		if len(a.days) > 0 && a.days[0] == day {
+			return
+		}
+	}
+}
+
+// pruneDayLocked deletes frames of a day from the oldest on while they are
+// older than cutoff or the archive is over quota, then rewrites the index
+func (a *frameArchive) pruneDayLocked(day *archiveDay, cutoff time.Time) error {
+	entries, err := a.readIndex(day.dir)
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for kept < len(entries) {
+		entry := entries[kept]
+		expired := !cutoff.IsZero() && entry.Time.Before(cutoff)
+		overQuota := a.maxBytes > 0 && a.bytes > a.maxBytes
+		if !expired && !overQuota {
+			break
+		}
+		if err := os.Remove(filepath.Join(a.root, filepath.FromSlash(entry.File))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		day.bytes -= entry.Size
+		a.bytes -= entry.Size
+		kept++
+	}
+
+	if kept == len(entries) {
+		a.removeDayLocked(day)
+		return nil
+	}
+	if kept == 0 {
+		return nil
+	}
+	return a.writeIndex(day.dir, entries[kept:])
+}
+
+// removeDayLocked deletes a whole day directory, and its month and year
+// directories once they are empty
+func (a *frameArchive) removeDayLocked(day *archiveDay) {
+	if err := os.RemoveAll(filepath.Join(a.root, filepath.FromSlash(day.dir))); err != nil {
+		fmt.Printf("Archive: failed to remove %s: %v\n", day.dir, err)
+	}
+	a.bytes -= day.bytes
+	for i, d := range a.days {
+		if d == day {
+			a.days = append(a.days[:i], a.days[i+1:]...)
+			break
+		}
+	}
+
+	// Remove now empty month and year directories; Remove fails if they are not empty
+	month := path.Dir(day.dir)
+	os.Remove(filepath.Join(a.root, filepath.FromSlash(month)))
+	os.Remove(filepath.Join(a.root, filepath.FromSlash(path.Dir(month))))
+}
+
+func (a *frameArchive) indexPath(dir string) string {
+	return filepath.Join(a.root, filepath.FromSlash(dir), archiveIndexFile)
+}
+
+func (a *frameArchive) readIndex(dir string) ([]archiveEntry, error) {
+	f, err := os.Open(a.indexPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []archiveEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry archiveEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn last line after a crash; skip it
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// writeIndex replaces a day's index atomically
+func (a *frameArchive) writeIndex(dir string, entries []archiveEntry) error {
+	tmp := a.indexPath(dir) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.indexPath(dir))
+}
+
+// daySnapshot returns the days currently in the archive, oldest first.
+// Indexes are read without holding mu, so that listing the archive does not
+// hold up store: appends leave complete lines before a torn last one, which
+// readIndex skips, and writeIndex replaces an index atomically.
+func (a *frameArchive) daySnapshot() []archiveDay {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	days := make([]archiveDay, len(a.days))
+	for i, day := range a.days {
+		days[i] = *day
+	}
+	return days
+}
+
+// query lists up to limit frames captured in [from, to], oldest first; zero
+// times leave that end open. more reports whether further frames follow,
+// starting at next, and only the indexes needed for the page are read.
+func (a *frameArchive) query(from, to time.Time, limit int) (result []archiveEntry, next time.Time, more bool, err error) {
+	for _, day := range a.daySnapshot() {
+		if !to.IsZero() && day.date.After(to) {
+			break
+		}
+		if !from.IsZero() && day.date.AddDate(0, 0, 1).Before(from) {
+			continue
+		}
+
+		entries, err := a.readIndex(day.dir)
+		if err != nil {
+			return nil, time.Time{}, false, err
+		}
+		for _, entry := range entries {
+			if (from.IsZero() || !entry.Time.Before(from)) && (to.IsZero() || !entry.Time.After(to)) {
+				if len(result) == limit {
+					return result, entry.Time, true, nil
+				}
+				result = append(result, entry)
+			}
+		}
+	}
+	return result, time.Time{}, false, nil
+}
+
+// lookup finds the index entry of an archived frame by its file name
+// relative to the archive root
+func (a *frameArchive) lookup(file string) (archiveEntry, bool, error) {
+	dir := path.Dir(file)
+	for _, day := range a.daySnapshot() {
+		if day.dir != dir {
+			continue
+		}
+		entries, err := a.readIndex(dir)
+		if err != nil {
+			return archiveEntry{}, false, err
+		}
+		for _, entry := range entries {
+			if entry.File == file {
+				return entry, true, nil
+			}
+		}
+	}
+	return archiveEntry{}, false, nil
+}
+
+func (a *frameArchive) usage() (days int, bytes int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.days), a.bytes
+}
+
+// parseArchiveTime accepts RFC 3339 timestamps or plain dates; a date used as
+// the end of a range covers that whole day
+func parseArchiveTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	if end {
+		return date.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return date, nil
+}
+
+// handleArchive lists archived frames between from and to, a page of limit
+// frames at a time; next is the from of the following page
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		http.Error(w, "Archive is disabled", http.StatusNotFound)
+		return
+	}
+
+	from, err := parseArchiveTime(r.URL.Query().Get("from"), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseArchiveTime(r.URL.Query().Get("to"), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultArchiveLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxArchiveLimit)
+	}
+
+	entries, next, more, err := s.archive.query(from, to, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read archive: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	type frame struct {
+		archiveEntry
+		URL string `json:"url"`
+	}
+	frames := make([]frame, 0, len(entries))
+	for _, entry := range entries {
+		frames = append(frames, frame{archiveEntry: entry, URL: "/archive/files/" + entry.File})
+	}
+	days, bytes := s.archive.usage()
+
+	result := map[string]interface{}{
+		"frames": frames,
+		"count":  len(frames),
+		"days":   days,
+		"bytes":  bytes,
+	}
+	if more {
+		result["next"] = next.Format(time.RFC3339Nano)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleArchiveFile serves a single archived frame. Only frames listed in
+// an index are served, not whatever else is below the archive path.
+func (s *Server) handleArchiveFile(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		http.Error(w, "Archive is disabled", http.StatusNotFound)
+		return
+	}
+
+	name := r.PathValue("path")
+	if !archiveFilePattern.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	entry, ok, err := s.archive.lookup(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read archive: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(s.archive.root, filepath.FromSlash(entry.File)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", entry.ContentType)
+	http.ServeContent(w, r, path.Base(entry.File), entry.Time, f)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestArchive opens an archive in a new directory on a test server
+func newTestArchive(t *testing.T, config ArchiveConfig) (*frameArchive, string) {
+	t.Helper()
+	config.Enabled = true
+	config.Path = t.TempDir()
+	archive, err := openArchive(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ts := newTestServer(t, nil)
+	s.archive = archive
+	return archive, ts.URL
+}
+
+func storeFrame(t *testing.T, archive *frameArchive, captured time.Time) {
+	t.Helper()
+	if err := archive.store([]byte("frame "+captured.Format(time.RFC3339)), "image/png", captured); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveServesIndexedFramesOnly(t *testing.T) {
+	archive, server := newTestArchive(t, ArchiveConfig{})
+	captured := time.Date(2026, 10, 12, 9, 30, 15, 0, time.Local)
+	storeFrame(t, archive, captured)
+
+	day := filepath.Join(archive.root, "2026", "10", "12")
+	for name, data := range map[string]string{
+		"093016.000.png":    "not in the index",
+		"notes.png":         "not a frame",
+		"../../../keys.png": "in the archive root",
+	} {
+		if err := os.WriteFile(filepath.Join(day, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"2026/10/12/093015.000.png", http.StatusOK},
+		{"2026/10/12/093016.000.png", http.StatusNotFound},
+		{"2026/10/12/notes.png", http.StatusNotFound},
+		{"2026/10/12/index.jsonl", http.StatusNotFound},
+		{"keys.png", http.StatusNotFound},
+		{"2026/10/12/../../../keys.png", http.StatusNotFound},
+		{"2026/10/13/093015.000.png", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server + "/archive/files/" + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: %s, want %d", test.path, resp.Status, test.status)
+			continue
+		}
+		if test.status == http.StatusOK {
+			if got := resp.Header.Get("Content-Type"); got != "image/png" {
+				t.Errorf("%s: content type %q", test.path, got)
+			}
+			if want := "frame " + captured.Format(time.RFC3339); string(body) != want {
+				t.Errorf("%s: body %q, want %q", test.path, body, want)
+			}
+		}
+	}
+}
+
+func TestArchivePages(t *testing.T) {
+	archive, server := newTestArchive(t, ArchiveConfig{})
+	var stored []time.Time
+	for day := 12; day <= 13; day++ {
+		for minute := 0; minute < 4; minute++ {
+			captured := time.Date(2026, 10, day, 9, minute, 0, 0, time.Local)
+			storeFrame(t, archive, captured)
+			stored = append(stored, captured)
+		}
+	}
+
+	type page struct {
+		Frames []archiveEntry `json:"frames"`
+		Count  int            `json:"count"`
+		Next   string         `json:"next"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		resp, err := http.Get(server + "/archive" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /archive%s: %s", query, resp.Status)
+		}
+		var p page
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	var listed []time.Time
+	query := "?limit=3"
+	for pages := 0; ; pages++ {
+		if pages > len(stored) {
+			t.Fatal("paging does not end")
+		}
+		p := list(query)
+		if p.Count != len(p.Frames) || p.Count > 3 {
+			t.Fatalf("page of %d frames, count %d", len(p.Frames), p.Count)
+		}
+		for _, frame := range p.Frames {
+			listed = append(listed, frame.Time)
+		}
+		if p.Next == "" {
+			break
+		}
+		query = "?limit=3&from=" + url.QueryEscape(p.Next)
+	}
+	if len(listed) != len(stored) {
+		t.Fatalf("listed %d frames, want %d", len(listed), len(stored))
+	}
+	for i := range stored {
+		if !listed[i].Equal(stored[i]) {
+			t.Errorf("frame %d at %v, want %v", i, listed[i], stored[i])
+		}
+	}
+
+	if p := list("?from=2026-10-13&to=2026-10-13"); p.Count != 4 || p.Next != "" {
+		t.Errorf("one day: %d frames, next %q", p.Count, p.Next)
+	}
+	if p := list(""); p.Count != len(stored) || p.Next != "" {
+		t.Errorf("default page: %d frames, next %q", p.Count, p.Next)
+	}
+	for _, limit := range []string{"0", "-1", "all"} {
+		resp, err := http.Get(server + "/archive?limit=" + limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("limit=%s: %s, want 400", limit, resp.Status)
+		}
+	}
+}
+
+func TestArchiveRetentionWithoutStores(t *testing.T) {
+	archive, _ := newTestArchive(t, ArchiveConfig{MaxAgeDays: 1})
+	clock := &testClock{}
+	archive.now = clock.now
+
+	for _, captured := range []time.Time{
+		time.Date(2026, 10, 12, 9, 0, 0, 0, time.Local),
+		time.Date(2026, 10, 13, 9, 0, 0, 0, time.Local),
+		time.Date(2026, 10, 13, 11, 0, 0, 0, time.Local),
+	} {
+		clock.t = captured
+		storeFrame(t, archive, captured)
+	}
+
+	// Nothing is stored any more; the timer alone expires old frames
+	clock.t = time.Date(2026, 10, 14, 10, 0, 0, 0, time.Local)
+	archive.prune()
+
+	entries, _, _, err := archive.query(time.Time{}, time.Time{}, maxArchiveLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Time.Hour() != 11 {
+		t.Errorf("left %+v, want the 11:00 frame of the 13th", entries)
+	}
+	if _, err := os.Stat(filepath.Join(archive.root, "2026", "10", "12")); !os.IsNotExist(err) {
+		t.Errorf("expired day still on disk: %v", err)
+	}
+	if days, bytes := archive.usage(); days != 1 || bytes != entries[0].Size {
+		t.Errorf("usage %d days, %d bytes", days, bytes)
+	}
+}
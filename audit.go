@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	auditActionSendText = "send_text"
+	auditActionClick    = "click"
+	auditActionConfig   = "config"
+	auditActionReload   = "config_reload" // the config file changed on disk
+)
+
+const (
+	auditResultSuccess = "success"
+	auditResultDenied  = "denied"
+	auditResultError   = "error"
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// errForbidden marks audit entries for requests refused for lack of permission
+var errForbidden = errors.New("forbidden")
+
+// AuditEntry is one line of the audit log. Hash is the SHA-256 of the entry
+// encoded as JSON with Hash left empty; as that includes PrevHash, changing,
+// removing or reordering any entry breaks the chain from there on.
+type AuditEntry struct {
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Remote   string          `json:"remote"`
+	User     string          `json:"user,omitempty"`
+	Action   string          `json:"action"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Result   string          `json:"result"`
+	Error    string          `json:"error,omitempty"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+func (e *AuditEntry) computeHash() (string, error) {
+	unsigned := *e
+	unsigned.Hash = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditLog appends hash-chained entries to a JSON lines file
+type auditLog struct {
+	path       string
+	redactText bool
+	now        func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+func openAuditLog(config AuditConfig) (*auditLog, error) {
+	path := config.Path
+	if path == "" {
+		path = "audit.log"
+	}
+
+	// Continue the chain from the last entry; a broken log is reported but
+	// still appended to, so that new actions are not lost
+	entries, err := readAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	if _, err := verifyAuditEntries(entries); err != nil {
+		fmt.Printf("Warning: audit log %s failed verification: %v\n", path, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	l := &auditLog{
+		path:       path,
+		redactText: config.RedactText,
+		now:        time.Now,
+		file:       file,
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+	return l, nil
+}
+
+// record appends an entry and writes it through to disk
+func (l *auditLog) record(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	entry.Seq = l.seq
+	entry.Time = l.now().UTC()
+	entry.PrevHash = l.lastHash
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.lastHash = hash
+	return nil
+}
+
+func readAuditLog(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// verifyAuditEntries checks the hash chain and returns the number of
+// entries that verified before the first broken one
+func verifyAuditEntries(entries []AuditEntry) (int, error) {
+	prevHash := ""
+	var prevSeq uint64
+	for i, entry := range entries {
+		if entry.Seq != prevSeq+1 {
+			return i, fmt.Errorf("entry %d: sequence number %d follows %d", i+1, entry.Seq, prevSeq)
+		}
+		if entry.PrevHash != prevHash {
+			return i, fmt.Errorf("entry %d (seq %d): previous hash does not match, an entry before it was changed or removed", i+1, entry.Seq)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return i, err
+		}
+		if hash != entry.Hash {
+			return i, fmt.Errorf("entry %d (seq %d): hash does not match its contents, the entry was changed", i+1, entry.Seq)
+		}
+		prevHash = entry.Hash
+		prevSeq = entry.Seq
+	}
+	return len(entries), nil
+}
+
+// verifyAuditLog checks a log file for the -verify-audit flag
+func verifyAuditLog(path string) (int, string, error) {
+	entries, err := readAuditLog(path)
+	if err != nil {
+		return 0, "", err
+	}
+	count, err := verifyAuditEntries(entries)
+	if err != nil {
+		return count, "", err
+	}
+	lastHash := ""
+	if len(entries) > 0 {
+		lastHash = entries[len(entries)-1].Hash
+	}
+	return count, lastHash, nil
+}
+
+// recordAudit logs an action taken through the API. err is nil on success
+// and errForbidden when the caller's role did not allow the action.
+func (s *Server) recordAudit(r *http.Request, action string, params interface{}, err error) {
+	s.recordAuditFrom(r.RemoteAddr, requestUser(r), action, params, err)
+}
+
+// recordAuditFrom logs an action that did not come through a request, or
+// one that did, given its remote address and user
+func (s *Server) recordAuditFrom(remote, user, action string, params interface{}, err error) {
+	if s.audit == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Remote: remote,
+		User:   user,
+		Action: action,
+		Result: auditResultSuccess,
+	}
+	switch {
+	case errors.Is(err, errForbidden):
+		entry.Result = auditResultDenied
+	case err != nil:
+		entry.Result = auditResultError
+		entry.Error = err.Error()
+	}
+	if params != nil {
+		data, marshalErr := json.Marshal(params)
+		if marshalErr != nil {
+			fmt.Printf("Audit: failed to encode parameters: %v\n", marshalErr)
+		} else {
+			entry.Params = data
+		}
+	}
+
+	if err := s.audit.record(entry); err != nil {
+		fmt.Printf("Audit: failed to write entry: %v\n", err)
+	}
+}
+
+// auditTextParams describes sent text, leaving out the text itself when
+// audit.redact_text is set
+func (s *Server) auditTextParams(text string) map[string]interface{} {
+	params := map[string]interface{}{"length": len([]rune(text))}
+	if s.audit != nil && !s.audit.redactText {
+		params["text"] = text
+	}
+	return params
+}
+
+// handleAudit returns the most recent audit entries, or those after since,
+// together with the result of verifying the whole chain
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, permAudit) {
+		return
+	}
+	if s.audit == nil {
+		http.Error(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	var since uint64
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxAuditLimit)
+	}
+
+	s.audit.mu.Lock()
+	entries, err := readAuditLog(s.audit.path)
+	s.audit.mu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	verified, verifyErr := verifyAuditEntries(entries)
+	result := map[string]interface{}{
+		"total":    len(entries),
+		"verified": verifyErr == nil,
+	}
+	if verifyErr != nil {
+		result["verify_error"] = verifyErr.Error()
+		result["verified_entries"] = verified
+	}
+
+	selected := []AuditEntry{}
+	for _, entry := range entries {
+		if entry.Seq > since {
+			selected = append(selected, entry)
+		}
+	}
+	if len(selected) > limit {
+		selected = selected[len(selected)-limit:]
+	}
+	result["entries"] = selected
+	result["count"] = len(selected)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLimit(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	audit, err := openAuditLog(AuditConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.audit = audit
+	for i := 0; i < maxAuditLimit+5; i++ {
+		s.recordAuditFrom("127.0.0.1:1", "", auditActionConfig, nil, nil)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+		first  uint64
+	}{
+		{"", http.StatusOK, defaultAuditLimit, maxAuditLimit + 5 - defaultAuditLimit + 1},
+		{"?limit=3", http.StatusOK, 3, maxAuditLimit + 3},
+		{"?limit=2000000000", http.StatusOK, maxAuditLimit, 6},
+		{"?since=1000&limit=2000000000", http.StatusOK, 5, 1001},
+		{"?since=2000", http.StatusOK, 0, 0},
+		{"?limit=0", http.StatusBadRequest, 0, 0},
+		{"?limit=many", http.StatusBadRequest, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/audit" + test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Fatalf("status %s, want %d", resp.Status, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var result struct {
+				Total    int          `json:"total"`
+				Verified bool         `json:"verified"`
+				Count    int          `json:"count"`
+				Entries  []AuditEntry `json:"entries"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != maxAuditLimit+5 || !result.Verified {
+				t.Errorf("total %d, verified %t", result.Total, result.Verified)
+			}
+			if result.Count != test.count || len(result.Entries) != test.count {
+				t.Fatalf("%d entries, want %d", len(result.Entries), test.count)
+			}
+			if test.count > 0 && result.Entries[0].Seq != test.first {
+				t.Errorf("first entry %d, want %d", result.Entries[0].Seq, test.first)
+			}
+		})
+	}
+}
+
+// writeAuditLog records count entries in a new log file and returns its lines
+func writeAuditLog(t *testing.T, count int) (string, []string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(AuditConfig{Enabled: true, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.file.Close()
+	for i := 1; i <= count; i++ {
+		if err := audit.record(AuditEntry{Remote: "127.0.0.1:1", Action: auditActionClick, Params: json.RawMessage(fmt.Sprintf(`{"x":%d,"y":0}`, i)), Result: auditResultSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// rehash recomputes the hash of an edited log line, as someone covering up
+// an edit would
+func rehash(t *testing.T, line string) string {
+	t.Helper()
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := entry.computeHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Hash = hash
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	path, lines := writeAuditLog(t, 5)
+	count, lastHash, err := verifyAuditLog(path)
+	if err != nil || count != 5 {
+		t.Fatalf("untouched log: %d entries, %v", count, err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		valid  int    // entries verified before the broken one
+		err    string // "" if the change cannot be told from the log alone
+	}{
+		{"edited params", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `"x":3`, `"x":30`, 1)
+			return lines
+		}, 2, "entry 3 (seq 3): hash does not match"},
+		{"edited result", func(lines []string) []string {
+			lines[3] = strings.Replace(lines[3], `"result":"success"`, `"result":"denied"`, 1)
+			return lines
+		}, 3, "entry 4 (seq 4): hash does not match"},
+		{"edited and rehashed", func(lines []string) []string {
+			lines[1] = rehash(t, strings.Replace(lines[1], `"remote":"127.0.0.1:1"`, `"remote":"10.0.0.1:1"`, 1))
+			return lines
+		}, 2, "entry 3 (seq 3): previous hash does not match"},
+		{"deleted", func(lines []string) []string {
+			return append(lines[:2:2], lines[3:]...)
+		}, 2, "entry 3: sequence number 4 follows 2"},
+		{"deleted first", func(lines []string) []string {
+			return lines[1:]
+		}, 0, "entry 1: sequence number 2 follows 0"},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 1, "entry 2: sequence number 3 follows 1"},
+		{"deleted and renumbered", func(lines []string) []string {
+			for i := 3; i < len(lines); i++ {
+				lines[i] = rehash(t, strings.Replace(lines[i], fmt.Sprintf(`"seq":%d`, i+1), fmt.Sprintf(`"seq":%d`, i), 1))
+			}
+			return append(lines[:2:2], lines[3:]...)
+		}, 2, "entry 3 (seq 3): previous hash does not match"},
+		{"not JSON", func(lines []string) []string {
+			lines[4] = "{"
+			return lines
+		}, 0, "line 5:"},
+		// Cutting off the end leaves a valid chain; only the last hash,
+		// kept elsewhere, shows it
+		{"truncated", func(lines []string) []string {
+			return lines[:4]
+		}, 4, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := test.tamper(append([]string(nil), lines...))
+			if err := os.WriteFile(path, []byte(strings.Join(tampered, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			count, hash, err := verifyAuditLog(path)
+			if test.err == "" {
+				if err != nil || count != test.valid || hash == lastHash {
+					t.Errorf("%d entries, last hash %s, %v", count, hash, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want %q", err, test.err)
+			}
+			if count != test.valid {
+				t.Errorf("%d entries verified, want %d", count, test.valid)
+			}
+		})
+	}
+}
+
+// TestAuditLogContinuesChain reopens a log and checks that new entries
+// extend the chain instead of starting a new one
+func TestAuditLogContinuesChain(t *testing.T) {
+	path, _ := writeAuditLog(t, 3)
+	audit, err := openAuditLog(AuditConfig{Enabled: true, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.file.Close()
+	if err := audit.record(AuditEntry{Action: auditActionConfig, Result: auditResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, err := verifyAuditLog(path); err != nil || count != 4 {
+		t.Errorf("%d entries, %v", count, err)
+	}
+}
+
+func TestAuditRejectedInput(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	audit, err := openAuditLog(AuditConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.file.Close()
+	s.audit = audit
+
+	tests := []struct {
+		path, body string
+		action     string
+		err        string
+	}{
+		{"/send-text", `{"text": `, auditActionSendText, "invalid JSON"},
+		{"/send-text", `{"text": ""}`, auditActionSendText, "text cannot be empty"},
+		{"/click", `[1, 2]`, auditActionClick, "invalid JSON"},
+	}
+	for i, test := range tests {
+		resp, err := http.Post(ts.URL+test.path, "application/json", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %s %s: %s, want 400", test.path, test.body, resp.Status)
+		}
+
+		entries, err := readAuditLog(audit.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != i+1 {
+			t.Fatalf("POST %s %s: %d audit entries, want %d", test.path, test.body, len(entries), i+1)
+		}
+		entry := entries[i]
+		if entry.Action != test.action || entry.Result != auditResultError || !strings.Contains(entry.Error, test.err) {
+			t.Errorf("POST %s %s: audited %+v", test.path, test.body, entry)
+		}
+	}
+}
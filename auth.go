@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 600000
+	passwordSaltSize       = 16
+	passwordKeySize        = 32
+
+	tokenHashPrefix = "sha256:"
+
+	sessionCookieName   = "dsc_session"
+	defaultSessionHours = 12
+	loginFailureDelay   = 500 * time.Millisecond
+)
+
+// hashPassword derives a salted PBKDF2-SHA256 hash of password, encoded as
+// pbkdf2-sha256$<iterations>$<salt>$<key> with unpadded base64 salt and key
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, passwordKeySize)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// parsePasswordHash splits a hash made by hashPassword
+func parsePasswordHash(encoded string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return 0, nil, nil, fmt.Errorf("unsupported password hash, expected %s$...", passwordHashScheme)
+	}
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("invalid iteration count in password hash")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid salt in password hash: %v", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid key in password hash")
+	}
+	return iterations, salt, key, nil
+}
+
+func verifyPassword(encoded, password string) bool {
+	iterations, salt, key, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false
+	}
+	derived, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+// newAPIToken returns a random bearer token and the hash to put in the config.
+// Tokens are long and random, so a plain SHA-256 is enough to store them.
+func newAPIToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// authIdentity is who a request is made by: a user or an API token
+type authIdentity struct {
+	Name string
+	Role string
+}
+
+type authSession struct {
+	identity authIdentity
+	expires  time.Time
+}
+
+// authenticator checks requests against the configured users and tokens.
+// Browsers log in through /login and get a session cookie; scripts send an
+// "Authorization: Bearer <token>" header.
+type authenticator struct {
+	users      map[string]UserConfig
+	tokens     []TokenConfig
+	sessionTTL time.Duration
+	dummyHash  string // checked for unknown users so they take as long as known ones
+	now        func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]authSession
+}
+
+func newAuthenticator(config AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		users:      make(map[string]UserConfig),
+		tokens:     config.Tokens,
+		sessionTTL: time.Duration(config.SessionHours) * time.Hour,
+		now:        time.Now,
+		sessions:   make(map[string]authSession),
+	}
+	if a.sessionTTL <= 0 {
+		a.sessionTTL = defaultSessionHours * time.Hour
+	}
+
+	for _, user := range config.Users {
+		if _, _, _, err := parsePasswordHash(user.PasswordHash); err != nil {
+			return nil, fmt.Errorf("user %s: %v", user.Username, err)
+		}
+		if user.Role != "" && !validRole(user.Role) {
+			return nil, fmt.Errorf("user %s: unknown role %q", user.Username, user.Role)
+		}
+		a.users[user.Username] = user
+	}
+	for _, token := range config.Tokens {
+		if !strings.HasPrefix(token.TokenHash, tokenHashPrefix) {
+			return nil, fmt.Errorf("token %s: unsupported token hash, expected %s...", token.Name, tokenHashPrefix)
+		}
+		if token.Role != "" && !validRole(token.Role) {
+			return nil, fmt.Errorf("token %s: unknown role %q", token.Name, token.Role)
+		}
+	}
+
+	dummy, err := hashPassword("")
+	if err != nil {
+		return nil, err
+	}
+	a.dummyHash = dummy
+
+	return a, nil
+}
+
+// keepSessions takes over the sessions of old whose user is still configured
+// with the same password hash and role, so that editing other users or the
+// tokens does not log everyone out. a must not be in use yet.
+func (a *authenticator) keepSessions(old *authenticator) {
+	if old == nil {
+		return
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+
+	for id, session := range old.sessions {
+		user, ok := a.users[session.identity.Name]
+		previous := old.users[session.identity.Name]
+		if ok && user.PasswordHash == previous.PasswordHash && user.Role == previous.Role {
+			a.sessions[id] = session
+		}
+	}
+}
+
+func (a *authenticator) login(username, password string) (authIdentity, bool) {
+	user, ok := a.users[username]
+	if !ok {
+		verifyPassword(a.dummyHash, password)
+		return authIdentity{}, false
+	}
+	if !verifyPassword(user.PasswordHash, password) {
+		return authIdentity{}, false
+	}
+	return authIdentity{Name: user.Username, Role: user.Role}, true
+}
+
+// authenticate returns the user or token a request is made by
+func (a *authenticator) authenticate(r *http.Request) (authIdentity, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return authIdentity{}, false
+		}
+		hash := []byte(hashToken(strings.TrimSpace(token)))
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(hash, []byte(t.TokenHash)) == 1 {
+				return authIdentity{Name: t.Name, Role: t.Role}, true
+			}
+		}
+		return authIdentity{}, false
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return authIdentity{}, false
+	}
+	return a.session(cookie.Value)
+}
+
+func (a *authenticator) newSession(identity authIdentity) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for key, session := range a.sessions {
+		if now.After(session.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[id] = authSession{identity: identity, expires: now.Add(a.sessionTTL)}
+
+	return id, nil
+}
+
+func (a *authenticator) session(id string) (authIdentity, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	session, ok := a.sessions[id]
+	if !ok {
+		return authIdentity{}, false
+	}
+	if a.now().After(session.expires) {
+		delete(a.sessions, id)
+		return authIdentity{}, false
+	}
+	return session.identity, true
+}
+
+func (a *authenticator) endSession(id string) {
+	a.mu.Lock()
+	delete(a.sessions, id)
+	a.mu.Unlock()
+}
+
+type authUserKey struct{}
+
+// requestUser returns the user or token name a request was authenticated as,
+// or "" when authentication is disabled
+func requestUser(r *http.Request) string {
+	identity, _ := r.Context().Value(authUserKey{}).(authIdentity)
+	return identity.Name
+}
+
+// requireAuth wraps every endpoint except the login page. Unauthenticated
+// page loads are sent to the login form, everything else gets a 401.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := s.auth.Load()
+		if auth == nil || r.URL.Path == "/login" || r.URL.Path == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, ok := auth.authenticate(r)
+		if !ok {
+			if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="desktop-surveillance-camera"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authUserKey{}, identity)))
+	})
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign in - Desktop Surveillance Camera</title>
+    <style>
+        body { margin: 0; padding: 20px; font-family: Arial, sans-serif; background-color: #f0f0f0; }
+        .container { max-width: 360px; margin: 60px auto; background-color: white; border-radius: 8px; padding: 20px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+        h1 { font-size: 20px; text-align: center; }
+        label { display: block; margin-top: 12px; }
+        input { width: 100%; box-sizing: border-box; padding: 8px; border: 1px solid #ddd; border-radius: 4px; font-size: 14px; }
+        .btn { width: 100%; margin-top: 20px; padding: 10px; background-color: #007bff; color: white; border: none; border-radius: 4px; cursor: pointer; font-size: 14px; }
+        .error { background-color: #f8d7da; border: 1px solid #f5c6cb; border-radius: 4px; padding: 10px; color: #721c24; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Desktop Surveillance Camera</h1>
+        {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+        <form method="post" action="/login">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <label for="username">Username</label>
+            <input type="text" id="username" name="username" value="{{.Username}}" autocomplete="username" autofocus required>
+            <label for="password">Password</label>
+            <input type="password" id="password" name="password" autocomplete="current-password" required>
+            <button class="btn" type="submit">Sign in</button>
+        </form>
+    </div>
+</body>
+</html>`))
+
+// safeRedirect only allows paths on this server, so the login form cannot be
+// used to send users elsewhere
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, status int, next, username, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, struct {
+		Next, Username, Error string
+	}{next, username, message})
+}
+
+// handleLogin shows the login form and starts a session on success
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	auth := s.auth.Load()
+	if auth == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		s.renderLogin(w, http.StatusOK, safeRedirect(r.URL.Query().Get("next")), "", "")
+
+	case "POST":
+		username := r.PostFormValue("username")
+		next := safeRedirect(r.PostFormValue("next"))
+		identity, ok := auth.login(username, r.PostFormValue("password"))
+		if !ok {
+			time.Sleep(loginFailureDelay)
+			s.renderLogin(w, http.StatusUnauthorized, next, username, "Invalid username or password")
+			return
+		}
+
+		id, err := auth.newSession(identity)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(auth.sessionTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogout ends the session and returns to the login form
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if auth := s.auth.Load(); auth != nil {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			auth.endSession(cookie.Value)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testUser is a configured user with the password it was hashed from
+type testUser struct {
+	UserConfig
+	password string
+}
+
+// newTestUser hashes the password with few iterations; verifyPassword takes
+// the count from the hash, and the full count is slow under the race detector
+func newTestUser(t *testing.T, username, role string) testUser {
+	t.Helper()
+	password := username + "-password"
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, 1000, passwordKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, 1000,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return testUser{UserConfig{Username: username, PasswordHash: hash, Role: role}, password}
+}
+
+// noRedirects is a client that returns redirects instead of following them
+var noRedirects = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+// login signs in through /login and returns the session cookie
+func login(t *testing.T, ts *httptest.Server, username, password string) *http.Cookie {
+	t.Helper()
+	resp, err := noRedirects.PostForm(ts.URL+"/login", url.Values{"username": {username}, "password": {password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookieName && cookie.Value != "" {
+			return cookie
+		}
+	}
+	t.Fatalf("login as %s: %s without a session", username, resp.Status)
+	return nil
+}
+
+// getStatus requests path with the session cookie or bearer token, whichever
+// is set, and returns the status code
+func getStatus(t *testing.T, ts *httptest.Server, path string, cookie *http.Cookie, token string) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, fmt.Sprintf("pbkdf2-sha256$%d$", passwordHashIterations)) {
+		t.Errorf("hash %s", hash)
+	}
+	if !verifyPassword(hash, "correct horse") {
+		t.Error("password does not match its hash")
+	}
+	for _, wrong := range []string{"", "correct horse ", "Correct horse"} {
+		if verifyPassword(hash, wrong) {
+			t.Errorf("%q matches", wrong)
+		}
+	}
+
+	// Every hash has its own salt
+	again, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("two hashes of the same password are equal")
+	}
+
+	// The iteration count and key length are read from the hash; this is
+	// the PBKDF2-HMAC-SHA256 test vector from RFC 7914
+	if !verifyPassword("pbkdf2-sha256$1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLxJypzM8Xm2RZkWZLOdd+8xfHG4RbHjC9UJESBB06GXgw", "passwd") {
+		t.Error("known PBKDF2 vector does not match")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"sha256$1$c2FsdA$a2V5",
+		"pbkdf2-sha256$0$c2FsdA$a2V5",
+		"pbkdf2-sha256$x$c2FsdA$a2V5",
+		"pbkdf2-sha256$1$not base64!$a2V5",
+		"pbkdf2-sha256$1$c2FsdA$",
+		"pbkdf2-sha256$1$c2FsdA",
+	} {
+		if _, _, _, err := parsePasswordHash(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+		if verifyPassword(invalid, "password") {
+			t.Errorf("%q verified", invalid)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	alice := newTestUser(t, "alice", roleAdmin)
+	s, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Users = []UserConfig{alice.UserConfig}
+	})
+
+	// The form is shown without a session, keeping only local redirects
+	resp, err := http.Get(ts.URL + "/login?next=" + url.QueryEscape("//example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `name="next" value="/"`) {
+		t.Errorf("GET /login: %s %s", resp.Status, body)
+	}
+
+	for _, credentials := range [][2]string{{"alice", "wrong"}, {"mallory", alice.password}, {"", ""}} {
+		start := time.Now()
+		resp, err := noRedirects.PostForm(ts.URL+"/login", url.Values{"username": {credentials[0]}, "password": {credentials[1]}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(body, "Invalid username or password") || len(resp.Cookies()) > 0 {
+			t.Errorf("login as %q/%q: %s %v", credentials[0], credentials[1], resp.Status, resp.Cookies())
+		}
+		if elapsed := time.Since(start); elapsed < loginFailureDelay {
+			t.Errorf("failed login answered after %v", elapsed)
+		}
+	}
+
+	resp, err = noRedirects.PostForm(ts.URL+"/login", url.Values{"username": {"alice"}, "password": {alice.password}, "next": {"/last?format=jpeg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/last?format=jpeg" {
+		t.Fatalf("login: %s to %s", resp.Status, resp.Header.Get("Location"))
+	}
+	var session *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil || !session.HttpOnly || session.SameSite != http.SameSiteLaxMode || session.MaxAge != defaultSessionHours*3600 {
+		t.Fatalf("session cookie %v", session)
+	}
+	if status := getStatus(t, ts, "/screen-info", session, ""); status != http.StatusOK {
+		t.Errorf("with the session: %d", status)
+	}
+
+	// Logging out ends the session on the server, not only in the browser
+	req, err := http.NewRequest("POST", ts.URL+"/logout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(session)
+	resp, err = noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Errorf("logout: %s to %s", resp.Status, resp.Header.Get("Location"))
+	}
+	if status := getStatus(t, ts, "/screen-info", session, ""); status != http.StatusUnauthorized {
+		t.Errorf("after logout: %d", status)
+	}
+	auth := s.auth.Load()
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	if len(auth.sessions) != 0 {
+		t.Errorf("%d sessions left", len(auth.sessions))
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	alice := newTestUser(t, "alice", roleViewer)
+	s, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Users = []UserConfig{alice.UserConfig}
+		config.Auth.SessionHours = 2
+	})
+	clock := &testClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s.auth.Load().now = clock.now
+
+	session := login(t, ts, alice.Username, alice.password)
+	if session.MaxAge != 2*3600 {
+		t.Errorf("cookie lasts %ds", session.MaxAge)
+	}
+
+	clock.advance(2 * time.Hour)
+	if status := getStatus(t, ts, "/screen-info", session, ""); status != http.StatusOK {
+		t.Fatalf("at the end of the session: %d", status)
+	}
+	clock.advance(time.Second)
+	if status := getStatus(t, ts, "/screen-info", session, ""); status != http.StatusUnauthorized {
+		t.Fatalf("after the session expired: %d", status)
+	}
+
+	// Expired sessions are forgotten, the next login starts a new one
+	auth := s.auth.Load()
+	auth.mu.Lock()
+	_, kept := auth.sessions[session.Value]
+	auth.mu.Unlock()
+	if kept {
+		t.Error("expired session kept")
+	}
+	if status := getStatus(t, ts, "/screen-info", login(t, ts, alice.Username, alice.password), ""); status != http.StatusOK {
+		t.Errorf("after logging in again: %d", status)
+	}
+}
+
+func TestBearerTokens(t *testing.T) {
+	token, tokenHash, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenHash != hashToken(token) || !strings.HasPrefix(tokenHash, tokenHashPrefix) {
+		t.Fatalf("token hash %s", tokenHash)
+	}
+	_, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Tokens = []TokenConfig{{Name: "script", TokenHash: tokenHash}}
+	})
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"Bearer " + token, http.StatusOK},
+		{"Bearer  " + token + " ", http.StatusOK},
+		{"Bearer " + token[1:], http.StatusUnauthorized},
+		{"Bearer " + tokenHash, http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"Basic " + token, http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", ts.URL+"/screen-info", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("Authorization %q: %s, want %d", test.header, resp.Status, test.status)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	viewer, operator, admin := newTestUser(t, "vera", roleViewer), newTestUser(t, "otto", roleOperator), newTestUser(t, "ada", roleAdmin)
+	token, tokenHash, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Users = []UserConfig{viewer.UserConfig, operator.UserConfig, admin.UserConfig}
+		// A token without a role is a viewer
+		config.Auth.Tokens = []TokenConfig{{Name: "script", TokenHash: tokenHash}}
+	})
+
+	// Allowed requests fail later on their malformed body or the disabled
+	// audit log, so nothing is typed, clicked or changed
+	type request struct {
+		method, path, body string
+		allowed            int
+	}
+	requests := []request{
+		{"GET", "/screen-info", "", http.StatusOK},
+		{"GET", "/preview?overlay=0", "", http.StatusOK},
+		{"POST", "/send-text", "{", http.StatusBadRequest},
+		{"POST", "/click", "{", http.StatusBadRequest},
+		{"POST", "/config", "{", http.StatusBadRequest},
+		{"GET", "/audit", "", http.StatusNotFound},
+	}
+	matrix := map[string][]bool{
+		// screen-info, overlay=0, send-text, click, config, audit
+		roleViewer:   {true, false, false, false, false, false},
+		roleOperator: {true, false, true, true, false, false},
+		roleAdmin:    {true, true, true, true, true, true},
+		"token":      {true, false, false, false, false, false},
+	}
+	credentials := map[string]func(*http.Request){
+		roleViewer:   sessionOf(login(t, ts, viewer.Username, viewer.password)),
+		roleOperator: sessionOf(login(t, ts, operator.Username, operator.password)),
+		roleAdmin:    sessionOf(login(t, ts, admin.Username, admin.password)),
+		"token":      func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) },
+	}
+
+	for who, allowed := range matrix {
+		for i, test := range requests {
+			req, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			credentials[who](req)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			want := http.StatusForbidden
+			if allowed[i] {
+				want = test.allowed
+			}
+			if resp.StatusCode != want {
+				t.Errorf("%s: %s %s: %s, want %d", who, test.method, test.path, resp.Status, want)
+			}
+		}
+	}
+}
+
+func sessionOf(cookie *http.Cookie) func(*http.Request) {
+	return func(r *http.Request) { r.AddCookie(cookie) }
+}
+
+func TestRequireAuth(t *testing.T) {
+	alice := newTestUser(t, "alice", roleAdmin)
+	_, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Users = []UserConfig{alice.UserConfig}
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		accept   string
+		status   int
+		location string
+	}{
+		{"browser", "GET", "/last?format=jpeg", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusSeeOther, "/login?next=%2Flast%3Fformat%3Djpeg"},
+		{"browser home", "GET", "/", "text/html", http.StatusSeeOther, "/login?next=%2F"},
+		{"API client", "GET", "/last", "", http.StatusUnauthorized, ""},
+		{"API client asking for JSON", "GET", "/config", "application/json", http.StatusUnauthorized, ""},
+		{"browser form post", "POST", "/send-text", "text/html", http.StatusUnauthorized, ""},
+		{"login page", "GET", "/login", "text/html", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			resp, err := noRedirects.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status || resp.Header.Get("Location") != test.location {
+				t.Errorf("%s to %q, want %d to %q", resp.Status, resp.Header.Get("Location"), test.status, test.location)
+			}
+			if test.status == http.StatusUnauthorized && !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("WWW-Authenticate %q", resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const defaultFramebufferDevice = "/dev/fb0"
+
+// Packed pixel layouts accepted by capture.framebuffer.format, named after
+// the DRM fourcc codes (channel order from most to least significant bit)
+var framebufferFormats = map[string]pixelFormat{
+	"xrgb8888": {BitsPerPixel: 32, Red: pixelChannel{16, 8}, Green: pixelChannel{8, 8}, Blue: pixelChannel{0, 8}},
+	"xbgr8888": {BitsPerPixel: 32, Red: pixelChannel{0, 8}, Green: pixelChannel{8, 8}, Blue: pixelChannel{16, 8}},
+	"rgb888":   {BitsPerPixel: 24, Red: pixelChannel{16, 8}, Green: pixelChannel{8, 8}, Blue: pixelChannel{0, 8}},
+	"bgr888":   {BitsPerPixel: 24, Red: pixelChannel{0, 8}, Green: pixelChannel{8, 8}, Blue: pixelChannel{16, 8}},
+	"rgb565":   {BitsPerPixel: 16, Red: pixelChannel{11, 5}, Green: pixelChannel{5, 6}, Blue: pixelChannel{0, 5}},
+	"bgr565":   {BitsPerPixel: 16, Red: pixelChannel{0, 5}, Green: pixelChannel{5, 6}, Blue: pixelChannel{11, 5}},
+}
+
+// Default layout for each depth when a dump is described without a format
+var framebufferDefaultFormats = map[int]string{
+	32: "xrgb8888",
+	24: "rgb888",
+	16: "rgb565",
+}
+
+// fbVarScreenInfo holds the fields of the kernel's struct fb_var_screeninfo
+// that are needed to interpret the pixel data
+type fbVarScreenInfo struct {
+	XRes, YRes               int
+	VirtualXRes, VirtualYRes int
+	XOffset, YOffset         int
+	BitsPerPixel             int
+	Grayscale                bool
+	Red, Green, Blue         pixelChannel
+	NonStandard              bool
+}
+
+// parseFbVarScreenInfo decodes a struct fb_var_screeninfo as returned by the
+// FBIOGET_VSCREENINFO ioctl (native byte order, which is little-endian on
+// every platform this program targets)
+func parseFbVarScreenInfo(buf []byte) (fbVarScreenInfo, error) {
+	if len(buf) < 84 {
+		return fbVarScreenInfo{}, fmt.Errorf("screen info too short: %d bytes", len(buf))
+	}
+
+	u32 := func(offset int) int {
+		return int(binary.LittleEndian.Uint32(buf[offset:]))
+	}
+	// struct fb_bitfield { offset, length, msb_right }
+	bitfield := func(offset int) pixelChannel {
+		return pixelChannel{Shift: u32(offset), Bits: u32(offset + 4)}
+	}
+
+	return fbVarScreenInfo{
+		XRes:         u32(0),
+		YRes:         u32(4),
+		VirtualXRes:  u32(8),
+		VirtualYRes:  u32(12),
+		XOffset:      u32(16),
+		YOffset:      u32(20),
+		BitsPerPixel: u32(24),
+		Grayscale:    u32(28) == 1,
+		Red:          bitfield(32),
+		Green:        bitfield(44),
+		Blue:         bitfield(56),
+		NonStandard:  u32(80) != 0,
+	}, nil
+}
+
+// fbGeometry is everything needed to read pixels out of a framebuffer
+type fbGeometry struct {
+	width, height    int
+	xOffset, yOffset int
+	stride           int
+	format           pixelFormat
+}
+
+// framebufferCapturer reads frames straight from a Linux framebuffer device
+// such as /dev/fb0. Geometry and pixel layout are queried from the device;
+// a regular file (for example a dump made with `cat /dev/fb0 > fb.raw`) is
+// read the same way using the geometry from the configuration instead.
+type framebufferCapturer struct {
+	config FramebufferConfig
+}
+
+func newFramebufferCapturer(config FramebufferConfig) *framebufferCapturer {
+	if config.Device == "" {
+		config.Device = defaultFramebufferDevice
+	}
+
+	return &framebufferCapturer{config: config}
+}
+
+func (c *framebufferCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	f, err := os.Open(c.config.Device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open framebuffer: %v", err)
+	}
+	defer f.Close()
+
+	geometry, err := c.geometry(f)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := ScreenRegion{Width: geometry.width, Height: geometry.height}
+	if region != nil {
+		clamped, ok := clampRegion(*region, geometry.width, geometry.height)
+		if !ok {
+			return nil, fmt.Errorf("region %dx%d at (%d, %d) is outside the screen", region.Width, region.Height, region.X, region.Y)
+		}
+		rect = clamped
+	}
+
+	bytesPerPixel := geometry.format.BitsPerPixel / 8
+	offset := int64((geometry.yOffset+rect.Y)*geometry.stride + (geometry.xOffset+rect.X)*bytesPerPixel)
+	buf := make([]byte, (rect.Height-1)*geometry.stride+rect.Width*bytesPerPixel)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("failed to read framebuffer: %v", err)
+	}
+
+	data, err := geometry.format.toBGRA(buf, rect.Width, rect.Height, geometry.stride)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Screenshot{
+		Width:  rect.Width,
+		Height: rect.Height,
+		Data:   data,
+		Region: region,
+	}, nil
+}
+
+func (c *framebufferCapturer) Bounds() (ScreenRegion, error) {
+	f, err := os.Open(c.config.Device)
+	if err != nil {
+		return ScreenRegion{}, fmt.Errorf("failed to open framebuffer: %v", err)
+	}
+	defer f.Close()
+
+	geometry, err := c.geometry(f)
+	if err != nil {
+		return ScreenRegion{}, err
+	}
+
+	return ScreenRegion{Width: geometry.width, Height: geometry.height}, nil
+}
+
+// geometry queries a device, or builds the geometry of a dump from the
+// configuration. Values set in the configuration override queried ones.
+func (c *framebufferCapturer) geometry(f *os.File) (fbGeometry, error) {
+	var geometry fbGeometry
+
+	info, err := f.Stat()
+	if err != nil {
+		return geometry, err
+	}
+
+	if info.Mode()&os.ModeDevice != 0 {
+		vinfo, lineLength, err := fbQueryDevice(f)
+		if err != nil {
+			return geometry, fmt.Errorf("failed to query framebuffer %s: %v", c.config.Device, err)
+		}
+		if vinfo.Grayscale || vinfo.NonStandard || vinfo.Red.Bits == 0 {
+			return geometry, fmt.Errorf("framebuffer %s uses an unsupported pixel format", c.config.Device)
+		}
+		geometry = fbGeometry{
+			width:   vinfo.XRes,
+			height:  vinfo.YRes,
+			xOffset: vinfo.XOffset,
+			yOffset: vinfo.YOffset,
+			stride:  lineLength,
+			format: pixelFormat{
+				BitsPerPixel: vinfo.BitsPerPixel,
+				Red:          vinfo.Red,
+				Green:        vinfo.Green,
+				Blue:         vinfo.Blue,
+			},
+		}
+		if geometry.stride == 0 {
+			geometry.stride = vinfo.VirtualXRes * vinfo.BitsPerPixel / 8
+		}
+	} else if c.config.Width <= 0 || c.config.Height <= 0 {
+		return geometry, fmt.Errorf("%s is not a framebuffer device; capture.framebuffer.width and height are required for dumps", c.config.Device)
+	}
+
+	if c.config.Width > 0 {
+		geometry.width = c.config.Width
+	}
+	if c.config.Height > 0 {
+		geometry.height = c.config.Height
+	}
+
+	formatName := strings.ToLower(c.config.Format)
+	if formatName == "" && c.config.BitsPerPixel > 0 {
+		formatName = framebufferDefaultFormats[c.config.BitsPerPixel]
+	}
+	if formatName == "" && geometry.format.BitsPerPixel == 0 {
+		formatName = framebufferDefaultFormats[32]
+	}
+	if formatName != "" {
+		format, ok := framebufferFormats[formatName]
+		if !ok {
+			return geometry, fmt.Errorf("unknown framebuffer format: %s", c.config.Format)
+		}
+		geometry.format = format
+	}
+
+	if c.config.Stride > 0 {
+		geometry.stride = c.config.Stride
+	}
+	if geometry.stride == 0 {
+		geometry.stride = geometry.width * geometry.format.BitsPerPixel / 8
+	}
+
+	return geometry, nil
+}
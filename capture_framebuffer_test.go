@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/binary"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFramebufferDump stores a framebuffer dump of noiseImage(width, height)
+// in the given format, with rows padding bytes apart beyond the pixels, and
+// returns its path
+func writeFramebufferDump(t *testing.T, format string, width, height, padding int) string {
+	t.Helper()
+	img := noiseImage(width, height)
+	bytesPerPixel := framebufferFormats[format].BitsPerPixel / 8
+	stride := width*bytesPerPixel + padding
+
+	dump := make([]byte, stride*height)
+	for i := range dump {
+		dump[i] = 0xaa
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.RGBAAt(x, y)
+			p := dump[y*stride+x*bytesPerPixel:]
+			switch format {
+			case "xrgb8888":
+				binary.LittleEndian.PutUint32(p, uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+			case "rgb565":
+				binary.LittleEndian.PutUint16(p, uint16(c.R>>3)<<11|uint16(c.G>>2)<<5|uint16(c.B>>3))
+			default:
+				t.Fatalf("no encoder for %s", format)
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "fb.raw")
+	if err := os.WriteFile(path, dump, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rgb565Color is what a color reads back as after a round trip through RGB565
+func rgb565Color(c color.RGBA) color.RGBA {
+	return color.RGBA{
+		uint8(uint16(c.R>>3) * 255 / 31),
+		uint8(uint16(c.G>>2) * 255 / 63),
+		uint8(uint16(c.B>>3) * 255 / 31),
+		255,
+	}
+}
+
+func TestFramebufferDump(t *testing.T) {
+	const width, height = 7, 5
+	want := noiseImage(width, height)
+
+	tests := []struct {
+		name    string
+		format  string
+		config  FramebufferConfig
+		padding int
+		region  *ScreenRegion
+	}{
+		{"xrgb8888", "xrgb8888", FramebufferConfig{Format: "xrgb8888"}, 0, nil},
+		{"xrgb8888 by default", "xrgb8888", FramebufferConfig{}, 0, nil},
+		{"xrgb8888 with stride", "xrgb8888", FramebufferConfig{Format: "XRGB8888", Stride: width*4 + 8}, 8, nil},
+		{"xrgb8888 region", "xrgb8888", FramebufferConfig{}, 0, &ScreenRegion{X: 2, Y: 1, Width: 3, Height: 3}},
+		{"rgb565", "rgb565", FramebufferConfig{Format: "rgb565"}, 0, nil},
+		{"rgb565 from bits per pixel", "rgb565", FramebufferConfig{BitsPerPixel: 16}, 0, nil},
+		{"rgb565 with stride", "rgb565", FramebufferConfig{Format: "rgb565", Stride: width*2 + 6}, 6, nil},
+		{"rgb565 region", "rgb565", FramebufferConfig{Format: "rgb565", Stride: width*2 + 6}, 6, &ScreenRegion{X: 4, Y: 2, Width: 10, Height: 10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Device = writeFramebufferDump(t, test.format, width, height, test.padding)
+			config.Width, config.Height = width, height
+			capturer := newFramebufferCapturer(config)
+
+			bounds, err := capturer.Bounds()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bounds != (ScreenRegion{Width: width, Height: height}) {
+				t.Errorf("bounds %+v, want %dx%d", bounds, width, height)
+			}
+
+			screenshot, err := capturer.Capture(test.region)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rect := ScreenRegion{Width: width, Height: height}
+			if test.region != nil {
+				rect, _ = clampRegion(*test.region, width, height)
+			}
+			if screenshot.Width != rect.Width || screenshot.Height != rect.Height {
+				t.Fatalf("captured %dx%d, want %dx%d", screenshot.Width, screenshot.Height, rect.Width, rect.Height)
+			}
+
+			for y := 0; y < rect.Height; y++ {
+				for x := 0; x < rect.Width; x++ {
+					c := want.RGBAAt(rect.X+x, rect.Y+y)
+					if test.format == "rgb565" {
+						c = rgb565Color(c)
+					}
+					if got := pixelAt(screenshot, x, y); got != c {
+						t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, got, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFramebufferDumpErrors(t *testing.T) {
+	dump := writeFramebufferDump(t, "xrgb8888", 4, 4, 0)
+
+	tests := []struct {
+		name   string
+		config FramebufferConfig
+		region *ScreenRegion
+		err    string
+	}{
+		{"no geometry", FramebufferConfig{Device: dump}, nil, "width and height are required for dumps"},
+		{"unknown format", FramebufferConfig{Device: dump, Width: 4, Height: 4, Format: "yuv420"}, nil, "unknown framebuffer format: yuv420"},
+		{"missing file", FramebufferConfig{Device: filepath.Join(t.TempDir(), "missing"), Width: 4, Height: 4}, nil, "failed to open framebuffer"},
+		{"larger than the dump", FramebufferConfig{Device: dump, Width: 4, Height: 8}, nil, "failed to read framebuffer"},
+		{"region outside", FramebufferConfig{Device: dump, Width: 4, Height: 4}, &ScreenRegion{X: 10, Y: 0, Width: 2, Height: 2}, "outside the screen"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newFramebufferCapturer(test.config).Capture(test.region)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseFbVarScreenInfo(t *testing.T) {
+	buf := make([]byte, 160)
+	put := func(offset int, v uint32) {
+		binary.LittleEndian.PutUint32(buf[offset:], v)
+	}
+	put(0, 1920)
+	put(4, 1080)
+	put(8, 1920)
+	put(12, 2160)
+	put(20, 1080)
+	put(24, 16)
+	put(32, 11)
+	put(36, 5)
+	put(44, 5)
+	put(48, 6)
+	put(56, 0)
+	put(60, 5)
+
+	info, err := parseFbVarScreenInfo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fbVarScreenInfo{
+		XRes: 1920, YRes: 1080,
+		VirtualXRes: 1920, VirtualYRes: 2160,
+		YOffset:      1080,
+		BitsPerPixel: 16,
+		Red:          pixelChannel{11, 5},
+		Green:        pixelChannel{5, 6},
+		Blue:         pixelChannel{0, 5},
+	}
+	if info != want {
+		t.Errorf("got %+v\nwant %+v", info, want)
+	}
+
+	if _, err := parseFbVarScreenInfo(buf[:40]); err == nil {
+		t.Error("short screen info parsed")
+	}
+}
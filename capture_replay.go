@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File extensions picked up by the replay source
+var replayExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+}
+
+// replayCapturer plays back a directory of PNG/JPEG frames in file name
+// order, looping at the end. Subdirectories are walked too, so a recorded
+// archive with one directory per day replays in capture order. With a
+// positive interval the frame is picked from the time elapsed since the
+// first capture, which keeps playback at the recorded rate however often
+// the screen is requested; otherwise every capture advances one frame.
+type replayCapturer struct {
+	interval time.Duration
+	now      func() time.Time
+	frames   []string
+
+	mu       sync.Mutex
+	start    time.Time
+	captures int
+	index    int
+	current  *Screenshot
+}
+
+func newReplayCapturer(path string, interval time.Duration) (*replayCapturer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("capture.replay.path is required for the replay source")
+	}
+
+	frames, err := findReplayFrames(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no PNG or JPEG frames found in %s", path)
+	}
+
+	return &replayCapturer{
+		interval: interval,
+		now:      time.Now,
+		frames:   frames,
+		index:    -1,
+	}, nil
+}
+
+// findReplayFrames lists the image files below root, sorted by path
+func findReplayFrames(root string) ([]string, error) {
+	var frames []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && replayExtensions[strings.ToLower(filepath.Ext(path))] {
+			frames = append(frames, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay frames: %v", err)
+	}
+
+	sort.Strings(frames)
+	return frames, nil
+}
+
+func (c *replayCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	screenshot, err := c.frame(c.nextIndex())
+	if err != nil {
+		return nil, err
+	}
+	if region == nil {
+		// The decoded frame is kept for the next capture, callers get a copy
+		return screenshot.Crop(&ScreenRegion{Width: screenshot.Width, Height: screenshot.Height})
+	}
+	return screenshot.Crop(region)
+}
+
+func (c *replayCapturer) Bounds() (ScreenRegion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := c.index
+	if index < 0 {
+		index = 0
+	}
+	screenshot, err := c.frame(index)
+	if err != nil {
+		return ScreenRegion{}, err
+	}
+	return ScreenRegion{Width: screenshot.Width, Height: screenshot.Height}, nil
+}
+
+// nextIndex picks the frame for the current capture
+func (c *replayCapturer) nextIndex() int {
+	if c.interval <= 0 {
+		index := c.captures % len(c.frames)
+		c.captures++
+		return index
+	}
+
+	now := c.now()
+	if c.start.IsZero() {
+		c.start = now
+	}
+	elapsed := now.Sub(c.start)
+	return int(elapsed/c.interval) % len(c.frames)
+}
+
+// frame decodes the frame at index, reusing the last one when unchanged
+func (c *replayCapturer) frame(index int) (*Screenshot, error) {
+	if index == c.index && c.current != nil {
+		return c.current, nil
+	}
+
+	f, err := os.Open(c.frames[index])
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay frame: %v", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode replay frame %s: %v", c.frames[index], err)
+	}
+
+	c.index = index
+	c.current = screenshotFromImage(img)
+	return c.current, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSyntheticWidth  = 1280
+	defaultSyntheticHeight = 720
+)
+
+// Colour bars of the synthetic test pattern, left to right
+var syntheticBars = []color.RGBA{
+	{192, 192, 192, 255},
+	{192, 192, 0, 255},
+	{0, 192, 192, 255},
+	{0, 192, 0, 255},
+	{192, 0, 192, 255},
+	{192, 0, 0, 255},
+	{0, 0, 192, 255},
+	{16, 16, 16, 255},
+}
+
+// syntheticCapturer renders a deterministic test pattern instead of reading
+// the screen: colour bars, a grey ramp, a marker that moves with every frame
+// and an overlay with the frame counter and capture time. Two captures with
+// the same frame number and clock reading produce identical pixels, which
+// makes it usable for headless runs and tests.
+//
+// A multi-monitor desktop can be simulated by listing monitor rectangles; the
+// desktop then becomes their bounding box, space not covered by any monitor
+// stays black and each monitor is labelled with its ID.
+type syntheticCapturer struct {
+	origin   image.Point
+	width    int
+	height   int
+	monitors []Monitor
+	now      func() time.Time
+
+	mu    sync.Mutex
+	frame uint64
+}
+
+func newSyntheticCapturer(width, height int, monitors []ScreenRegion) *syntheticCapturer {
+	if width <= 0 {
+		width = defaultSyntheticWidth
+	}
+	if height <= 0 {
+		height = defaultSyntheticHeight
+	}
+
+	c := &syntheticCapturer{
+		width:  width,
+		height: height,
+		now:    time.Now,
+	}
+
+	if len(monitors) > 0 {
+		var desktop image.Rectangle
+		for i, bounds := range monitors {
+			c.monitors = append(c.monitors, Monitor{
+				Name:    fmt.Sprintf("SYNTHETIC-%d", i+1),
+				Bounds:  bounds,
+				Primary: i == 0,
+				Scale:   1,
+			})
+			desktop = desktop.Union(regionRect(bounds))
+		}
+		numberMonitors(c.monitors)
+		c.origin = desktop.Min
+		c.width, c.height = desktop.Dx(), desktop.Dy()
+	}
+
+	return c
+}
+
+func (c *syntheticCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	c.mu.Lock()
+	c.frame++
+	frame := c.frame
+	c.mu.Unlock()
+
+	screenshot := screenshotFromImage(c.render(frame, c.now()))
+	return screenshot.Crop(region)
+}
+
+func (c *syntheticCapturer) Bounds() (ScreenRegion, error) {
+	return ScreenRegion{X: c.origin.X, Y: c.origin.Y, Width: c.width, Height: c.height}, nil
+}
+
+func (c *syntheticCapturer) Monitors() ([]Monitor, error) {
+	if len(c.monitors) == 0 {
+		return nil, nil
+	}
+	return append([]Monitor(nil), c.monitors...), nil
+}
+
+// render draws the test pattern for the given frame number and time
+func (c *syntheticCapturer) render(frame uint64, now time.Time) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+
+	// Colour bars over the top two thirds
+	barsHeight := c.height * 2 / 3
+	for i, bar := range syntheticBars {
+		x0 := c.width * i / len(syntheticBars)
+		x1 := c.width * (i + 1) / len(syntheticBars)
+		draw.Draw(img, image.Rect(x0, 0, x1, barsHeight), image.NewUniform(bar), image.Point{}, draw.Src)
+	}
+
+	// Grey ramp over the bottom third
+	for x := 0; x < c.width; x++ {
+		level := uint8(x * 255 / max(c.width-1, 1))
+		draw.Draw(img, image.Rect(x, barsHeight, x+1, c.height), image.NewUniform(color.RGBA{level, level, level, 255}), image.Point{}, draw.Src)
+	}
+
+	// Marker sweeping left to right across the boundary between bars and ramp
+	size := max(c.height/8, 4)
+	travel := max(c.width-size, 1)
+	markerX := int((frame * 8) % uint64(travel))
+	markerY := barsHeight - size/2
+	draw.Draw(img, image.Rect(markerX, markerY, markerX+size, markerY+size), image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(markerX+2, markerY+2, markerX+size-2, markerY+size-2), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+
+	scale := max(c.height/180, 1)
+	if len(c.monitors) > 0 {
+		c.renderMonitors(img, scale)
+	}
+
+	// Frame counter and timestamp in the top-left corner of the primary monitor
+	var corner image.Point
+	for _, monitor := range c.monitors {
+		if monitor.Primary {
+			corner = image.Pt(monitor.Bounds.X, monitor.Bounds.Y).Sub(c.origin)
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("FRAME %06d", frame),
+		now.Format("2006-01-02 15:04:05.000"),
+	}
+	padding := 2 * scale
+	lineHeight := (glyphHeight + 3) * scale
+	boxWidth := 0
+	for _, line := range lines {
+		w, _ := textSize(line, scale)
+		boxWidth = max(boxWidth, w)
+	}
+	box := image.Rect(0, 0, boxWidth+2*padding, len(lines)*lineHeight+padding).Add(corner)
+	draw.Draw(img, box, image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	for i, line := range lines {
+		drawText(img, corner.X+padding, corner.Y+padding+i*lineHeight, line, scale, color.White)
+	}
+
+	return img
+}
+
+// renderMonitors blanks the parts of the desktop outside every monitor and
+// labels each monitor in its bottom-left corner
+func (c *syntheticCapturer) renderMonitors(img *image.RGBA, scale int) {
+	covered := image.NewAlpha(img.Bounds())
+	for _, monitor := range c.monitors {
+		draw.Draw(covered, regionRect(monitor.Bounds).Sub(c.origin), image.Opaque, image.Point{}, draw.Src)
+	}
+	for y := 0; y < c.height; y++ {
+		for x := 0; x < c.width; x++ {
+			if covered.AlphaAt(x, y).A == 0 {
+				img.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+
+	for _, monitor := range c.monitors {
+		rect := regionRect(monitor.Bounds).Sub(c.origin)
+		label := fmt.Sprintf("MONITOR %d", monitor.ID)
+		w, h := textSize(label, scale)
+		padding := 2 * scale
+		box := image.Rect(rect.Min.X, rect.Max.Y-h-2*padding, rect.Min.X+w+2*padding, rect.Max.Y)
+		draw.Draw(img, box, image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+		drawText(img, box.Min.X+padding, box.Min.Y+padding, label, scale, color.White)
+	}
+}
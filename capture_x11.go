@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// X11 core protocol opcodes used by the capturer
+const (
+	x11OpGetGeometry    = 14
+	x11OpGetAtomName    = 17
+	x11OpGetInputFocus  = 43
+	x11OpGetImage       = 73
+	x11OpQueryExtension = 98
+
+	x11ShmAttach   = 1
+	x11ShmDetach   = 2
+	x11ShmGetImage = 4
+
+	x11RandRQueryVersion = 0
+	x11RandRGetMonitors  = 42
+
+	x11ZPixmap = 2
+)
+
+var x11ErrorNames = map[uint8]string{
+	1: "BadRequest", 2: "BadValue", 3: "BadWindow", 4: "BadPixmap", 5: "BadAtom",
+	6: "BadCursor", 7: "BadFont", 8: "BadMatch", 9: "BadDrawable", 10: "BadAccess",
+	11: "BadAlloc", 12: "BadColormap", 13: "BadGContext", 14: "BadIDChoice",
+	15: "BadName", 16: "BadLength", 17: "BadImplementation",
+}
+
+// x11Capturer grabs the root window of an X server by speaking the X11
+// protocol directly. It uses the MIT-SHM extension when the server is local
+// and supports it, and plain GetImage otherwise. The connection is opened on
+// first use and re-established after any failure.
+type x11Capturer struct {
+	display    string
+	disableSHM bool
+
+	mu   sync.Mutex
+	conn *x11Conn
+}
+
+func newX11Capturer(display string, disableSHM bool) *x11Capturer {
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+
+	return &x11Capturer{
+		display:    display,
+		disableSHM: disableSHM,
+	}
+}
+
+func (c *x11Capturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	screenshot, err := conn.capture(region)
+	if err != nil {
+		c.closeLocked()
+		return nil, err
+	}
+
+	return screenshot, nil
+}
+
+func (c *x11Capturer) Bounds() (ScreenRegion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.connection()
+	if err != nil {
+		return ScreenRegion{}, err
+	}
+
+	width, height, err := conn.rootSize()
+	if err != nil {
+		c.closeLocked()
+		return ScreenRegion{}, err
+	}
+
+	return ScreenRegion{Width: width, Height: height}, nil
+}
+
+// Monitors lists the RandR monitors of the screen. Servers without RandR 1.5
+// report none, so the whole root window is treated as one monitor. X11 has
+// no per-monitor scaling, so Scale is always 1.
+func (c *x11Capturer) Monitors() ([]Monitor, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	monitors, err := conn.monitors()
+	if err != nil {
+		c.closeLocked()
+		return nil, err
+	}
+
+	return monitors, nil
+}
+
+func (c *x11Capturer) connection() (*x11Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	conn, err := dialX11(c.display)
+	if err != nil {
+		return nil, err
+	}
+	if !c.disableSHM && conn.local {
+		// Shared memory is an optimisation only; fall back to GetImage
+		if err := conn.initSHM(); err != nil {
+			fmt.Printf("X11: MIT-SHM unavailable, using GetImage: %v\n", err)
+		}
+	}
+
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *x11Capturer) closeLocked() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+type x11Visual struct {
+	red, green, blue uint32
+}
+
+type x11Shm struct {
+	major uint8
+	seg   uint32
+	id    int
+	data  []byte
+}
+
+// x11Conn is a minimal synchronous X11 client connection
+type x11Conn struct {
+	conn  net.Conn
+	rd    *bufio.Reader
+	local bool
+	seq   uint16
+
+	idBase uint32
+	idMask uint32
+	nextID uint32
+
+	imageBigEndian bool
+	formats        map[uint8][2]int // depth -> bits per pixel, scanline pad
+	visuals        map[uint32]x11Visual
+
+	root       uint32
+	rootVisual uint32
+
+	shm *x11Shm
+}
+
+// parseDisplay splits an X display name such as ":0", "unix:1.0" or
+// "host:0" into a dial network and address plus display and screen numbers
+func parseDisplay(display string) (network, address string, number, screen int, err error) {
+	if display == "" {
+		return "", "", 0, 0, fmt.Errorf("no X display set (DISPLAY is empty)")
+	}
+
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return "", "", 0, 0, fmt.Errorf("invalid X display %q", display)
+	}
+	host, rest := display[:colon], display[colon+1:]
+
+	numberPart := rest
+	if dot := strings.Index(rest, "."); dot >= 0 {
+		numberPart = rest[:dot]
+		if screen, err = strconv.Atoi(rest[dot+1:]); err != nil {
+			return "", "", 0, 0, fmt.Errorf("invalid X display %q", display)
+		}
+	}
+	if number, err = strconv.Atoi(numberPart); err != nil {
+		return "", "", 0, 0, fmt.Errorf("invalid X display %q", display)
+	}
+
+	switch {
+	case strings.HasPrefix(host, "/"):
+		// Full socket path, as used by XQuartz
+		return "unix", host + ":" + numberPart, number, screen, nil
+	case host == "" || host == "unix":
+		return "unix", fmt.Sprintf("/tmp/.X11-unix/X%d", number), number, screen, nil
+	default:
+		return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+number)), number, screen, nil
+	}
+}
+
+// readXauthority looks up an MIT-MAGIC-COOKIE-1 for the display in the
+// user's Xauthority file. A missing file or entry is not an error: many
+// servers (such as a plain Xvfb) accept connections without authorisation.
+func readXauthority(network string, number int) (name string, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+
+	hostname, _ := os.Hostname()
+	displayNumber := strconv.Itoa(number)
+	r := bytes.NewReader(content)
+
+	readField := func() ([]byte, bool) {
+		var length uint16
+		if binary.Read(r, binary.BigEndian, &length) != nil {
+			return nil, false
+		}
+		field := make([]byte, length)
+		if _, err := io.ReadFull(r, field); err != nil {
+			return nil, false
+		}
+		return field, true
+	}
+
+	for {
+		var family uint16
+		if binary.Read(r, binary.BigEndian, &family) != nil {
+			return "", nil
+		}
+		address, ok1 := readField()
+		num, ok2 := readField()
+		authName, ok3 := readField()
+		authData, ok4 := readField()
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return "", nil
+		}
+
+		if string(authName) != "MIT-MAGIC-COOKIE-1" {
+			continue
+		}
+		if len(num) > 0 && string(num) != displayNumber {
+			continue
+		}
+
+		const familyLocal, familyWild = 256, 65535
+		switch {
+		case family == familyWild:
+		case family == familyLocal && string(address) == hostname:
+		case network == "tcp" && family != familyLocal:
+		default:
+			continue
+		}
+
+		return string(authName), authData
+	}
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+func dialX11(display string) (*x11Conn, error) {
+	network, address, number, screen, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to X display %s: %v", display, err)
+	}
+
+	c := &x11Conn{
+		conn:    netConn,
+		rd:      bufio.NewReaderSize(netConn, 64*1024),
+		local:   network == "unix",
+		formats: make(map[uint8][2]int),
+		visuals: make(map[uint32]x11Visual),
+	}
+
+	if err := c.setup(network, number, screen); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("X display %s: %v", display, err)
+	}
+
+	return c, nil
+}
+
+// setup performs the connection handshake and records the root window,
+// pixmap formats and visuals of the requested screen
+func (c *x11Conn) setup(network string, number, screen int) error {
+	authName, authData := readXauthority(network, number)
+
+	req := make([]byte, 12, 12+len(authName)+len(authData)+8)
+	req[0] = 'l' // little-endian client
+	binary.LittleEndian.PutUint16(req[2:], 11)
+	binary.LittleEndian.PutUint16(req[4:], 0)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(authName)))
+	binary.LittleEndian.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad4(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad4(len(authData)))...)
+
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(c.rd, head); err != nil {
+		return fmt.Errorf("failed to read setup reply: %v", err)
+	}
+	body := make([]byte, int(binary.LittleEndian.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(c.rd, body); err != nil {
+		return fmt.Errorf("failed to read setup reply: %v", err)
+	}
+
+	switch head[0] {
+	case 0:
+		reason := body[:min(int(head[1]), len(body))]
+		return fmt.Errorf("connection refused by server: %s", strings.TrimSpace(string(reason)))
+	case 2:
+		return fmt.Errorf("server requires further authentication: %s", strings.TrimRight(string(body), "\x00"))
+	case 1:
+	default:
+		return fmt.Errorf("unexpected setup status %d", head[0])
+	}
+
+	if len(body) < 32 {
+		return fmt.Errorf("setup reply too short")
+	}
+	le := binary.LittleEndian
+	c.idBase = le.Uint32(body[4:])
+	c.idMask = le.Uint32(body[8:])
+	vendorLen := int(le.Uint16(body[16:]))
+	numScreens := int(body[20])
+	numFormats := int(body[21])
+	c.imageBigEndian = body[22] == 1
+
+	pos := 32 + vendorLen + pad4(vendorLen)
+	for i := 0; i < numFormats; i++ {
+		if pos+8 > len(body) {
+			return fmt.Errorf("setup reply truncated")
+		}
+		c.formats[body[pos]] = [2]int{int(body[pos+1]), int(body[pos+2])}
+		pos += 8
+	}
+
+	if screen >= numScreens {
+		return fmt.Errorf("screen %d does not exist (server has %d)", screen, numScreens)
+	}
+
+	for i := 0; i < numScreens; i++ {
+		if pos+40 > len(body) {
+			return fmt.Errorf("setup reply truncated")
+		}
+		if i == screen {
+			c.root = le.Uint32(body[pos:])
+			c.rootVisual = le.Uint32(body[pos+32:])
+		}
+		numDepths := int(body[pos+39])
+		pos += 40
+		for d := 0; d < numDepths; d++ {
+			if pos+8 > len(body) {
+				return fmt.Errorf("setup reply truncated")
+			}
+			numVisuals := int(le.Uint16(body[pos+2:]))
+			pos += 8
+			for v := 0; v < numVisuals; v++ {
+				if pos+24 > len(body) {
+					return fmt.Errorf("setup reply truncated")
+				}
+				c.visuals[le.Uint32(body[pos:])] = x11Visual{
+					red:   le.Uint32(body[pos+8:]),
+					green: le.Uint32(body[pos+12:]),
+					blue:  le.Uint32(body[pos+16:]),
+				}
+				pos += 24
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *x11Conn) Close() error {
+	if c.shm != nil {
+		shmDetach(c.shm.data)
+		c.shm = nil
+	}
+	return c.conn.Close()
+}
+
+func (c *x11Conn) newID() uint32 {
+	c.nextID++
+	shift := 0
+	for shift < 32 && c.idMask&(1<<shift) == 0 {
+		shift++
+	}
+	return c.idBase | ((c.nextID << shift) & c.idMask)
+}
+
+// send writes a request; the length field is filled in from len(req)
+func (c *x11Conn) send(req []byte) error {
+	binary.LittleEndian.PutUint16(req[2:], uint16(len(req)/4))
+	c.seq++
+	_, err := c.conn.Write(req)
+	return err
+}
+
+// reply waits for the reply to the most recent request. Events are skipped;
+// an error for this or any earlier request is returned as a Go error.
+func (c *x11Conn) reply() (head []byte, body []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	for {
+		head = make([]byte, 32)
+		if _, err := io.ReadFull(c.rd, head); err != nil {
+			return nil, nil, err
+		}
+
+		switch head[0] {
+		case 0:
+			name := x11ErrorNames[head[1]]
+			if name == "" {
+				name = fmt.Sprintf("error %d", head[1])
+			}
+			return nil, nil, fmt.Errorf("X11 %s (major opcode %d, minor %d)", name, head[10], binary.LittleEndian.Uint16(head[8:]))
+		case 1:
+			body = make([]byte, int(binary.LittleEndian.Uint32(head[4:]))*4)
+			if _, err := io.ReadFull(c.rd, body); err != nil {
+				return nil, nil, err
+			}
+			if binary.LittleEndian.Uint16(head[2:]) != c.seq {
+				continue
+			}
+			return head, body, nil
+		default:
+			// Event; we never select any, but the server may still send some
+		}
+	}
+}
+
+func (c *x11Conn) rootSize() (width, height int, err error) {
+	req := make([]byte, 8)
+	req[0] = x11OpGetGeometry
+	binary.LittleEndian.PutUint32(req[4:], c.root)
+	if err := c.send(req); err != nil {
+		return 0, 0, err
+	}
+
+	head, _, err := c.reply()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(binary.LittleEndian.Uint16(head[16:])), int(binary.LittleEndian.Uint16(head[18:])), nil
+}
+
+// pixelFormat returns the ZPixmap layout of images of the given depth and visual
+func (c *x11Conn) pixelFormat(depth uint8, visual uint32) (pixelFormat, int, error) {
+	format, ok := c.formats[depth]
+	if !ok {
+		return pixelFormat{}, 0, fmt.Errorf("no pixmap format for depth %d", depth)
+	}
+	v, ok := c.visuals[visual]
+	if !ok {
+		v, ok = c.visuals[c.rootVisual]
+	}
+	if !ok || v.red == 0 {
+		return pixelFormat{}, 0, fmt.Errorf("unsupported visual 0x%x (only TrueColor is supported)", visual)
+	}
+
+	return pixelFormat{
+		BitsPerPixel: format[0],
+		BigEndian:    c.imageBigEndian,
+		Red:          channelFromMask(v.red),
+		Green:        channelFromMask(v.green),
+		Blue:         channelFromMask(v.blue),
+	}, format[1], nil
+}
+
+func (c *x11Conn) capture(region *ScreenRegion) (*Screenshot, error) {
+	width, height, err := c.rootSize()
+	if err != nil {
+		return nil, err
+	}
+
+	rect := ScreenRegion{Width: width, Height: height}
+	if region != nil {
+		clamped, ok := clampRegion(*region, width, height)
+		if !ok {
+			return nil, fmt.Errorf("region %dx%d at (%d, %d) is outside the screen", region.Width, region.Height, region.X, region.Y)
+		}
+		rect = clamped
+	}
+
+	var data []byte
+	if c.shm != nil {
+		data, err = c.shmGetImage(rect)
+	} else {
+		data, err = c.getImage(rect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Screenshot{
+		Width:  rect.Width,
+		Height: rect.Height,
+		Data:   data,
+		Region: region,
+	}, nil
+}
+
+func (c *x11Conn) getImageRequest(opcode, minor uint8, rect ScreenRegion, size int) []byte {
+	req := make([]byte, size)
+	req[0] = opcode
+	req[1] = minor
+	le := binary.LittleEndian
+	le.PutUint32(req[4:], c.root)
+	le.PutUint16(req[8:], uint16(int16(rect.X)))
+	le.PutUint16(req[10:], uint16(int16(rect.Y)))
+	le.PutUint16(req[12:], uint16(rect.Width))
+	le.PutUint16(req[14:], uint16(rect.Height))
+	le.PutUint32(req[16:], 0xffffffff) // all planes
+	return req
+}
+
+func (c *x11Conn) getImage(rect ScreenRegion) ([]byte, error) {
+	if err := c.send(c.getImageRequest(x11OpGetImage, x11ZPixmap, rect, 20)); err != nil {
+		return nil, err
+	}
+
+	head, body, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+
+	format, pad, err := c.pixelFormat(head[1], binary.LittleEndian.Uint32(head[8:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return format.toBGRA(body, rect.Width, rect.Height, x11Stride(rect.Width, format.BitsPerPixel, pad))
+}
+
+func x11Stride(width, bitsPerPixel, pad int) int {
+	if pad <= 0 {
+		pad = 32
+	}
+	bitsPerRow := width * bitsPerPixel
+	return (bitsPerRow + pad - 1) / pad * pad / 8
+}
+
+func (c *x11Conn) queryExtension(name string) (present bool, major uint8, err error) {
+	req := make([]byte, 8+len(name)+pad4(len(name)))
+	req[0] = x11OpQueryExtension
+	binary.LittleEndian.PutUint16(req[4:], uint16(len(name)))
+	copy(req[8:], name)
+	if err := c.send(req); err != nil {
+		return false, 0, err
+	}
+
+	head, _, err := c.reply()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return head[8] != 0, head[9], nil
+}
+
+func (c *x11Conn) atomName(atom uint32) (string, error) {
+	req := make([]byte, 8)
+	req[0] = x11OpGetAtomName
+	binary.LittleEndian.PutUint32(req[4:], atom)
+	if err := c.send(req); err != nil {
+		return "", err
+	}
+
+	head, body, err := c.reply()
+	if err != nil {
+		return "", err
+	}
+
+	length := int(binary.LittleEndian.Uint16(head[8:]))
+	if length > len(body) {
+		return "", fmt.Errorf("short GetAtomName reply")
+	}
+	return string(body[:length]), nil
+}
+
+// monitors queries the RandR 1.5 monitor list of the root window, returning
+// nil if the server does not support it
+func (c *x11Conn) monitors() ([]Monitor, error) {
+	present, major, err := c.queryExtension("RANDR")
+	if err != nil || !present {
+		return nil, err
+	}
+
+	req := make([]byte, 12)
+	req[0] = major
+	req[1] = x11RandRQueryVersion
+	binary.LittleEndian.PutUint32(req[4:], 1)
+	binary.LittleEndian.PutUint32(req[8:], 5)
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+	head, _, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+	serverMajor, serverMinor := binary.LittleEndian.Uint32(head[8:]), binary.LittleEndian.Uint32(head[12:])
+	if serverMajor < 1 || (serverMajor == 1 && serverMinor < 5) {
+		return nil, nil
+	}
+
+	req = make([]byte, 12)
+	req[0] = major
+	req[1] = x11RandRGetMonitors
+	binary.LittleEndian.PutUint32(req[4:], c.root)
+	req[8] = 1 // active monitors only
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+	head, body, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each MONITORINFO is 24 bytes followed by its output IDs
+	count := int(binary.LittleEndian.Uint32(head[12:]))
+	monitors := make([]Monitor, 0, count)
+	atoms := make([]uint32, 0, count)
+	offset := 0
+	for i := 0; i < count; i++ {
+		if offset+24 > len(body) {
+			return nil, fmt.Errorf("short RRGetMonitors reply")
+		}
+		info := body[offset:]
+		atoms = append(atoms, binary.LittleEndian.Uint32(info))
+		monitors = append(monitors, Monitor{
+			Bounds: ScreenRegion{
+				X:      int(int16(binary.LittleEndian.Uint16(info[8:]))),
+				Y:      int(int16(binary.LittleEndian.Uint16(info[10:]))),
+				Width:  int(binary.LittleEndian.Uint16(info[12:])),
+				Height: int(binary.LittleEndian.Uint16(info[14:])),
+			},
+			Primary: info[4] != 0,
+			Scale:   1,
+		})
+		offset += 24 + 4*int(binary.LittleEndian.Uint16(info[6:]))
+	}
+
+	for i, atom := range atoms {
+		if monitors[i].Name, err = c.atomName(atom); err != nil {
+			return nil, err
+		}
+	}
+
+	numberMonitors(monitors)
+	return monitors, nil
+}
+
+// sync makes a round trip so that errors for earlier requests are reported
+func (c *x11Conn) sync() error {
+	req := make([]byte, 4)
+	req[0] = x11OpGetInputFocus
+	if err := c.send(req); err != nil {
+		return err
+	}
+	_, _, err := c.reply()
+	return err
+}
+
+func (c *x11Conn) initSHM() error {
+	present, major, err := c.queryExtension("MIT-SHM")
+	if err != nil {
+		return err
+	}
+	if !present {
+		return fmt.Errorf("extension not present")
+	}
+
+	width, height, err := c.rootSize()
+	if err != nil {
+		return err
+	}
+
+	c.shm = &x11Shm{major: major}
+	if err := c.attachSHM(width * height * 4); err != nil {
+		c.shm = nil
+		return err
+	}
+
+	return nil
+}
+
+// attachSHM creates a shared memory segment of at least size bytes and
+// attaches it to the server, replacing any previous segment
+func (c *x11Conn) attachSHM(size int) error {
+	if c.shm.data != nil {
+		req := make([]byte, 8)
+		req[0] = c.shm.major
+		req[1] = x11ShmDetach
+		binary.LittleEndian.PutUint32(req[4:], c.shm.seg)
+		if err := c.send(req); err != nil {
+			return err
+		}
+		shmDetach(c.shm.data)
+		c.shm.data = nil
+	}
+
+	id, data, err := shmCreate(size)
+	if err != nil {
+		return err
+	}
+	// The segment is freed once both sides have detached
+	defer shmRemove(id)
+
+	seg := c.newID()
+	req := make([]byte, 16)
+	req[0] = c.shm.major
+	req[1] = x11ShmAttach
+	binary.LittleEndian.PutUint32(req[4:], seg)
+	binary.LittleEndian.PutUint32(req[8:], uint32(id))
+	req[12] = 0 // read-write, the server writes images into it
+	if err := c.send(req); err != nil {
+		shmDetach(data)
+		return err
+	}
+	if err := c.sync(); err != nil {
+		shmDetach(data)
+		return err
+	}
+
+	c.shm.seg = seg
+	c.shm.id = id
+	c.shm.data = data
+	return nil
+}
+
+func (c *x11Conn) shmGetImage(rect ScreenRegion) ([]byte, error) {
+	// Worst case is 32 bits per pixel with no extra padding
+	if need := rect.Width * rect.Height * 4; need > len(c.shm.data) {
+		if err := c.attachSHM(need); err != nil {
+			return nil, err
+		}
+	}
+
+	req := c.getImageRequest(c.shm.major, x11ShmGetImage, rect, 32)
+	req[20] = x11ZPixmap
+	binary.LittleEndian.PutUint32(req[24:], c.shm.seg)
+	binary.LittleEndian.PutUint32(req[28:], 0) // offset
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+
+	head, _, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+
+	format, pad, err := c.pixelFormat(head[1], binary.LittleEndian.Uint32(head[8:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return format.toBGRA(c.shm.data, rect.Width, rect.Height, x11Stride(rect.Width, format.BitsPerPixel, pad))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+const xvfbWidth, xvfbHeight = 320, 240
+
+// testDisplay returns the X display to capture from: DISPLAY if it is set,
+// otherwise a private Xvfb started for the test. The test is skipped when
+// neither is available.
+func testDisplay(t *testing.T) (display string, width, height int) {
+	t.Helper()
+	if display := os.Getenv("DISPLAY"); display != "" {
+		return display, 0, 0
+	}
+
+	xvfb, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("no DISPLAY and no Xvfb")
+	}
+
+	for number := 99; number < 120; number++ {
+		socket := fmt.Sprintf("/tmp/.X11-unix/X%d", number)
+		if _, err := os.Stat(socket); err == nil {
+			continue
+		}
+
+		cmd := exec.Command(xvfb, fmt.Sprintf(":%d", number), "-screen", "0", fmt.Sprintf("%dx%dx24", xvfbWidth, xvfbHeight), "-nolisten", "tcp")
+		if err := cmd.Start(); err != nil {
+			t.Skipf("failed to start Xvfb: %v", err)
+		}
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if _, err := os.Stat(socket); err == nil {
+				return fmt.Sprintf(":%d", number), xvfbWidth, xvfbHeight
+			}
+		}
+		t.Skip("Xvfb did not come up")
+	}
+
+	t.Skip("no free display number for Xvfb")
+	return "", 0, 0
+}
+
+// canCreateSHM reports whether this platform can create shared memory
+// segments for MIT-SHM at all
+func canCreateSHM() bool {
+	id, data, err := shmCreate(4096)
+	if err != nil {
+		return false
+	}
+	shmDetach(data)
+	shmRemove(id)
+	return true
+}
+
+func TestX11Capture(t *testing.T) {
+	display, width, height := testDisplay(t)
+
+	shm := newX11Capturer(display, false)
+	defer shm.closeLocked()
+	plain := newX11Capturer(display, true)
+	defer plain.closeLocked()
+
+	bounds, err := shm.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width > 0 && (bounds.Width != width || bounds.Height != height) {
+		t.Errorf("bounds %dx%d, want %dx%d", bounds.Width, bounds.Height, width, height)
+	}
+
+	screenshot, err := shm.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screenshot.Width != bounds.Width || screenshot.Height != bounds.Height || len(screenshot.Data) != bounds.Width*bounds.Height*4 {
+		t.Fatalf("captured %dx%d with %d bytes, want %dx%d", screenshot.Width, screenshot.Height, len(screenshot.Data), bounds.Width, bounds.Height)
+	}
+
+	present, _, err := shm.conn.queryExtension("MIT-SHM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if present && shm.conn.shm == nil && canCreateSHM() {
+		t.Error("MIT-SHM is available but not used")
+	}
+
+	// With shared memory disabled the capturer falls back to GetImage
+	fallback, err := plain.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.conn.shm != nil {
+		t.Error("MIT-SHM used although disabled")
+	}
+	if fallback.Width != screenshot.Width || fallback.Height != screenshot.Height {
+		t.Fatalf("GetImage captured %dx%d, MIT-SHM %dx%d", fallback.Width, fallback.Height, screenshot.Width, screenshot.Height)
+	}
+
+	// A region is cut out by the server; both paths must agree on it
+	region := &ScreenRegion{X: bounds.Width / 4, Y: bounds.Height / 4, Width: bounds.Width / 2, Height: bounds.Height / 2}
+	shmRegion, err := shm.Capture(region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainRegion, err := plain.Capture(region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shmRegion.Width != region.Width || shmRegion.Height != region.Height {
+		t.Errorf("region captured as %dx%d, want %dx%d", shmRegion.Width, shmRegion.Height, region.Width, region.Height)
+	}
+	if width > 0 && string(shmRegion.Data) != string(plainRegion.Data) {
+		// A private Xvfb shows nothing that could change between the captures
+		t.Error("MIT-SHM and GetImage captured different pixels")
+	}
+}
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		display        string
+		network, addr  string
+		number, screen int
+		err            bool
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", 0, 0, false},
+		{"unix:1.2", "unix", "/tmp/.X11-unix/X1", 1, 2, false},
+		{"localhost:10.0", "tcp", "localhost:6010", 10, 0, false},
+		{"/private/tmp/com.apple.launchd.x/org.xquartz:0", "unix", "/private/tmp/com.apple.launchd.x/org.xquartz:0", 0, 0, false},
+		{"", "", "", 0, 0, true},
+		{"nodisplay", "", "", 0, 0, true},
+		{":x", "", "", 0, 0, true},
+		{":0.y", "", "", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		network, addr, number, screen, err := parseDisplay(test.display)
+		if (err != nil) != test.err {
+			t.Errorf("%q: error %v", test.display, err)
+			continue
+		}
+		if network != test.network || addr != test.addr || number != test.number || screen != test.screen {
+			t.Errorf("%q: got %s %s %d.%d", test.display, network, addr, number, screen)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Capturer is a source of screenshots. Regions passed to Capture are relative
+// to the top-left corner of the area reported by Bounds.
+type Capturer interface {
+	// Capture grabs the whole screen when region is nil, otherwise only the
+	// given region, clamped to the screen. The screenshot belongs to the
+	// caller, which may draw on it, as privacy masks do.
+	Capture(region *ScreenRegion) (*Screenshot, error)
+
+	// Bounds reports the capturable screen area in the backend's own
+	// coordinates (on Windows the virtual screen, whose origin may be negative).
+	Bounds() (ScreenRegion, error)
+}
+
+// unsupportedCapturer stands in when no capture backend is available on this
+// machine; every call fails with the reason
+type unsupportedCapturer struct {
+	reason string
+}
+
+func (c *unsupportedCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	return nil, errors.New(c.reason)
+}
+
+func (c *unsupportedCapturer) Bounds() (ScreenRegion, error) {
+	return ScreenRegion{}, errors.New(c.reason)
+}
+
+// captureSources lists the valid values of capture.source
+var captureSources = []string{"native", "x11", "framebuffer", "synthetic", "replay"}
+
+func isValidCaptureSource(source string) bool {
+	return source == "" || slices.Contains(captureSources, source)
+}
+
+// newCapturer creates the capture backend selected by capture.source
+func newCapturer(config CaptureConfig) (Capturer, error) {
+	switch config.Source {
+	case "", "native":
+		return newNativeCapturer()
+	case "synthetic":
+		width, height := 0, 0
+		var monitors []ScreenRegion
+		if config.Synthetic != nil {
+			width, height = config.Synthetic.Width, config.Synthetic.Height
+			for _, monitor := range config.Synthetic.Monitors {
+				monitors = append(monitors, ScreenRegion{X: monitor.X, Y: monitor.Y, Width: monitor.Width, Height: monitor.Height})
+			}
+		}
+		return newSyntheticCapturer(width, height, monitors), nil
+	case "x11":
+		x11 := X11Config{}
+		if config.X11 != nil {
+			x11 = *config.X11
+		}
+		return newX11Capturer(x11.Display, x11.DisableSHM), nil
+	case "framebuffer":
+		framebuffer := FramebufferConfig{}
+		if config.Framebuffer != nil {
+			framebuffer = *config.Framebuffer
+		}
+		return newFramebufferCapturer(framebuffer), nil
+	case "replay":
+		path := ""
+		if config.Replay != nil {
+			path = config.Replay.Path
+		}
+		return newReplayCapturer(path, config.Interval)
+	default:
+		return nil, fmt.Errorf("unknown capture source: %s", config.Source)
+	}
+}
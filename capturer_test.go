@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func solidImage(width, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// pixelAt returns the color of a BGRA screenshot pixel as RGBA
+func pixelAt(s *Screenshot, x, y int) color.RGBA {
+	offset := (y*s.Width + x) * 4
+	return color.RGBA{s.Data[offset+2], s.Data[offset+1], s.Data[offset], s.Data[offset+3]}
+}
+
+// noiseImage has a different color in every pixel, so that blurring or
+// pixelating any part of it shows
+func noiseImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 37), uint8(y * 53), uint8(x*y + 11), 255})
+		}
+	}
+	return img
+}
+
+// writeReplayFrames stores images as numbered PNG files in a new directory
+// and returns a replay capturer playing them back one per capture
+func writeReplayFrames(t *testing.T, frames ...image.Image) *replayCapturer {
+	t.Helper()
+	dir := t.TempDir()
+	for i, img := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, string(rune('a'+i))+".png")
+		if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	capturer, err := newReplayCapturer(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return capturer
+}
+
+func TestCrop(t *testing.T) {
+	source := screenshotFromImage(noiseImage(64, 48))
+
+	tests := []struct {
+		name          string
+		region        *ScreenRegion
+		width, height int
+		origin        image.Point // source pixel expected at (0, 0)
+		wantErr       bool
+	}{
+		{"whole screen", nil, 64, 48, image.Pt(0, 0), false},
+		{"inside", &ScreenRegion{X: 10, Y: 12, Width: 20, Height: 10}, 20, 10, image.Pt(10, 12), false},
+		{"past the bottom right", &ScreenRegion{X: 50, Y: 40, Width: 30, Height: 30}, 14, 8, image.Pt(50, 40), false},
+		{"negative origin", &ScreenRegion{X: -5, Y: -3, Width: 10, Height: 10}, 5, 7, image.Pt(0, 0), false},
+		{"right of the screen", &ScreenRegion{X: 64, Y: 0, Width: 5, Height: 5}, 0, 0, image.Point{}, true},
+		{"above the screen", &ScreenRegion{X: 0, Y: -10, Width: 5, Height: 10}, 0, 0, image.Point{}, true},
+		{"empty", &ScreenRegion{X: 1, Y: 1, Width: 0, Height: 5}, 0, 0, image.Point{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cropped, err := source.Crop(test.region)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %dx%d, want an error", cropped.Width, cropped.Height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cropped.Width != test.width || cropped.Height != test.height {
+				t.Fatalf("size %dx%d, want %dx%d", cropped.Width, cropped.Height, test.width, test.height)
+			}
+			if got, want := pixelAt(cropped, 0, 0), pixelAt(source, test.origin.X, test.origin.Y); got != want {
+				t.Errorf("first pixel %v, want %v from %v", got, want, test.origin)
+			}
+			if got, want := pixelAt(cropped, test.width-1, test.height-1), pixelAt(source, test.origin.X+test.width-1, test.origin.Y+test.height-1); got != want {
+				t.Errorf("last pixel %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSyntheticRegions(t *testing.T) {
+	capturer := newSyntheticCapturer(160, 90, nil)
+	bounds, err := capturer.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ScreenRegion{Width: 160, Height: 90}); bounds != want {
+		t.Fatalf("bounds %+v, want %+v", bounds, want)
+	}
+
+	tests := []struct {
+		name          string
+		region        *ScreenRegion
+		width, height int
+		wantErr       bool
+	}{
+		{"whole screen", nil, 160, 90, false},
+		{"inside", &ScreenRegion{X: 10, Y: 10, Width: 40, Height: 30}, 40, 30, false},
+		{"clamped", &ScreenRegion{X: 150, Y: 80, Width: 20, Height: 20}, 10, 10, false},
+		{"outside", &ScreenRegion{X: 160, Y: 0, Width: 10, Height: 10}, 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			screenshot, err := capturer.Capture(test.region)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %dx%d, want an error", screenshot.Width, screenshot.Height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if screenshot.Width != test.width || screenshot.Height != test.height || len(screenshot.Data) != test.width*test.height*4 {
+				t.Errorf("size %dx%d with %d bytes, want %dx%d", screenshot.Width, screenshot.Height, len(screenshot.Data), test.width, test.height)
+			}
+		})
+	}
+}
+
+// TestSyntheticMonitorSelection captures a synthetic desktop of two monitors,
+// the first left of the origin, through Server.capture
+func TestSyntheticMonitorSelection(t *testing.T) {
+	capturer := newSyntheticCapturer(0, 0, []ScreenRegion{
+		{X: 0, Y: 0, Width: 100, Height: 80},
+		{X: -50, Y: 0, Width: 50, Height: 40},
+	})
+	capturer.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	bounds, err := capturer.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ScreenRegion{X: -50, Y: 0, Width: 150, Height: 80}); bounds != want {
+		t.Fatalf("bounds %+v, want %+v", bounds, want)
+	}
+	monitors, err := listMonitors(capturer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monitors) != 2 || monitors[0].Bounds.X != -50 || !monitors[1].Primary {
+		t.Fatalf("monitors %+v, want the left one first with the primary second", monitors)
+	}
+
+	s := NewServer(DefaultConfig(), "", capturer)
+	tests := []struct {
+		name          string
+		monitor       int
+		region        *ScreenRegion
+		width, height int
+		wantErr       bool
+	}{
+		{"whole desktop", 0, nil, 150, 80, false},
+		{"desktop region", 0, &ScreenRegion{X: 10, Y: 10, Width: 30, Height: 20}, 30, 20, false},
+		{"left monitor", 1, nil, 50, 40, false},
+		{"right monitor", 2, nil, 100, 80, false},
+		{"region in a monitor", 2, &ScreenRegion{X: 10, Y: 5, Width: 40, Height: 30}, 40, 30, false},
+		{"region clamped to its monitor", 2, &ScreenRegion{X: 90, Y: 70, Width: 20, Height: 20}, 10, 10, false},
+		{"region clamped at a monitor's left edge", 1, &ScreenRegion{X: -10, Y: 0, Width: 20, Height: 10}, 10, 10, false},
+		{"region outside its monitor", 1, &ScreenRegion{X: 60, Y: 0, Width: 10, Height: 10}, 0, 0, true},
+		{"unknown monitor", 3, nil, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			screenshot, err := s.capture(&ScreenshotOptions{Monitor: test.monitor, Region: test.region})
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got %dx%d, want an error", screenshot.Width, screenshot.Height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if screenshot.Width != test.width || screenshot.Height != test.height {
+				t.Errorf("size %dx%d, want %dx%d", screenshot.Width, screenshot.Height, test.width, test.height)
+			}
+		})
+	}
+
+	// Space not covered by any monitor stays black
+	desktop, err := capturer.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pixelAt(desktop, 10, 70); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("uncovered pixel %v, want black", got)
+	}
+}
+
+func TestSyntheticDeterministic(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := newSyntheticCapturer(160, 90, nil).render(7, now)
+	b := newSyntheticCapturer(160, 90, nil).render(7, now)
+	c := newSyntheticCapturer(160, 90, nil).render(8, now)
+
+	if string(a.Pix) != string(b.Pix) {
+		t.Error("same frame and time rendered differently")
+	}
+	if string(a.Pix) == string(c.Pix) {
+		t.Error("next frame rendered identically")
+	}
+}
+
+func TestReplayCapturer(t *testing.T) {
+	red, green, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+	frames := []color.RGBA{red, green, blue}
+
+	t.Run("one frame per capture", func(t *testing.T) {
+		capturer := writeReplayFrames(t, solidImage(40, 30, red), solidImage(40, 30, green), solidImage(40, 30, blue))
+		for i, want := range []color.RGBA{red, green, blue, red, green} {
+			screenshot, err := capturer.Capture(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pixelAt(screenshot, 0, 0); got != want {
+				t.Errorf("capture %d: %v, want %v", i, got, want)
+			}
+		}
+	})
+
+	t.Run("at the recorded rate", func(t *testing.T) {
+		capturer := writeReplayFrames(t, solidImage(40, 30, red), solidImage(40, 30, green), solidImage(40, 30, blue))
+		start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		capturer.interval = time.Second
+
+		tests := []struct {
+			elapsed time.Duration
+			frame   int
+		}{
+			{0, 0},
+			{999 * time.Millisecond, 0},
+			{time.Second, 1},
+			{2500 * time.Millisecond, 2},
+			{3200 * time.Millisecond, 0},
+			{3200 * time.Millisecond, 0},
+		}
+		for _, test := range tests {
+			capturer.now = func() time.Time { return start.Add(test.elapsed) }
+			screenshot, err := capturer.Capture(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := pixelAt(screenshot, 0, 0), frames[test.frame]; got != want {
+				t.Errorf("after %v: %v, want frame %d", test.elapsed, got, test.frame)
+			}
+		}
+	})
+
+	t.Run("regions", func(t *testing.T) {
+		capturer := writeReplayFrames(t, solidImage(40, 30, red))
+		bounds, err := capturer.Bounds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (ScreenRegion{Width: 40, Height: 30}); bounds != want {
+			t.Fatalf("bounds %+v, want %+v", bounds, want)
+		}
+
+		tests := []struct {
+			region        ScreenRegion
+			width, height int
+			wantErr       bool
+		}{
+			{ScreenRegion{X: 5, Y: 5, Width: 10, Height: 10}, 10, 10, false},
+			{ScreenRegion{X: 35, Y: 25, Width: 10, Height: 10}, 5, 5, false},
+			{ScreenRegion{X: 40, Y: 0, Width: 10, Height: 10}, 0, 0, true},
+		}
+		for _, test := range tests {
+			region := test.region
+			screenshot, err := capturer.Capture(&region)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("region %+v: got %dx%d, want an error", test.region, screenshot.Width, screenshot.Height)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("region %+v: %v", test.region, err)
+			}
+			if screenshot.Width != test.width || screenshot.Height != test.height {
+				t.Errorf("region %+v: size %dx%d, want %dx%d", test.region, screenshot.Width, screenshot.Height, test.width, test.height)
+			}
+		}
+
+		// Without a monitor list the whole replay is monitor 1
+		s := NewServer(DefaultConfig(), "", capturer)
+		screenshot, err := s.capture(&ScreenshotOptions{Monitor: 1, Region: &ScreenRegion{X: 30, Y: 20, Width: 20, Height: 20}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if screenshot.Width != 10 || screenshot.Height != 10 {
+			t.Errorf("monitor 1 region: size %dx%d, want 10x10", screenshot.Width, screenshot.Height)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChangeBlockSize = 16
+	defaultChangeThreshold = 4
+)
+
+// ChangeResult describes how a frame differs from the one before it
+type ChangeResult struct {
+	Time    time.Time      `json:"time"`
+	Score   float64        `json:"score"` // fraction of compared blocks that changed, 0 to 1
+	Changed bool           `json:"changed"`
+	Boxes   []ScreenRegion `json:"boxes"` // bounding boxes of changed areas in frame coordinates
+
+	reset bool // there was no comparable previous frame
+}
+
+// changeDetector compares each frame with the previous one block by block.
+// A block has changed when the mean absolute difference of its colour
+// channels exceeds the threshold; pixels inside ignore regions are left out.
+// Neighbouring changed blocks are merged into one bounding box.
+type changeDetector struct {
+	blockSize int
+	threshold float64
+	ignore    []ScreenRegion
+
+	mu           sync.Mutex
+	previous     *Screenshot
+	previousOpts ScreenshotOptions
+	last         ChangeResult
+	compared     uint64
+	skipped      uint64
+}
+
+func newChangeDetector(config ChangeDetectionConfig) *changeDetector {
+	d := &changeDetector{
+		blockSize: config.BlockSize,
+		threshold: config.Threshold,
+	}
+	if d.blockSize <= 0 {
+		d.blockSize = defaultChangeBlockSize
+	}
+	for _, region := range config.Ignore {
+		d.ignore = append(d.ignore, ScreenRegion{X: region.X, Y: region.Y, Width: region.Width, Height: region.Height})
+	}
+	return d
+}
+
+// compare records screenshot as the latest frame and reports how it differs
+// from the previous one. The first frame, and any frame taken with different
+// options or at a different size, counts as changed everywhere.
+func (d *changeDetector) compare(screenshot *Screenshot, opts *ScreenshotOptions, captured time.Time) ChangeResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := ChangeResult{Time: captured, Boxes: []ScreenRegion{}}
+	previous := d.previous
+	if previous == nil || previous.Width != screenshot.Width || previous.Height != screenshot.Height || !sameScreenshotOptions(&d.previousOpts, opts) {
+		result.Score = 1
+		result.Changed = true
+		result.reset = true
+		result.Boxes = append(result.Boxes, ScreenRegion{Width: screenshot.Width, Height: screenshot.Height})
+	} else {
+		result.Score, result.Boxes = d.diff(previous, screenshot)
+		result.Changed = len(result.Boxes) > 0
+	}
+
+	d.previous = screenshot
+	d.previousOpts = *opts
+	if opts.Region != nil {
+		region := *opts.Region
+		d.previousOpts.Region = &region
+	}
+	d.last = result
+	d.compared++
+
+	return result
+}
+
+// diff compares two frames of the same size
+func (d *changeDetector) diff(a, b *Screenshot) (float64, []ScreenRegion) {
+	size := d.blockSize
+	cols := (b.Width + size - 1) / size
+	rows := (b.Height + size - 1) / size
+	changed := make([]bool, cols*rows)
+
+	frame := image.Rect(0, 0, b.Width, b.Height)
+	var ignore []image.Rectangle
+	for _, region := range d.ignore {
+		if rect := regionRect(region).Intersect(frame); !rect.Empty() {
+			ignore = append(ignore, rect)
+		}
+	}
+
+	compared, changedBlocks := 0, 0
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			block := image.Rect(bx*size, by*size, (bx+1)*size, (by+1)*size).Intersect(frame)
+
+			var sum, pixels int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					if pointIgnored(ignore, x, y) {
+						continue
+					}
+					offset := (y*b.Width + x) * 4
+					if offset+3 >= len(a.Data) || offset+3 >= len(b.Data) {
+						continue
+					}
+					for c := 0; c < 3; c++ {
+						diff := int(a.Data[offset+c]) - int(b.Data[offset+c])
+						if diff < 0 {
+							diff = -diff
+						}
+						sum += diff
+					}
+					pixels++
+				}
+			}
+			if pixels == 0 {
+				continue
+			}
+
+			compared++
+			if float64(sum)/float64(3*pixels) > d.threshold {
+				changed[by*cols+bx] = true
+				changedBlocks++
+			}
+		}
+	}
+
+	if compared == 0 {
+		return 0, []ScreenRegion{}
+	}
+	return float64(changedBlocks) / float64(compared), changedBoxes(changed, cols, rows, size, frame)
+}
+
+func pointIgnored(ignore []image.Rectangle, x, y int) bool {
+	for _, rect := range ignore {
+		if image.Pt(x, y).In(rect) {
+			return true
+		}
+	}
+	return false
+}
+
+// changedBoxes merges touching changed blocks, diagonals included, and
+// returns the bounding box of each group clipped to the frame
+func changedBoxes(changed []bool, cols, rows, size int, frame image.Rectangle) []ScreenRegion {
+	boxes := []ScreenRegion{}
+	seen := make([]bool, len(changed))
+
+	for start := range changed {
+		if !changed[start] || seen[start] {
+			continue
+		}
+
+		minX, minY, maxX, maxY := cols, rows, -1, -1
+		stack := []int{start}
+		seen[start] = true
+		for len(stack) > 0 {
+			index := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := index%cols, index/cols
+			minX, minY = min(minX, x), min(minY, y)
+			maxX, maxY = max(maxX, x), max(maxY, y)
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= cols || ny >= rows {
+						continue
+					}
+					next := ny*cols + nx
+					if changed[next] && !seen[next] {
+						seen[next] = true
+						stack = append(stack, next)
+					}
+				}
+			}
+		}
+
+		rect := image.Rect(minX*size, minY*size, (maxX+1)*size, (maxY+1)*size).Intersect(frame)
+		boxes = append(boxes, ScreenRegion{X: rect.Min.X, Y: rect.Min.Y, Width: rect.Dx(), Height: rect.Dy()})
+	}
+
+	return boxes
+}
+
+func sameScreenshotOptions(a, b *ScreenshotOptions) bool {
+	if (a.Region == nil) != (b.Region == nil) || (a.Region != nil && *a.Region != *b.Region) {
+		return false
+	}
+	return a.Monitor == b.Monitor && a.Compress == b.Compress && a.MaxWidth == b.MaxWidth &&
+		a.MaxHeight == b.MaxHeight && a.Format == b.Format && a.Quality == b.Quality
+}
+
+// forget drops the previous frame, so that the next one is not compared
+// with a frame taken long before it
+func (d *changeDetector) forget() {
+	d.mu.Lock()
+	d.previous = nil
+	d.mu.Unlock()
+}
+
+// skip counts a frame that was not stored because it had not changed
+func (d *changeDetector) skip() {
+	d.mu.Lock()
+	d.skipped++
+	d.mu.Unlock()
+}
+
+// handleChanges reports the result of the latest frame comparison
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if s.change == nil {
+		http.Error(w, "Change detection is disabled", http.StatusNotFound)
+		return
+	}
+
+	s.change.mu.Lock()
+	response := struct {
+		ChangeResult
+		Compared uint64 `json:"compared"`
+		Skipped  uint64 `json:"skipped"`
+	}{s.change.last, s.change.compared, s.change.skipped}
+	s.change.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
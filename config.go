@@ -8,20 +8,166 @@ import (
 )
 
 type Config struct {
+    Version  int            `json:"version"` // schema version, see currentConfigVersion
     Server   ServerConfig   `json:"server"`
     Capture  CaptureConfig  `json:"capture"`
+    History  HistoryConfig  `json:"history"`
+    Archive  ArchiveConfig  `json:"archive"`
+    ChangeDetection ChangeDetectionConfig `json:"change_detection"`
+    Webhooks WebhooksConfig `json:"webhooks"`
+    Auth     AuthConfig     `json:"auth"`
+    Audit    AuditConfig    `json:"audit"`
+    Pause    PauseConfig    `json:"pause"`
 }
 
 type ServerConfig struct {
-    Host string `json:"host"`
-    Port int    `json:"port"`
+    Host string    `json:"host"`
+    Port int       `json:"port"`
+    TLS  TLSConfig `json:"tls"`
+}
+
+// TLSConfig serves HTTPS, either with a given certificate or with a generated self-signed one
+type TLSConfig struct {
+    Enabled      bool     `json:"enabled"`
+    CertFile     string   `json:"cert_file"`     // PEM certificate chain; with key_file, used instead of a generated certificate
+    KeyFile      string   `json:"key_file"`      // PEM private key
+    CertDir      string   `json:"cert_dir"`      // where the generated CA and certificate are kept
+    Hosts        []string `json:"hosts"`         // extra names or addresses for the generated certificate
+    RedirectPort int      `json:"redirect_port"` // plain HTTP port redirecting to HTTPS, 0 to disable
+}
+
+// HistoryConfig bounds the in-memory buffer of recent frames served by /frames
+type HistoryConfig struct {
+    Enabled   bool `json:"enabled"`
+    MaxFrames int  `json:"max_frames"` // number of frames kept
+    MaxMB     int  `json:"max_mb"`     // total size of kept frames in megabytes, 0 for no limit
+}
+
+// ArchiveConfig controls the on-disk archive of captured frames served by /archive
+type ArchiveConfig struct {
+    Enabled    bool   `json:"enabled"`
+    Path       string `json:"path"`         // archive root, frames go to path/YYYY/MM/DD
+    MaxAgeDays int    `json:"max_age_days"` // frames older than this are deleted, 0 to keep them
+    MaxMB      int    `json:"max_mb"`       // total size of the archive in megabytes, 0 for no limit
+}
+
+// ChangeDetectionConfig controls the comparison of consecutive frames reported by /changes
+type ChangeDetectionConfig struct {
+    Enabled       bool           `json:"enabled"`
+    BlockSize     int            `json:"block_size"`     // side of the square blocks compared, in pixels
+    Threshold     float64        `json:"threshold"`      // mean channel difference (0-255) above which a block has changed
+    Ignore        []RegionConfig `json:"ignore"`         // areas of the frame never reported as changed, e.g. a clock
+    SkipUnchanged bool           `json:"skip_unchanged"` // keep unchanged frames out of history, archive and stream
+}
+
+// AuthConfig protects every endpoint with a login for people and API tokens for scripts
+type AuthConfig struct {
+    Enabled      bool          `json:"enabled"`
+    Users        []UserConfig  `json:"users"`
+    Tokens       []TokenConfig `json:"tokens"`
+    SessionHours int           `json:"session_hours"` // lifetime of a login session
+}
+
+type UserConfig struct {
+    Username     string `json:"username"`
+    PasswordHash string `json:"password_hash"` // created with -hash-password
+    Role         string `json:"role"`          // "viewer" (default), "operator" or "admin"
+}
+
+type TokenConfig struct {
+    Name      string `json:"name"`
+    TokenHash string `json:"token_hash"` // created with -new-token
+    Role      string `json:"role"`       // "viewer" (default), "operator" or "admin"
+}
+
+// AuditConfig controls the hash-chained log of remote input and config changes
+type AuditConfig struct {
+    Enabled    bool   `json:"enabled"`
+    Path       string `json:"path"`        // JSON lines file the entries are appended to
+    RedactText bool   `json:"redact_text"` // record only the length of text sent with /send-text
+}
+
+// PauseConfig controls the loopback-only endpoint through which the person
+// at the desk pauses capture with -pause and -resume. It is off by default
+// so that the server only listens on the ports it is configured with.
+type PauseConfig struct {
+    Enabled bool `json:"enabled"`
+    Port    int  `json:"port"` // bound on 127.0.0.1 only
+}
+
+// WebhooksConfig lists the targets alerted about events such as screen changes
+type WebhooksConfig struct {
+    Enabled     bool            `json:"enabled"`
+    Outbox      string          `json:"outbox"`       // directory holding deliveries not yet sent
+    MaxAttempts int             `json:"max_attempts"` // attempts per delivery before it is dropped
+    MaxPending  int             `json:"max_pending"`  // deliveries kept in the outbox, the oldest are dropped beyond it
+    Targets     []WebhookConfig `json:"targets"`
+}
+
+type WebhookConfig struct {
+    URL       string   `json:"url"`
+    Secret    string   `json:"secret"`    // key for the HMAC-SHA256 signature, unsigned if empty
+    Events    []string `json:"events"`    // events to send, all if empty
+    MinScore  float64  `json:"min_score"` // change events are only sent from this change score on
+    Thumbnail bool     `json:"thumbnail"` // attach a small JPEG of the frame to change events
 }
 
 type CaptureConfig struct {
     Mode        string        `json:"mode"`        // "realtime" or "ondemand"
     Interval    time.Duration `json:"interval"`    // for realtime mode
-    Region      *RegionConfig `json:"region"`      // optional screen region
+    Source      string        `json:"source"`      // "native", "x11", "framebuffer", "synthetic" or "replay"
+    Monitor     int           `json:"monitor"`     // 0 for the whole desktop, otherwise a monitor ID from /monitors
+    Region      *RegionConfig `json:"region"`      // optional screen region, relative to the monitor if one is set
+    Masks       []MaskConfig  `json:"masks"`       // privacy masks hidden in every frame
     Compression CompressionConfig `json:"compression"` // image compression settings
+    Watermark   WatermarkConfig   `json:"watermark"`   // label burned into every frame served
+    Synthetic   *SyntheticConfig  `json:"synthetic,omitempty"` // test pattern size for the synthetic source
+    X11         *X11Config        `json:"x11,omitempty"`       // X server for the x11 source
+    Framebuffer *FramebufferConfig `json:"framebuffer,omitempty"` // device or dump for the framebuffer source
+    Replay      *ReplayConfig      `json:"replay,omitempty"`      // recorded frames for the replay source
+    Schedule    *ScheduleConfig    `json:"schedule,omitempty"`    // when frames may be taken, at any time if unset
+}
+
+// WatermarkConfig burns a label into the frames served, so that forwarded
+// screenshots show when and where they were taken
+type WatermarkConfig struct {
+    Enabled  bool    `json:"enabled"`
+    Text     string  `json:"text"`      // {hostname}, {time} and {monitor} are filled in, \n starts a new line
+    TimeZone string  `json:"time_zone"` // IANA name for {time}, local time if empty
+    Position string  `json:"position"`  // top-left, top-right, bottom-left or bottom-right
+    Scale    int     `json:"scale"`     // pixels per dot of the 5x7 font
+    Opacity  float64 `json:"opacity"`   // of the black background, 0 to 1
+}
+
+// ScheduleConfig limits capture to weekly time windows. Quiet ranges and
+// holidays take precedence over the windows.
+type ScheduleConfig struct {
+    TimeZone string         `json:"time_zone"` // IANA name such as Europe/Berlin, local time if empty
+    Windows  []ScheduleRule `json:"windows"`   // capture only inside these, at any time if empty
+    Quiet    []ScheduleRule `json:"quiet"`     // never capture inside these, e.g. lunch breaks
+    Holidays []string       `json:"holidays"`  // YYYY-MM-DD dates without any capture
+}
+
+// ScheduleRule is a daily time range on some weekdays. A range ending at or
+// before its start runs past midnight into the next day.
+type ScheduleRule struct {
+    Days  []string `json:"days"`  // sun, mon, tue, wed, thu, fri, sat; every day if empty
+    Start string   `json:"start"` // HH:MM
+    End   string   `json:"end"`   // HH:MM, exclusive; 24:00 for the end of the day
+}
+
+// MaskConfig is a rectangle that never leaves the machine: it is hidden in
+// the raw frame, before any encoding, storage or streaming
+type MaskConfig struct {
+    Name    string `json:"name"`    // what the mask hides, for reference
+    X       int    `json:"x"`
+    Y       int    `json:"y"`
+    Width   int    `json:"width"`
+    Height  int    `json:"height"`
+    Monitor int    `json:"monitor"` // 0 for coordinates on the whole desktop, otherwise relative to this monitor
+    Style   string `json:"style"`   // "fill" (default), "pixelate" or "blur"
+    Color   string `json:"color"`   // fill color as #RRGGBB, black if empty
+    Size    int    `json:"size"`    // pixelate block size or blur radius in pixels, 0 for the default
 }
 
 type RegionConfig struct {
@@ -32,47 +178,56 @@ type RegionConfig struct {
 }
 
 type CompressionConfig struct {
-    Enabled   bool `json:"enabled"`
-    MaxWidth  int  `json:"max_width"`
-    MaxHeight int  `json:"max_height"`
+    Enabled   bool   `json:"enabled"`
+    MaxWidth  int    `json:"max_width"`
+    MaxHeight int    `json:"max_height"`
+    Format    string `json:"format"`  // "png" or "jpeg", used when neither the URL nor the Accept header picks one
+    Quality   int    `json:"quality"` // JPEG quality 1-100, 0 for the default
+}
+
+type SyntheticConfig struct {
+    Width    int            `json:"width"`
+    Height   int            `json:"height"`
+    Monitors []RegionConfig `json:"monitors,omitempty"` // simulated monitor layout; overrides width and height
 }
 
-func (c *Config) MarshalJSON() ([]byte, error) {
-    type Alias Config
+type X11Config struct {
+    Display    string `json:"display"`     // defaults to $DISPLAY
+    DisableSHM bool   `json:"disable_shm"` // always use plain GetImage
+}
+
+// FramebufferConfig selects the framebuffer to read. The geometry fields are
+// required for dump files and override what a device reports when set.
+type FramebufferConfig struct {
+    Device       string `json:"device"`         // defaults to /dev/fb0
+    Width        int    `json:"width"`
+    Height       int    `json:"height"`
+    BitsPerPixel int    `json:"bits_per_pixel"`
+    Stride       int    `json:"stride"`         // bytes per row, defaults to width * bytes per pixel
+    Format       string `json:"format"`         // xrgb8888, xbgr8888, rgb888, bgr888, rgb565 or bgr565
+}
+
+type ReplayConfig struct {
+    Path string `json:"path"` // directory of PNG/JPEG frames, searched recursively
+}
+
+// MarshalJSON writes the interval as a duration string such as "5s"
+func (c CaptureConfig) MarshalJSON() ([]byte, error) {
+    type Alias CaptureConfig
     return json.Marshal(&struct {
-        *Alias
-        Capture struct {
-            Mode        string             `json:"mode"`
-            Interval    string             `json:"interval"`
-            Region      *RegionConfig      `json:"region"`
-            Compression CompressionConfig  `json:"compression"`
-        } `json:"capture"`
+        Alias
+        Interval string `json:"interval"`
     }{
-        Alias: (*Alias)(c),
-        Capture: struct {
-            Mode        string             `json:"mode"`
-            Interval    string             `json:"interval"`
-            Region      *RegionConfig      `json:"region"`
-            Compression CompressionConfig  `json:"compression"`
-        }{
-            Mode:        c.Capture.Mode,
-            Interval:    c.Capture.Interval.String(),
-            Region:      c.Capture.Region,
-            Compression: c.Capture.Compression,
-        },
+        Alias:    Alias(c),
+        Interval: c.Interval.String(),
     })
 }
 
-func (c *Config) UnmarshalJSON(data []byte) error {
-    type Alias Config
+func (c *CaptureConfig) UnmarshalJSON(data []byte) error {
+    type Alias CaptureConfig
     aux := &struct {
         *Alias
-        Capture struct {
-            Mode        string             `json:"mode"`
-            Interval    string             `json:"interval"`
-            Region      *RegionConfig      `json:"region"`
-            Compression CompressionConfig  `json:"compression"`
-        } `json:"capture"`
+        Interval string `json:"interval"`
     }{
         Alias: (*Alias)(c),
     }
@@ -81,16 +236,12 @@ func (c *Config) UnmarshalJSON(data []byte) error {
         return err
     }
     
-    c.Capture.Mode = aux.Capture.Mode
-    c.Capture.Region = aux.Capture.Region
-    c.Capture.Compression = aux.Capture.Compression
-    
-    if aux.Capture.Interval != "" {
-        interval, err := time.ParseDuration(aux.Capture.Interval)
+    if aux.Interval != "" {
+        interval, err := time.ParseDuration(aux.Interval)
         if err != nil {
             return fmt.Errorf("invalid interval format: %v", err)
         }
-        c.Capture.Interval = interval
+        c.Interval = interval
     }
     
     return nil
@@ -98,52 +249,131 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 
 func DefaultConfig() *Config {
     return &Config{
+        Version: currentConfigVersion,
         Server: ServerConfig{
             Host: "0.0.0.0",
             Port: 9981,
+            TLS: TLSConfig{
+                Enabled: false,
+                CertDir: defaultCertDir,
+                Hosts:   []string{},
+            },
         },
         Capture: CaptureConfig{
             Mode:     "ondemand",
             Interval: 5 * time.Second,
+            Source:   "native",
             Region:   nil, // full screen
+            Masks:    []MaskConfig{},
             Compression: CompressionConfig{
                 Enabled:   false,
                 MaxWidth:  1920,
                 MaxHeight: 1080,
+                Format:    "png",
+                Quality:   defaultJPEGQuality,
             },
+            Watermark: WatermarkConfig{
+                Enabled:  false,
+                Text:     defaultWatermarkText,
+                Position: watermarkBottomRight,
+                Scale:    defaultWatermarkScale,
+                Opacity:  defaultWatermarkOpacity,
+            },
+        },
+        History: HistoryConfig{
+            Enabled:   true,
+            MaxFrames: 60,
+            MaxMB:     100,
+        },
+        Archive: ArchiveConfig{
+            Enabled:    false,
+            Path:       "archive",
+            MaxAgeDays: 30,
+            MaxMB:      1024,
+        },
+        ChangeDetection: ChangeDetectionConfig{
+            Enabled:       true,
+            BlockSize:     defaultChangeBlockSize,
+            Threshold:     defaultChangeThreshold,
+            SkipUnchanged: true,
+        },
+        Webhooks: WebhooksConfig{
+            Enabled:     false,
+            Outbox:      "webhook-outbox",
+            MaxAttempts: defaultWebhookMaxAttempts,
+            MaxPending:  defaultWebhookMaxPending,
+            Targets:     []WebhookConfig{},
+        },
+        Auth: AuthConfig{
+            Enabled:      false,
+            Users:        []UserConfig{},
+            Tokens:       []TokenConfig{},
+            SessionHours: defaultSessionHours,
+        },
+        Audit: AuditConfig{
+            Enabled:    true,
+            Path:       "audit.log",
+            RedactText: true,
+        },
+        Pause: PauseConfig{
+            Port: defaultPausePort,
         },
     }
 }
 
-func LoadConfig(filename string) (*Config, error) {
+// LoadConfig reads the config file in the format given by its extension,
+// creating it with the defaults if it does not exist. The overrides are then
+// applied and the result validated.
+func LoadConfig(filename string, overrides configOverrides) (*Config, error) {
     data, err := os.ReadFile(filename)
-    if err != nil {
-        if os.IsNotExist(err) {
-            config := DefaultConfig()
-            err := SaveConfig(config, filename)
-            if err != nil {
-                return nil, fmt.Errorf("failed to create default config: %v", err)
-            }
-            fmt.Printf("Created default config file: %s\n", filename)
-            return config, nil
+    if os.IsNotExist(err) {
+        err = SaveConfig(DefaultConfig(), filename)
+        if err != nil {
+            return nil, fmt.Errorf("failed to create default config: %v", err)
         }
+        fmt.Printf("Created default config file: %s\n", filename)
+        data, err = os.ReadFile(filename)
+    }
+    if err != nil {
         return nil, err
     }
     
-    var config Config
-    err = json.Unmarshal(data, &config)
+    data, err = decodeConfigFile(filename, data)
+    if err != nil {
+        return nil, err
+    }
+    
+    config, problems, err := readConfig(data, overrides)
+    if err != nil {
+        return nil, err
+    }
+    if len(problems) > 0 {
+        return nil, problems
+    }
+    
+    return config, nil
+}
+
+// parseConfig decodes a config file converted to JSON by decodeConfigFile;
+// settings it leaves out keep their defaults
+func parseConfig(data []byte) (*Config, error) {
+    config := DefaultConfig()
+    err := json.Unmarshal(data, config)
     if err != nil {
         return nil, fmt.Errorf("failed to parse config: %v", err)
     }
     
-    return &config, nil
+    return config, nil
 }
 
+// SaveConfig writes config as JSON, YAML or TOML depending on the extension
+// of filename. The file holds password hashes and webhook secrets, so a new
+// one is only readable by its owner; an existing one keeps its mode.
 func SaveConfig(config *Config, filename string) error {
-    data, err := json.MarshalIndent(config, "", "  ")
+    data, err := encodeConfigFile(config, filename)
     if err != nil {
         return err
     }
     
-    return os.WriteFile(filename, data, 0644)
+    return os.WriteFile(filename, data, 0600)
 }
\ No newline at end of file
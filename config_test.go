@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveConfigFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix file modes")
+	}
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Webhooks.Targets = []WebhookConfig{{URL: "https://example.com/hook", Secret: "s3cret"}}
+
+	// New files, including the default config created on first start, are
+	// only readable by their owner
+	for _, filename := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, filename)
+		if err := SaveConfig(config, path); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %v, %v", filename, info.Mode().Perm(), err)
+		}
+	}
+	created := filepath.Join(dir, "created.json")
+	if _, err := LoadConfig(created, nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(created); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("default config: mode %v, %v", info.Mode().Perm(), err)
+	}
+
+	// Saving over a file keeps the mode it was given
+	shared := filepath.Join(dir, "shared.json")
+	if err := os.WriteFile(shared, []byte("{}"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(config, shared); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(shared); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("existing file: mode %v, %v", info.Mode().Perm(), err)
+	}
+}
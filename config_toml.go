@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The TOML subset read here is TOML 1.0 without dates, times, multi-line
+// strings and special floats: tables, arrays of tables, dotted keys, basic
+// and literal strings, integers, floats, booleans, arrays and inline tables.
+
+var (
+	tomlBareKeyPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	tomlDateTimePattern = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}|[0-9]{2}:[0-9]{2})`)
+	tomlDecimalPattern  = regexp.MustCompile(`^[-+]?[0-9]+$`)
+)
+
+type tomlParser struct {
+	data    string
+	pos     int
+	root    map[string]interface{}
+	table   map[string]interface{} // the table key/value lines go into
+	headers map[string]bool        // tables opened by a [header], by path
+}
+
+// parseTOML decodes a TOML document into maps, lists and scalars like
+// encoding/json does
+func parseTOML(data []byte) (interface{}, error) {
+	p := &tomlParser{data: string(data), root: map[string]interface{}{}, headers: map[string]bool{}}
+	p.table = p.root
+	for {
+		p.skipBlank()
+		if p.pos == len(p.data) {
+			return p.root, nil
+		}
+
+		var err error
+		if p.data[p.pos] == '[' {
+			err = p.parseHeader()
+		} else {
+			err = p.parseKeyValue(p.table)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", 1+strings.Count(p.data[:p.pos], "\n"), err)
+		}
+	}
+}
+
+func (p *tomlParser) peek(s string) bool {
+	return strings.HasPrefix(p.data[p.pos:], s)
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, line breaks and comments
+func (p *tomlParser) skipBlank() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.peek("#") {
+		for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+	if p.pos == len(p.data) || p.peek("\n") || p.peek("\r\n") {
+		return nil
+	}
+	return fmt.Errorf("expected the end of the line, found %q", p.token())
+}
+
+// token returns the text at the current position up to the next space, for errors
+func (p *tomlParser) token() string {
+	rest := p.data[p.pos:]
+	if i := strings.IndexAny(rest, " \t\r\n,]}"); i > 0 {
+		return rest[:i]
+	} else if i == 0 {
+		return rest[:1]
+	}
+	return rest
+}
+
+// parseHeader opens the table of a [table] or [[array of tables]] line
+func (p *tomlParser) parseHeader() error {
+	array := p.peek("[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	p.skipSpace()
+	keys, err := p.parseKeys()
+	if err != nil {
+		return err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !p.peek(closing) {
+		return fmt.Errorf("expected %s after the table name", closing)
+	}
+	p.pos += len(closing)
+
+	if !array {
+		table, path, err := tomlTable(p.root, keys)
+		if err != nil {
+			return err
+		}
+		if p.headers[path] {
+			return fmt.Errorf("table [%s] is defined twice", path)
+		}
+		p.headers[path] = true
+		p.table = table
+		return nil
+	}
+
+	parent, path, err := tomlTable(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	list, ok := parent[key].([]interface{})
+	if _, set := parent[key]; set && !ok {
+		return fmt.Errorf("%s is already set to a value", joinFieldPath(path, key))
+	}
+	p.table = map[string]interface{}{}
+	parent[key] = append(list, p.table)
+	return nil
+}
+
+// tomlTable finds or creates the table at keys below root. An array of
+// tables stands for its last element, as for [a.b] following [[a]].
+func tomlTable(root map[string]interface{}, keys []string) (map[string]interface{}, string, error) {
+	table, path := root, ""
+	for _, key := range keys {
+		path = joinFieldPath(path, key)
+		switch child := table[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = child
+		case []interface{}:
+			last, ok := map[string]interface{}(nil), len(child) > 0
+			if ok {
+				last, ok = child[len(child)-1].(map[string]interface{})
+			}
+			if !ok {
+				return nil, "", fmt.Errorf("%s is already set to a value", path)
+			}
+			path += fmt.Sprintf("[%d]", len(child)-1)
+			table = last
+		default:
+			return nil, "", fmt.Errorf("%s is already set to a value", path)
+		}
+	}
+	return table, path, nil
+}
+
+// parseKeyValue reads a key = value pair into table
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKeys()
+	if err != nil {
+		return err
+	}
+	if !p.peek("=") {
+		return fmt.Errorf("expected = after %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	parent, path, err := tomlTable(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, dup := parent[key]; dup {
+		return fmt.Errorf("duplicate key %s", joinFieldPath(path, key))
+	}
+	parent[key] = value
+	return nil
+}
+
+// parseKeys reads a bare, quoted or dotted key and the spaces after it
+func (p *tomlParser) parseKeys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var key string
+		switch {
+		case p.peek(`"`) || p.peek("'"):
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		default:
+			start := p.pos
+			for p.pos < len(p.data) && tomlBareKeyPattern.MatchString(p.data[p.pos:p.pos+1]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected a key, found %q", p.token())
+			}
+			key = p.data[start:p.pos]
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if !p.peek(".") {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch {
+	case p.pos == len(p.data):
+		return nil, fmt.Errorf("expected a value")
+	case p.peek(`"`) || p.peek("'"):
+		return p.parseString()
+	case p.peek("["):
+		return p.parseArray()
+	case p.peek("{"):
+		return p.parseInlineTable()
+	}
+
+	token := p.token()
+	switch {
+	case token == "true" || token == "false":
+		p.pos += len(token)
+		return token == "true", nil
+	case tomlDateTimePattern.MatchString(token):
+		return nil, fmt.Errorf("dates and times are not supported, use a string")
+	}
+
+	number := strings.ReplaceAll(token, "_", "")
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(number, prefix) {
+			n, err := strconv.ParseInt(number[2:], base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %q", token)
+			}
+			p.pos += len(token)
+			return n, nil
+		}
+	}
+	if tomlDecimalPattern.MatchString(number) {
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", token)
+		}
+		p.pos += len(token)
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil && yamlFloatPattern.MatchString(number) {
+		p.pos += len(token)
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// parseString reads a basic "string" or a literal 'string'
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.data[p.pos]
+	if p.peek(strings.Repeat(string(quote), 3)) {
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+	for i := p.pos + 1; i < len(p.data) && p.data[i] != '\n'; i++ {
+		switch {
+		case quote == '"' && p.data[i] == '\\':
+			i++
+		case p.data[i] == quote:
+			body := p.data[p.pos+1 : i]
+			p.pos = i + 1
+			if quote == '\'' {
+				return body, nil
+			}
+			return unescapeConfigString(body)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// parseArray reads an array, which may span several lines
+func (p *tomlParser) parseArray() (interface{}, error) {
+	p.pos++
+	list := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.peek("]") {
+			p.pos++
+			return list, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+
+		p.skipBlank()
+		switch {
+		case p.peek(","):
+			p.pos++
+		case !p.peek("]"):
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// parseInlineTable reads a { key = value, ... } table written on one line
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	p.pos++
+	table := map[string]interface{}{}
+	p.skipSpace()
+	if p.peek("}") {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch {
+		case p.peek(","):
+			p.pos++
+		case p.peek("}"):
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// writeTOMLTable writes the fields of object, the table at path. Plain
+// values come before sub-tables since everything after a [header] belongs
+// to that table. TOML has no null, so null settings are left out and keep
+// their defaults.
+func writeTOMLTable(buf *bytes.Buffer, object orderedObject, path []string) error {
+	var tables []orderedField
+	for _, field := range object {
+		if isTOMLTable(field.value) {
+			tables = append(tables, field)
+			continue
+		}
+		if field.value == nil {
+			continue
+		}
+		value, err := tomlValue(field.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(append(path, field.key), "."), err)
+		}
+		buf.WriteString(tomlKey(field.key) + " = " + value + "\n")
+	}
+
+	for _, field := range tables {
+		keys := append(append([]string{}, path...), tomlKey(field.key))
+		header := strings.Join(keys, ".")
+		if table, ok := field.value.(orderedObject); ok {
+			fmt.Fprintf(buf, "\n[%s]\n", header)
+			if err := writeTOMLTable(buf, table, keys); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, item := range field.value.([]interface{}) {
+			fmt.Fprintf(buf, "\n[[%s]]\n", header)
+			if err := writeTOMLTable(buf, item.(orderedObject), keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isTOMLTable reports whether value is written as a [table] or as an
+// [[array of tables]] rather than inline
+func isTOMLTable(value interface{}) bool {
+	switch v := value.(type) {
+	case orderedObject:
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.(orderedObject); !ok {
+				return false
+			}
+		}
+		return len(v) > 0
+	}
+	return false
+}
+
+func tomlValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", fmt.Errorf("null cannot be written in TOML")
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return quoteConfigString(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case orderedObject:
+		var fields []string
+		for _, field := range v {
+			if field.value == nil {
+				continue
+			}
+			s, err := tomlValue(field.value)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, tomlKey(field.key)+" = "+s)
+		}
+		if len(fields) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(fields, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+func tomlKey(key string) string {
+	if tomlBareKeyPattern.MatchString(key) {
+		return key
+	}
+	return quoteConfigString(key)
+}
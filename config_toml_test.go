@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want interface{}
+	}{
+		{"empty document", "# nothing here\n", map[string]interface{}{}},
+		{"scalars", "a = 1\nb = -2.5\nc = true\nd = \"text\"\ne = 1_000\nf = 0x1F\ng = 0o17\nh = 0b101\ni = 1e3\n", map[string]interface{}{
+			"a": int64(1), "b": -2.5, "c": true, "d": "text", "e": int64(1000), "f": int64(31), "g": int64(15), "h": int64(5), "i": 1000.0,
+		}},
+		{"strings", `a = "tab\there \"quoted\" \u00e9"` + "\nb = 'C:\\path\\no escapes'\n", map[string]interface{}{
+			"a": "tab\there \"quoted\" é", "b": `C:\path\no escapes`,
+		}},
+		{"comments", "# header\na = 1 # trailing\nb = \"not # a comment\"\n", map[string]interface{}{
+			"a": int64(1), "b": "not # a comment",
+		}},
+		{"tables", "version = 2\n\n[server]\nhost = \"0.0.0.0\"\n\n[server.tls]\nenabled = true\n", map[string]interface{}{
+			"version": int64(2),
+			"server":  map[string]interface{}{"host": "0.0.0.0", "tls": map[string]interface{}{"enabled": true}},
+		}},
+		{"dotted keys", "server.port = 80\n\"quoted key\".x = 1\n\n[capture]\nwatermark.enabled = true\nwatermark . position = \"top-left\"\n", map[string]interface{}{
+			"server":     map[string]interface{}{"port": int64(80)},
+			"quoted key": map[string]interface{}{"x": int64(1)},
+			"capture":    map[string]interface{}{"watermark": map[string]interface{}{"enabled": true, "position": "top-left"}},
+		}},
+		{"arrays of tables", "[[capture.masks]]\nx = 1\n\n[[capture.masks]]\nx = 2\n\n[capture.masks.extra]\ny = 3\n", map[string]interface{}{
+			"capture": map[string]interface{}{"masks": []interface{}{
+				map[string]interface{}{"x": int64(1)},
+				map[string]interface{}{"x": int64(2), "extra": map[string]interface{}{"y": int64(3)}},
+			}},
+		}},
+		{"arrays and inline tables", "a = [1, \"two\", [3]]\nb = [\n  { x = 1, y.z = 2 },\n  {},\n]\n", map[string]interface{}{
+			"a": []interface{}{int64(1), "two", []interface{}{int64(3)}},
+			"b": []interface{}{
+				map[string]interface{}{"x": int64(1), "y": map[string]interface{}{"z": int64(2)}},
+				map[string]interface{}{},
+			},
+		}},
+		{"tabs and CRLF", "[server]\r\n\tport\t=\t80\r\n", map[string]interface{}{
+			"server": map[string]interface{}{"port": int64(80)},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(test.toml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		err  string
+	}{
+		{"multi-line basic string", "a = \"\"\"\ntext\"\"\"\n", "line 1: multi-line strings"},
+		{"multi-line literal string", "a = '''\ntext'''\n", "line 1: multi-line strings"},
+		{"string across lines", "a = \"first\nsecond\"\n", "line 1: unterminated string"},
+		{"date", "a = 2026-12-25\n", "line 1: dates and times"},
+		{"date and time", "a = 2026-12-25T09:00:00Z\n", "line 1: dates and times"},
+		{"time", "a = 17:00:00\n", "line 1: dates and times"},
+		{"duplicate key", "a = 1\nb = 2\na = 3\n", "line 3: duplicate key a"},
+		{"duplicate dotted key", "[server]\ntls.enabled = true\ntls.enabled = false\n", "line 3: duplicate key tls.enabled"},
+		{"table defined twice", "[server]\n[server]\n", "line 2: table [server] is defined twice"},
+		{"table over a value", "server = 1\n[server]\n", "line 2: server is already set to a value"},
+		{"special float", "a = inf\n", `line 1: invalid value "inf"`},
+		{"two values on a line", "a = 1 b = 2\n", "line 1: expected the end of the line"},
+		{"missing value", "a =\n", "line 1:"},
+		{"unclosed inline table", "a = { x = 1\n", "line 1: expected , or }"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(test.toml))
+			if err == nil {
+				t.Fatalf("got %#v, want an error", got)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %q, want %q", err, test.err)
+			}
+		})
+	}
+}
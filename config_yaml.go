@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The YAML subset read here covers what config files need: block mappings
+// and sequences, flow [lists] and {maps} on one line, plain and quoted
+// scalars, and comments. Anchors, tags, block scalars (| and >) and
+// multi-document files are rejected.
+
+var (
+	yamlIntPattern   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloatPattern = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlPlainPattern = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./@+:() -]*$`)
+)
+
+type yamlLine struct {
+	number int // 1-based, for errors
+	indent int
+	text   string // without indentation and comment
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML decodes a YAML document into maps, lists and scalars like
+// encoding/json does; an empty document is an empty mapping
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		number := i + 1
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(text)
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", number)
+		}
+		text = strings.TrimRight(stripYAMLComment(text), " \t")
+		if text == "" {
+			continue
+		}
+		if text == "---" || text == "..." || strings.HasPrefix(text, "%") {
+			if len(p.lines) > 0 && text != "..." {
+				return nil, fmt.Errorf("line %d: only one document is supported", number)
+			}
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{number: number, indent: indent, text: text})
+	}
+
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	value, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected line, check its indentation")
+	}
+	return value, nil
+}
+
+// stripYAMLComment removes a # comment, which starts the line or follows
+// whitespace, outside of quotes
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	number := 0
+	if p.pos < len(p.lines) {
+		number = p.lines[p.pos].number
+	} else if len(p.lines) > 0 {
+		number = p.lines[len(p.lines)-1].number
+	}
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// parseBlock parses the node starting at the current line, indented by indent
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	text := p.lines[p.pos].text
+	if isYAMLSequenceItem(text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok, err := splitYAMLKey(text); err != nil {
+		return nil, p.errorf("%v", err)
+	} else if ok {
+		return p.parseMapping(indent)
+	}
+
+	p.pos++
+	value, err := parseYAMLFlow(text)
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("%v", err)
+	}
+	return value, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	object := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		key, rest, ok, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, dup := object[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+
+		var value interface{}
+		if rest == "" {
+			value, err = p.parseNested(indent, true)
+		} else {
+			value, err = parseYAMLFlow(rest)
+			if err != nil {
+				err = p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return object, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			value, err := p.parseNested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			continue
+		}
+
+		// "- key: value" and "- - item" start a node indented to where
+		// the item's content begins
+		_, _, isKey, err := splitYAMLKey(rest)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if isKey || isYAMLSequenceItem(rest) {
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(rest), text: rest}
+			value, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			continue
+		}
+
+		value, err := parseYAMLFlow(rest)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos++
+		list = append(list, value)
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return list, nil
+}
+
+// parseNested parses the value of a key or list item written on the
+// following lines, or null if there is none. A mapping's value may also be
+// a sequence at the mapping's own indentation.
+func (p *yamlParser) parseNested(indent int, inMapping bool) (interface{}, error) {
+	p.pos++
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent {
+		return p.parseBlock(next.indent)
+	}
+	if inMapping && next.indent == indent && isYAMLSequenceItem(next.text) {
+		return p.parseSequence(indent)
+	}
+	return nil, nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" into its key and the rest of the line;
+// ok is false when text is not a mapping entry
+func splitYAMLKey(text string) (key, rest string, ok bool, err error) {
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+
+	end := 0
+	if text[0] == '"' || text[0] == '\'' {
+		quoted, n, err := scanYAMLQuoted(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		key, end = quoted, n
+		if !strings.HasPrefix(strings.TrimLeft(text[end:], " "), ":") {
+			return "", "", false, nil
+		}
+		end += strings.Index(text[end:], ":")
+	} else {
+		for end = strings.IndexByte(text, ':'); end >= 0; end = nextIndex(text, ':', end) {
+			if end+1 == len(text) || text[end+1] == ' ' {
+				break
+			}
+		}
+		if end < 0 {
+			return "", "", false, nil
+		}
+		key = strings.TrimRight(text[:end], " ")
+	}
+
+	if end+1 < len(text) && text[end+1] != ' ' {
+		return "", "", false, nil
+	}
+	rest = strings.TrimLeft(text[end+1:], " ")
+	if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+		return "", "", false, fmt.Errorf("block scalars (| and >) are not supported, use a quoted string")
+	}
+	if strings.HasPrefix(rest, "&") || strings.HasPrefix(rest, "*") || strings.HasPrefix(rest, "!") {
+		return "", "", false, fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+	return key, rest, true, nil
+}
+
+func nextIndex(s string, c byte, after int) int {
+	i := strings.IndexByte(s[after+1:], c)
+	if i < 0 {
+		return -1
+	}
+	return after + 1 + i
+}
+
+// scanYAMLQuoted reads the quoted scalar text starts with and returns its
+// value and length
+func scanYAMLQuoted(text string) (string, int, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(text[1:i], "''", "'"), i + 1, nil
+			}
+			value, err := unescapeConfigString(text[1:i])
+			return value, i + 1, err
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+// parseYAMLFlow parses a value written on one line: a scalar, a flow
+// sequence [a, b] or a flow mapping {a: 1}
+func parseYAMLFlow(text string) (interface{}, error) {
+	value, n, err := scanYAMLFlow(text, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest := strings.TrimSpace(text[n:]); rest != "" {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+	return value, nil
+}
+
+// scanYAMLFlow reads one value from the start of text and returns it with
+// the number of bytes used. Inside a flow collection plain scalars end at
+// a comma or closing bracket.
+func scanYAMLFlow(text string, inFlow bool) (interface{}, int, error) {
+	i := len(text) - len(strings.TrimLeft(text, " "))
+	if i == len(text) {
+		return nil, i, nil
+	}
+
+	switch text[i] {
+	case '"', '\'':
+		value, n, err := scanYAMLQuoted(text[i:])
+		return value, i + n, err
+	case '[':
+		list := []interface{}{}
+		i++
+		for {
+			i += len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+			if i == len(text) {
+				return nil, 0, fmt.Errorf("unterminated flow sequence")
+			}
+			if text[i] == ']' {
+				return list, i + 1, nil
+			}
+			value, n, err := scanYAMLFlow(text[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			if i, err = skipYAMLFlowSeparator(text, i+n, ']'); err != nil {
+				return nil, 0, err
+			}
+		}
+	case '{':
+		object := map[string]interface{}{}
+		i++
+		for {
+			i += len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+			if i == len(text) {
+				return nil, 0, fmt.Errorf("unterminated flow mapping")
+			}
+			if text[i] == '}' {
+				return object, i + 1, nil
+			}
+			key, n, err := scanYAMLFlow(text[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				name = fmt.Sprint(key)
+			}
+			i += n
+			i += len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+			if i == len(text) || text[i] != ':' {
+				return nil, 0, fmt.Errorf("expected : after key %q in flow mapping", name)
+			}
+			value, n, err := scanYAMLFlow(text[i+1:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			if _, dup := object[name]; dup {
+				return nil, 0, fmt.Errorf("duplicate key %q", name)
+			}
+			object[name] = value
+			if i, err = skipYAMLFlowSeparator(text, i+1+n, '}'); err != nil {
+				return nil, 0, err
+			}
+		}
+	case '&', '*', '!', '|', '>':
+		return nil, 0, fmt.Errorf("%q is not supported", text[i])
+	}
+
+	end := len(text)
+	if inFlow {
+		for j := i; j < len(text); j++ {
+			if c := text[j]; c == ',' || c == ']' || c == '}' || (c == ':' && (j+1 == len(text) || text[j+1] == ' ')) {
+				end = j
+				break
+			}
+		}
+	}
+	return resolveYAMLScalar(strings.TrimRight(text[i:end], " ")), end, nil
+}
+
+func skipYAMLFlowSeparator(text string, i int, closing byte) (int, error) {
+	i += len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+	if i < len(text) && text[i] == ',' {
+		return i + 1, nil
+	}
+	if i < len(text) && text[i] == closing {
+		return i, nil
+	}
+	return 0, fmt.Errorf("expected , or %c", closing)
+}
+
+// resolveYAMLScalar gives a plain scalar its type under the YAML core
+// schema: null, a boolean, a number or else a string
+func resolveYAMLScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlIntPattern.MatchString(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	}
+	if yamlFloatPattern.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// writeYAMLObject writes the fields of object as a block mapping
+func writeYAMLObject(buf *bytes.Buffer, object orderedObject, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for _, field := range object {
+		buf.WriteString(pad + yamlScalar(field.key) + ":")
+		switch value := field.value.(type) {
+		case orderedObject:
+			if len(value) == 0 {
+				buf.WriteString(" {}\n")
+				continue
+			}
+			buf.WriteString("\n")
+			writeYAMLObject(buf, value, indent+2)
+		case []interface{}:
+			if len(value) == 0 {
+				buf.WriteString(" []\n")
+				continue
+			}
+			buf.WriteString("\n")
+			writeYAMLList(buf, value, indent+2)
+		default:
+			buf.WriteString(" " + yamlScalar(value) + "\n")
+		}
+	}
+}
+
+func writeYAMLList(buf *bytes.Buffer, list []interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for _, item := range list {
+		switch value := item.(type) {
+		case orderedObject:
+			if len(value) == 0 {
+				buf.WriteString(pad + "- {}\n")
+				continue
+			}
+			// The first field goes on the line of the dash
+			var item bytes.Buffer
+			writeYAMLObject(&item, value, indent+2)
+			buf.WriteString(pad + "- ")
+			buf.Write(item.Bytes()[indent+2:])
+		case []interface{}:
+			if len(value) == 0 {
+				buf.WriteString(pad + "- []\n")
+				continue
+			}
+			buf.WriteString(pad + "-\n")
+			writeYAMLList(buf, value, indent+2)
+		default:
+			buf.WriteString(pad + "- " + yamlScalar(value) + "\n")
+		}
+	}
+}
+
+// yamlScalar writes a JSON scalar token, quoting strings that would
+// otherwise read back as something else
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		// Only strings starting with a letter are left plain: YAML 1.1
+		// readers take 17:00 for the number 1020 and 2026-12-25 for a date,
+		// and yes, no, on and off for booleans
+		switch strings.ToLower(v) {
+		case "yes", "no", "on", "off", "y", "n":
+			return quoteConfigString(v)
+		}
+		if yamlPlainPattern.MatchString(v) && !strings.HasSuffix(v, " ") && !strings.HasSuffix(v, ":") && !strings.Contains(v, ": ") {
+			if _, ok := resolveYAMLScalar(v).(string); ok {
+				return v
+			}
+		}
+		return quoteConfigString(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want interface{}
+	}{
+		{"empty document", "# nothing here\n", map[string]interface{}{}},
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: null\ne: ~\nf: text with spaces\ng: 17:00\nh: 2026-12-25\n", map[string]interface{}{
+			"a": int64(1), "b": -2.5, "c": true, "d": nil, "e": nil, "f": "text with spaces", "g": "17:00", "h": "2026-12-25",
+		}},
+		{"comments", "# header\na: 1 # trailing\nb: 'not # a comment'\nc: a#b\n", map[string]interface{}{
+			"a": int64(1), "b": "not # a comment", "c": "a#b",
+		}},
+		{"double-quoted escapes", `a: "tab\there \"quoted\" \\ \u00e9 \U0001F600"` + "\n", map[string]interface{}{
+			"a": "tab\there \"quoted\" \\ é 😀",
+		}},
+		{"single-quoted", "a: 'it''s \\n'\n", map[string]interface{}{"a": `it's \n`}},
+		{"quoted keys", "\"a b\": 1\n'c:d': 2\n", map[string]interface{}{"a b": int64(1), "c:d": int64(2)}},
+		{"nested mappings", "server:\n  host: 0.0.0.0\n  tls:\n    enabled: true\nport: 80\n", map[string]interface{}{
+			"server": map[string]interface{}{"host": "0.0.0.0", "tls": map[string]interface{}{"enabled": true}},
+			"port":   int64(80),
+		}},
+		{"sequences", "a:\n  - 1\n  - two\nb:\n- x\n- - y\n  - z\n", map[string]interface{}{
+			"a": []interface{}{int64(1), "two"},
+			"b": []interface{}{"x", []interface{}{"y", "z"}},
+		}},
+		{"sequence of mappings", "masks:\n  - x: 1\n    y: 2\n  - {x: 3, y: 4}\n", map[string]interface{}{
+			"masks": []interface{}{
+				map[string]interface{}{"x": int64(1), "y": int64(2)},
+				map[string]interface{}{"x": int64(3), "y": int64(4)},
+			},
+		}},
+		{"flow collections", "a: [1, \"two, three\", [4], {}]\nb: {x: 1, 'y': [a, b], z: {w: null}}\nc: []\n", map[string]interface{}{
+			"a": []interface{}{int64(1), "two, three", []interface{}{int64(4)}, map[string]interface{}{}},
+			"b": map[string]interface{}{"x": int64(1), "y": []interface{}{"a", "b"}, "z": map[string]interface{}{"w": nil}},
+			"c": []interface{}{},
+		}},
+		{"document markers", "---\na: 1\n...\n", map[string]interface{}{"a": int64(1)}},
+		{"key without a value", "a:\nb: 1\n", map[string]interface{}{"a": nil, "b": int64(1)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"literal block scalar", "a: |\n  text\n", "line 1: block scalars"},
+		{"folded block scalar", "a: >\n  text\n", "line 1: block scalars"},
+		{"multi-line string", "a: \"first\n  second\"\n", "line 1: unterminated quoted string"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: duplicate key "a"`},
+		{"duplicate flow key", "a: {x: 1, x: 2}\n", `line 1: duplicate key "x"`},
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs are not allowed"},
+		{"anchor", "a: &base 1\n", "line 1: anchors, aliases and tags"},
+		{"tag", "a: !!str 1\n", "line 1: anchors, aliases and tags"},
+		{"second document", "a: 1\n---\nb: 2\n", "line 2: only one document"},
+		{"bad indentation", "a:\n    b: 1\n  c: 2\n", "line 3:"},
+		{"unterminated flow sequence", "a: [1,\n", "line 1: unterminated flow sequence"},
+		{"unclosed flow sequence", "a: [1, 2\n", "line 1: expected , or ]"},
+		{"invalid escape", `a: "\q"` + "\n", "line 1: unsupported escape sequence"},
+		{"text after a value", "a: 'x' y\n", "line 1: unexpected"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(test.yaml))
+			if err == nil {
+				t.Fatalf("got %#v, want an error", got)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %q, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+// TestYAMLScalarQuoting checks that strings which YAML 1.1 readers would
+// take for numbers, times, dates or booleans are written quoted
+func TestYAMLScalarQuoting(t *testing.T) {
+	tests := []struct {
+		value string
+		plain bool
+	}{
+		{"realtime", true},
+		{"Europe/Berlin", true},
+		{"certs/server.pem", true},
+		{"17:00", false},
+		{"09:00", false},
+		{"2026-12-25", false},
+		{"1_000", false},
+		{"0x1F", false},
+		{"1e3", false},
+		{".inf", false},
+		{"5s", false},
+		{"yes", false},
+		{"Off", false},
+		{"true", false},
+		{"null", false},
+		{"~", false},
+		{"", false},
+		{"#FF0000", false},
+		{"a: b", false},
+		{"trailing ", false},
+		{"-leading", false},
+	}
+
+	for _, test := range tests {
+		written := yamlScalar(test.value)
+		if plain := written == test.value; plain != test.plain {
+			t.Errorf("%q written as %s", test.value, written)
+		}
+		got, err := parseYAMLFlow(written)
+		if err != nil || got != test.value {
+			t.Errorf("%q written as %s reads back as %#v, %v", test.value, written, got, err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// currentConfigVersion is the schema version of the settings this program
+// reads. Files without a version are version 1, the layout used before the
+// version field was introduced. A schema change that renames or moves a
+// setting has to raise it and convert older files in checkConfigVersion.
+const currentConfigVersion = 2
+
+type configFormat string
+
+const (
+	formatJSON configFormat = "json"
+	formatYAML configFormat = "yaml"
+	formatTOML configFormat = "toml"
+)
+
+// configFormatFor picks the format of a config file from its extension;
+// anything other than .yaml, .yml and .toml is JSON
+func configFormatFor(filename string) configFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// decodeConfigFile converts the contents of a config file, in the format
+// given by its name, to JSON of the current schema version
+func decodeConfigFile(filename string, data []byte) ([]byte, error) {
+	var tree interface{}
+	var err error
+	switch configFormatFor(filename) {
+	case formatYAML:
+		tree, err = parseYAML(data)
+	case formatTOML:
+		tree, err = parseTOML(data)
+	default:
+		tree, err = parseJSONTree(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	object, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to parse config: settings must be in an object at the top level")
+	}
+	if err := checkConfigVersion(object); err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	return data, nil
+}
+
+// parseJSONTree decodes a JSON document keeping numbers as written
+func parseJSONTree(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid data after the top-level value")
+	}
+	return tree, nil
+}
+
+// encodeConfigFile writes config in the format given by filename
+func encodeConfigFile(config *Config, filename string) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	format := configFormatFor(filename)
+	if format == formatJSON {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	tree, err := decodeOrdered(decoder)
+	if err != nil {
+		return nil, err
+	}
+	object, _ := tree.(orderedObject)
+
+	var buf bytes.Buffer
+	if format == formatYAML {
+		writeYAMLObject(&buf, object, 0)
+	} else if err := writeTOMLTable(&buf, object, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkConfigVersion checks the schema version of a decoded config file and
+// sets it to currentConfigVersion. Version 2 added only the version field to
+// the version 1 layout, so older files are read as they are.
+func checkConfigVersion(tree map[string]interface{}) error {
+	key := "version"
+	for name := range tree {
+		if strings.EqualFold(name, key) {
+			key = name
+		}
+	}
+
+	version := 1
+	if value, ok := tree[key]; ok {
+		n, ok := wholeNumber(value)
+		if !ok {
+			return ValidationErrors{{Field: "version", Message: "must be a whole number"}}
+		}
+		version = n
+	}
+	switch {
+	case version < 1:
+		return ValidationErrors{{Field: "version", Message: "must be >= 1"}}
+	case version > currentConfigVersion:
+		return ValidationErrors{{Field: "version", Message: fmt.Sprintf("schema version %d is newer than this program supports (%d)", version, currentConfigVersion)}}
+	}
+	delete(tree, key)
+	tree["version"] = currentConfigVersion
+	return nil
+}
+
+func wholeNumber(value interface{}) (int, bool) {
+	switch n := value.(type) {
+	case json.Number:
+		i, err := strconv.Atoi(n.String())
+		return i, err == nil
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), n == math.Trunc(n) && math.Abs(n) < 1<<31
+	}
+	return 0, false
+}
+
+// orderedObject is a JSON object with its keys in document order, so that
+// YAML and TOML files list the settings in the same order as JSON ones
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered reads the next value from decoder as an orderedObject,
+// []interface{} or scalar token
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := orderedObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, orderedField{key: key.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = decoder.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// quoteConfigString writes s as a double-quoted string that YAML and TOML
+// both read back unchanged
+func quoteConfigString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unescapeConfigString resolves the escapes YAML and TOML share in the body
+// of a double-quoted string
+func unescapeConfigString(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("unterminated escape sequence")
+		}
+		switch s[i] {
+		case '"', '\\', '/':
+			b.WriteByte(s[i])
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'u', 'U':
+			size := 4
+			if s[i] == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				return "", fmt.Errorf("invalid escape sequence \\%s", s[i:])
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid escape sequence \\%s", s[i:i+1+size])
+			}
+			b.WriteRune(rune(code))
+			i += size
+		default:
+			return "", fmt.Errorf("unsupported escape sequence \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripConfig has a value in every kind of setting a config file holds
+func roundTripConfig() *Config {
+	config := DefaultConfig()
+	config.Server.Host = "0.0.0.0"
+	config.Capture.Interval = 1500 * time.Millisecond
+	config.Capture.Region = &RegionConfig{X: -1920, Y: 0, Width: 800, Height: 600}
+	config.Capture.Masks = []MaskConfig{
+		{Name: "mail \"inbox\"", X: 10, Y: 20, Width: 300, Height: 200, Style: "blur", Size: 8},
+		{Name: "chat: private", X: 0, Y: 0, Width: 50, Height: 50, Color: "#FF0000"},
+	}
+	config.Capture.Schedule = &ScheduleConfig{
+		TimeZone: "Europe/Berlin",
+		Windows:  []ScheduleRule{{Days: []string{"mon", "fri"}, Start: "09:00", End: "17:00"}},
+		Quiet:    []ScheduleRule{{Start: "12:00", End: "13:00"}},
+		Holidays: []string{"2026-12-25", "2027-01-01"},
+	}
+	config.Auth.Users = []UserConfig{{Username: "yes", PasswordHash: "pbkdf2-sha256$600000$c2FsdA$a2V5", Role: "admin"}}
+	config.Webhooks.Targets = []WebhookConfig{{URL: "https://example.com/hook?a=1#x", Secret: "s3cret\twith\ttabs", Events: []string{"change"}}}
+	return config
+}
+
+func TestConfigFileRoundTrip(t *testing.T) {
+	want := roundTripConfig()
+
+	for _, filename := range []string{"config.json", "config.yaml", "config.toml"} {
+		t.Run(filename, func(t *testing.T) {
+			encoded, err := encodeConfigFile(want, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := decodeConfigFile(filename, encoded)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, encoded)
+			}
+			got, err := parseConfig(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read back differently:\n%s", encoded)
+			}
+		})
+	}
+
+	// Other YAML readers take plain 17:00 for a number and 2026-12-25 for a date
+	encoded, err := encodeConfigFile(want, "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`end: "17:00"`, `- "2026-12-25"`, `username: "yes"`, `interval: "1.5s"`, "time_zone: Europe/Berlin"} {
+		if !strings.Contains(string(encoded), line) {
+			t.Errorf("YAML has no line %s:\n%s", line, encoded)
+		}
+	}
+}
+
+func TestConfigFileVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"current", `{"version": 2}`, ""},
+		{"before versions", `{"server": {"port": 9000}}`, ""},
+		{"version 1", "version: 1\n", ""},
+		{"newer", `{"version": 3}`, "schema version 3 is newer"},
+		{"zero", "version = 0\n", "must be >= 1"},
+		{"not a number", `{"version": "2"}`, "must be a whole number"},
+		{"fraction", `{"version": 1.5}`, "must be a whole number"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := "config.json"
+			if strings.HasPrefix(test.file, "version:") {
+				filename = "config.yaml"
+			} else if strings.HasPrefix(test.file, "version =") {
+				filename = "config.toml"
+			}
+
+			data, err := decodeConfigFile(filename, []byte(test.file))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := parseConfig(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Version != currentConfigVersion {
+				t.Errorf("version %d, want %d", config.Version, currentConfigVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const configPollInterval = 2 * time.Second
+
+// configWatcher notices when the config file changes on disk. The file is
+// polled, and only read when its size or modification time changed; the
+// hash of its contents then tells edits apart from a touch.
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func newConfigWatcher(path string) *configWatcher {
+	w := &configWatcher{path: path}
+	// The file as it is now was loaded at startup
+	w.changed()
+	return w
+}
+
+// changed returns the file's contents if they differ from the last time
+func (w *configWatcher) changed() ([]byte, bool, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return nil, false, nil
+	}
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, false, err
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	hash := sha256.Sum256(data)
+	if hash == w.hash {
+		return nil, false, nil
+	}
+	w.hash = hash
+	return data, true, nil
+}
+
+// watchConfig reloads the config file whenever it changes, until stop is
+// closed. An invalid file is reported once and the configuration in effect
+// is kept.
+func (s *Server) watchConfig(stop <-chan struct{}) {
+	watcher := newConfigWatcher(s.configFile)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		data, changed, err := watcher.changed()
+		if err == nil && changed {
+			err = s.reloadConfig(data)
+		}
+		if err != nil {
+			if err.Error() != lastErr {
+				fmt.Printf("Config reload: %v; keeping the current configuration\n", err)
+			}
+			lastErr = err.Error()
+			continue
+		}
+		lastErr = ""
+	}
+}
+
+// checkRestartOnly rejects a reload that changes a component that is only
+// built at startup
+func checkRestartOnly(oldConfig, newConfig *Config) error {
+	changes, err := diffConfig(oldConfig, newConfig)
+	if err != nil {
+		return fmt.Errorf("failed to compare config: %v", err)
+	}
+	var fields []string
+	for _, change := range changes {
+		if changesPrefix([]ConfigChange{change}, restartOnlyComponents...) {
+			fields = append(fields, change.Field)
+		}
+	}
+	if len(fields) > 0 {
+		return fmt.Errorf("%s changed, which needs a restart", strings.Join(fields, ", "))
+	}
+	return nil
+}
+
+// reloadConfig validates the new contents of the config file and applies
+// them the same way as POST /config. Environment variables and flags still
+// take precedence over the file. Changes to the webhooks, the audit log or
+// the archive reject the whole reload; the users and tokens are replaced.
+func (s *Server) reloadConfig(data []byte) error {
+	params := map[string]interface{}{"file": s.configFile}
+
+	data, err := decodeConfigFile(s.configFile, data)
+	var newConfig *Config
+	var problems ValidationErrors
+	if err == nil {
+		newConfig, problems, err = readConfig(data, s.overrides)
+	}
+	if err == nil && len(problems) > 0 {
+		err = problems
+	}
+	if err != nil {
+		s.recordAuditFrom("", "", auditActionReload, params, err)
+		return err
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	oldConfig := s.currentConfig()
+	if err := checkRestartOnly(oldConfig, newConfig); err != nil {
+		s.recordAuditFrom("", "", auditActionReload, params, err)
+		return err
+	}
+	changes, err := s.applyConfig(oldConfig, newConfig)
+	if err != nil {
+		s.recordAuditFrom("", "", auditActionReload, params, err)
+		return err
+	}
+	// Saving with POST /config?save=true also changes the file
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fields := make([]string, len(changes))
+	for i, change := range changes {
+		fields[i] = change.Field
+	}
+	fmt.Printf("Reloaded %s: %s changed\n", s.configFile, strings.Join(fields, ", "))
+	if restartRequired(changes) {
+		fmt.Println("Some of the changes take effect after a restart")
+	}
+
+	params["changes"] = changes
+	s.recordAuditFrom("", "", auditActionReload, params, nil)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func marshalConfig(t *testing.T, config *Config) []byte {
+	t.Helper()
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReloadReplacesUsersAndTokens(t *testing.T) {
+	alice, bob := newTestUser(t, "alice", roleAdmin), newTestUser(t, "bob", roleViewer)
+	token, tokenHash, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ts := newTestServer(t, func(config *Config) {
+		config.Auth.Enabled = true
+		config.Auth.Users = []UserConfig{alice.UserConfig, bob.UserConfig}
+		config.Auth.Tokens = []TokenConfig{{Name: "script", TokenHash: tokenHash}}
+	})
+	s.configFile = "config.json"
+
+	aliceSession := login(t, ts, alice.Username, alice.password)
+	bobSession := login(t, ts, bob.Username, bob.password)
+	if getStatus(t, ts, "/config", bobSession, "") != http.StatusOK || getStatus(t, ts, "/config", nil, token) != http.StatusOK {
+		t.Fatal("bob or the token cannot sign in before the reload")
+	}
+
+	// The file drops bob and the token
+	config, err := copyConfig(s.currentConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Auth.Users = []UserConfig{alice.UserConfig}
+	config.Auth.Tokens = nil
+	if err := s.reloadConfig(marshalConfig(t, config)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		status int
+	}{
+		{"session of a kept user", aliceSession, "", http.StatusOK},
+		{"session of a removed user", bobSession, "", http.StatusUnauthorized},
+		{"removed token", nil, token, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if status := getStatus(t, ts, "/config", test.cookie, test.token); status != test.status {
+			t.Errorf("%s: %d, want %d", test.name, status, test.status)
+		}
+	}
+	resp, err := noRedirects.PostForm(ts.URL+"/login", url.Values{"username": {bob.Username}, "password": {bob.password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("removed user logs in: %s", resp.Status)
+	}
+
+	// A new password ends the user's sessions too
+	alice = newTestUser(t, "alice", roleAdmin)
+	config.Auth.Users = []UserConfig{alice.UserConfig}
+	if err := s.reloadConfig(marshalConfig(t, config)); err != nil {
+		t.Fatal(err)
+	}
+	if status := getStatus(t, ts, "/config", aliceSession, ""); status != http.StatusUnauthorized {
+		t.Errorf("session after a password change: %d, want 401", status)
+	}
+}
+
+func TestReloadRejectsRestartOnlyChanges(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	s.configFile = "config.json"
+	before := s.currentConfig()
+
+	for _, change := range []func(*Config){
+		func(config *Config) {
+			config.Webhooks.Enabled = true
+			config.Webhooks.Targets = []WebhookConfig{{URL: "http://127.0.0.1:1/hook"}}
+		},
+		func(config *Config) { config.Audit.Enabled = true },
+		func(config *Config) { config.Archive.Enabled = true },
+	} {
+		config, err := copyConfig(before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change(config)
+		config.Capture.Interval *= 2 // applied live, unless the reload is rejected
+
+		err = s.reloadConfig(marshalConfig(t, config))
+		if err == nil || !strings.Contains(err.Error(), "needs a restart") {
+			t.Errorf("reload: %v, want it rejected", err)
+		}
+		if s.currentConfig() != before {
+			t.Error("rejected reload changed the configuration")
+		}
+	}
+}
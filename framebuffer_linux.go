@@ -0,0 +1,41 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fbioGetVScreenInfo = 0x4600
+	fbioGetFScreenInfo = 0x4602
+)
+
+// fbQueryDevice reads the variable screen info and the line length from the
+// fixed screen info of a framebuffer device
+func fbQueryDevice(f *os.File) (fbVarScreenInfo, int, error) {
+	var vbuf [160]byte
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fbioGetVScreenInfo, uintptr(unsafe.Pointer(&vbuf[0]))); errno != 0 {
+		return fbVarScreenInfo{}, 0, errno
+	}
+
+	vinfo, err := parseFbVarScreenInfo(vbuf[:])
+	if err != nil {
+		return fbVarScreenInfo{}, 0, err
+	}
+
+	// struct fb_fix_screeninfo: char id[16]; unsigned long smem_start;
+	// __u32 smem_len, type, type_aux, visual; __u16 xpanstep, ypanstep,
+	// ywrapstep; __u32 line_length; ...
+	var fbuf [128]byte
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fbioGetFScreenInfo, uintptr(unsafe.Pointer(&fbuf[0]))); errno != 0 {
+		return fbVarScreenInfo{}, 0, errno
+	}
+	lineLengthOffset := 16 + int(unsafe.Sizeof(uintptr(0))) + 4*4 + 3*2
+	lineLengthOffset += pad4(lineLengthOffset)
+
+	return vinfo, int(binary.LittleEndian.Uint32(fbuf[lineLengthOffset:])), nil
+}
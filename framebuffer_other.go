@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func fbQueryDevice(f *os.File) (fbVarScreenInfo, int, error) {
+	return fbVarScreenInfo{}, 0, fmt.Errorf("framebuffer devices are only supported on Linux, current OS: %s", runtime.GOOS)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FrameInfo describes a frame kept in the history
+type FrameInfo struct {
+	ID          uint64    `json:"id"`
+	Time        time.Time `json:"time"`
+	Size        int       `json:"size"`
+	ContentType string    `json:"content_type"`
+}
+
+type historyFrame struct {
+	FrameInfo
+	data []byte
+}
+
+// frameHistory keeps the most recent encoded frames in memory, bounded both
+// by count and by total size. The oldest frames are dropped first. IDs
+// increase monotonically and are never reused, so clients can poll for
+// frames newer than the last one they saw.
+type frameHistory struct {
+	mu        sync.RWMutex
+	maxFrames int
+	maxBytes  int
+	frames    []*historyFrame // oldest first
+	bytes     int
+	nextID    uint64
+}
+
+func newFrameHistory(maxFrames, maxBytes int) *frameHistory {
+	return &frameHistory{
+		maxFrames: maxFrames,
+		maxBytes:  maxBytes,
+		nextID:    1,
+	}
+}
+
+// add records a frame and returns its ID, or 0 if history is disabled or the
+// frame alone exceeds the size limit
+func (h *frameHistory) add(data []byte, contentType string, captured time.Time) uint64 {
+	if h.maxFrames <= 0 || (h.maxBytes > 0 && len(data) > h.maxBytes) {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	frame := &historyFrame{
+		FrameInfo: FrameInfo{
+			ID:          h.nextID,
+			Time:        captured,
+			Size:        len(data),
+			ContentType: contentType,
+		},
+		data: data,
+	}
+	h.nextID++
+
+	h.frames = append(h.frames, frame)
+	h.bytes += len(data)
+	for len(h.frames) > h.maxFrames || (h.maxBytes > 0 && h.bytes > h.maxBytes) {
+		h.bytes -= h.frames[0].Size
+		h.frames[0] = nil
+		h.frames = h.frames[1:]
+	}
+
+	return frame.ID
+}
+
+// list returns the frames newer than since, oldest first
+func (h *frameHistory) list(since uint64) []FrameInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	infos := make([]FrameInfo, 0, len(h.frames))
+	for _, frame := range h.frames {
+		if frame.ID > since {
+			infos = append(infos, frame.FrameInfo)
+		}
+	}
+	return infos
+}
+
+func (h *frameHistory) get(id uint64) (*historyFrame, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// IDs are consecutive within the buffer, so the frame can be indexed directly
+	if len(h.frames) == 0 || id < h.frames[0].ID {
+		return nil, false
+	}
+	index := id - h.frames[0].ID
+	if index >= uint64(len(h.frames)) {
+		return nil, false
+	}
+	return h.frames[index], true
+}
+
+func (h *frameHistory) usage() (count, bytes int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.frames), h.bytes
+}
+
+// handleFrames lists the frames kept in history, optionally only those newer
+// than the since parameter
+func (s *Server) handleFrames(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	frames := s.history.list(since)
+	count, bytes := s.history.usage()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"frames":     frames,
+		"count":      count,
+		"bytes":      bytes,
+		"max_frames": s.history.maxFrames,
+		"max_bytes":  s.history.maxBytes,
+	})
+}
+
+// handleFrame returns a single frame from history
+func (s *Server) handleFrame(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid frame id", http.StatusBadRequest)
+		return
+	}
+
+	frame, ok := s.history.get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Frame %d not found (it may have been evicted)", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", frame.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(frame.Size))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Last-Modified", frame.Time.UTC().Format(http.TimeFormat))
+	w.Write(frame.data)
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+    "bufio"
     "flag"
     "fmt"
     "log"
     "os"
     "os/signal"
     "runtime"
+    "strings"
     "syscall"
+    "time"
 )
 
 const (
@@ -21,7 +24,15 @@ func main() {
         showHelp   = flag.Bool("help", false, "显示帮助信息")
         showVersion = flag.Bool("version", false, "显示版本信息")
         testMode   = flag.Bool("test", false, "测试截图功能")
+        hashPass   = flag.Bool("hash-password", false, "从标准输入读取密码并输出其哈希值，用于 auth.users")
+        newToken   = flag.Bool("new-token", false, "生成新的 API 令牌及其哈希值，用于 auth.tokens")
+        verifyAudit = flag.String("verify-audit", "", "校验审计日志文件的哈希链")
+        checkOnly  = flag.Bool("check-config", false, "检查配置文件，列出所有错误后退出")
+        printOnly  = flag.Bool("print-config", false, "输出合并后的最终配置及每项的来源后退出")
+        pauseNow   = flag.Bool("pause", false, "暂停本机正在运行的服务器的截图以保护隐私，可在其后指定自动恢复的时长，如 -pause 30m")
+        resumeNow  = flag.Bool("resume", false, "恢复被 -pause 暂停的截图")
     )
+    flagOverrides := registerConfigFlags(flag.CommandLine)
     flag.Parse()
 
     if *showHelp {
@@ -35,23 +46,120 @@ func main() {
         return
     }
 
-    if *testMode {
-        testScreenshot()
+    if *hashPass {
+        printPasswordHash()
+        return
+    }
+
+    if *newToken {
+        token, hash, err := newAPIToken()
+        if err != nil {
+            log.Fatalf("生成令牌失败: %v", err)
+        }
+        fmt.Printf("令牌 (请求头 Authorization: Bearer <令牌>): %s\n", token)
+        fmt.Printf("token_hash: %s\n", hash)
+        return
+    }
+
+    if *verifyAudit != "" {
+        count, lastHash, err := verifyAuditLog(*verifyAudit)
+        if err != nil {
+            fmt.Printf("审计日志校验失败 (前 %d 条记录有效): %v\n", count, err)
+            os.Exit(1)
+        }
+        fmt.Printf("审计日志校验通过: 共 %d 条记录\n", count)
+        if lastHash != "" {
+            fmt.Printf("最后一条记录的哈希: %s\n", lastHash)
+        }
+        return
+    }
+
+    // Precedence: flags > environment variables > config file > defaults
+    overrides := append(envOverrides(), *flagOverrides...)
+
+    if *checkOnly {
+        if !checkConfigFile(*configFile, overrides) {
+            os.Exit(1)
+        }
         return
     }
 
-    if runtime.GOOS != "windows" {
-        log.Printf("警告: 当前运行在 %s 平台，截图功能仅在 Windows 平台可用", runtime.GOOS)
+    if *printOnly {
+        if !printEffectiveConfig(*configFile, overrides) {
+            os.Exit(1)
+        }
+        return
+    }
+
+    if *pauseNow || *resumeNow {
+        if !sendPauseCommand(*configFile, overrides, *resumeNow, flag.Args()) {
+            os.Exit(1)
+        }
+        return
     }
 
-    config, err := LoadConfig(*configFile)
+    config, err := LoadConfig(*configFile, overrides)
+    if problems, ok := err.(ValidationErrors); ok {
+        lines := make([]string, len(problems))
+        for i, problem := range problems {
+            lines[i] = "  " + problem.Error()
+        }
+        log.Fatalf("配置文件有误 (可使用 -check-config 检查):\n%s", strings.Join(lines, "\n"))
+    }
     if err != nil {
         log.Fatalf("加载配置文件失败: %v", err)
     }
 
     validateConfig(config)
 
-    server := NewServer(config, *configFile)
+    capturer, err := newCapturer(config.Capture)
+    if err != nil {
+        log.Fatalf("初始化截图后端失败: %v", err)
+    }
+
+    if *testMode {
+        testScreenshot(newMaskingCapturer(capturer, func() []MaskConfig {
+            return config.Capture.Masks
+        }))
+        return
+    }
+
+    if _, ok := capturer.(*unsupportedCapturer); ok {
+        log.Printf("警告: 当前运行在 %s 平台且未设置 DISPLAY、也没有 /dev/fb0，原生截图功能不可用，可将 capture.source 设为 \"synthetic\" 使用测试图案", runtime.GOOS)
+    }
+
+    server := NewServer(config, *configFile, capturer)
+    server.overrides = overrides
+    if config.Archive.Enabled {
+        archive, err := openArchive(config.Archive)
+        if err != nil {
+            log.Fatalf("打开截图归档失败: %v", err)
+        }
+        server.archive = archive
+    }
+    if config.Auth.Enabled {
+        auth, err := newAuthenticator(config.Auth)
+        if err != nil {
+            log.Fatalf("初始化身份验证失败: %v", err)
+        }
+        server.auth.Store(auth)
+    } else if config.Server.Host != "127.0.0.1" && config.Server.Host != "localhost" {
+        log.Printf("警告: 未启用身份验证，网络中的任何人都可以查看屏幕并操作这台电脑，请配置 auth 部分")
+    }
+    if config.Audit.Enabled {
+        audit, err := openAuditLog(config.Audit)
+        if err != nil {
+            log.Fatalf("打开审计日志失败: %v", err)
+        }
+        server.audit = audit
+    }
+    if config.Webhooks.Enabled && len(config.Webhooks.Targets) > 0 {
+        webhooks, err := newWebhookNotifier(config.Webhooks)
+        if err != nil {
+            log.Fatalf("初始化 Webhook 失败: %v", err)
+        }
+        server.webhooks = webhooks
+    }
 
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -77,14 +185,37 @@ func printHelp() {
 
 选项:
   -config string
-        配置文件路径 (默认: %s)
+        配置文件路径，按扩展名识别格式: .json、.yaml/.yml 或 .toml (默认: %s)
   -test
         测试截图功能
+  -hash-password
+        从标准输入读取密码，输出用于 auth.users 的 password_hash
+  -new-token
+        生成 API 令牌，输出令牌及用于 auth.tokens 的 token_hash
+  -verify-audit string
+        校验审计日志文件的哈希链，检查记录是否被篡改
+  -check-config
+        检查配置文件，一次列出所有错误 (字段路径及原因) 后退出
+  -print-config
+        输出合并后的最终配置，并注明每项来自默认值、配置文件、环境变量还是命令行参数
+  -pause [时长]
+        暂停本机正在运行的服务器的截图以保护隐私，远程查看者只会看到"已暂停"的占位图；
+        可指定自动恢复的时长，如 -pause 30m，否则一直暂停到 -resume (需放在其他选项之后)
+  -resume
+        恢复被 -pause 暂停的截图
+  -<字段路径> value
+        覆盖配置文件中的对应字段，例如 -server.port 8080、-capture.interval 2s、
+        -capture.region.width 800；列表可写作 a,b,c，对象列表使用 JSON
   -version
         显示版本信息
   -help
         显示此帮助信息
 
+环境变量:
+  每个字段也可以用 DSC_ 加大写的字段路径 (点换成下划线) 覆盖，例如
+  DSC_SERVER_PORT=8080、DSC_CAPTURE_COMPRESSION_FORMAT=jpeg。
+  优先级: 命令行参数 > 环境变量 > 配置文件 > 默认值
+
 配置文件格式 (JSON):
 {
   "server": {
@@ -93,34 +224,70 @@ func printHelp() {
   },
   "capture": {
     "mode": "ondemand",    // "ondemand" 或 "realtime"
-    "interval": "5s"       // 仅在 realtime 模式下使用
+    "interval": "5s",      // 仅在 realtime 模式下使用
+    "source": "native",    // "native"、"x11"、"framebuffer"、"synthetic" 或 "replay"
+    "monitor": 0           // 0 为整个桌面，否则为 /monitors 返回的显示器 ID
   }
 }
 
+截图来源:
+  native      - 本机屏幕 (Windows 使用 GDI，Linux 在设置了 DISPLAY 时使用 X11，否则使用 /dev/fb0)
+  x11         - 直接连接 X 服务器截取根窗口 (capture.x11.display 默认取 $DISPLAY)
+  framebuffer - 读取 Linux 帧缓冲设备 (capture.framebuffer.device 默认 /dev/fb0)，
+                也可读取原始转储文件，此时需设置 width、height 以及 bits_per_pixel 或 format
+  synthetic   - 合成测试图案，可在无显示器的环境中运行
+  replay      - 按文件名顺序循环回放 capture.replay.path 目录 (含子目录) 中的 PNG/JPEG 帧，
+                每个 capture.interval 切换一帧，可用于演示或复现问题
+
 运行模式:
   ondemand  - 按需模式：只有在访问 /last 端点时才截图
   realtime  - 实时模式：按配置的间隔自动截图
 
 API 端点:
   /         - 网页界面
-  /last     - 获取最新截图 (PNG 格式)
+  /last     - 获取最新截图 (默认 PNG 格式，可用 format=jpeg&quality=80 输出 JPEG，
+              未指定 format 时按 Accept 请求头选择；可用 monitor=ID 指定显示器；
+              启用 capture.watermark 时管理员可用 overlay=0 获取不带水印的截图)
+  /stream   - MJPEG 实时视频流 (可用 fps=N 指定帧率，默认 5，最大 30，可直接用于 <img> 标签)
+  /frames   - 列出内存中保留的最近截图 (可用 since=ID 只返回更新的帧)
+  /frames/ID - 获取历史中的某一帧
+  /changes  - 最近一帧与上一帧的变化程度 (score) 及变化区域 (boxes)
+  /archive  - 浏览磁盘归档中的截图 (可用 from=、to= 指定时间范围，支持 RFC 3339 或 YYYY-MM-DD；每页 limit= 条，下一页从 next 开始)
+  /monitors - 列出显示器 (ID、名称、位置、是否主显示器、缩放比例)
+  /schedule - 截图计划状态: 当前是否按计划暂停截图，以及何时恢复
 
 示例:
   %s                           # 使用默认配置启动
   %s -config my.json           # 使用指定配置文件启动
+  %s -config my.yaml           # 使用 YAML 格式的配置文件启动
   %s -test                     # 测试截图功能
-`, version, os.Args[0], defaultConfigFile, os.Args[0], os.Args[0], os.Args[0])
+  %s -pause 30m                # 暂停截图 30 分钟
+`, version, os.Args[0], defaultConfigFile, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 }
 
-func testScreenshot() {
-    fmt.Println("正在测试截图功能...")
-    
-    if runtime.GOOS != "windows" {
-        fmt.Printf("错误: 截图功能仅在 Windows 平台可用，当前平台: %s\n", runtime.GOOS)
-        return
+// printPasswordHash reads a password from the first line of stdin
+func printPasswordHash() {
+    fmt.Fprint(os.Stderr, "密码: ")
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    if err != nil && line == "" {
+        log.Fatalf("读取密码失败: %v", err)
+    }
+    password := strings.TrimRight(line, "\r\n")
+    if password == "" {
+        log.Fatalf("密码不能为空")
     }
+
+    hash, err := hashPassword(password)
+    if err != nil {
+        log.Fatalf("生成密码哈希失败: %v", err)
+    }
+    fmt.Println(hash)
+}
+
+func testScreenshot(capturer Capturer) {
+    fmt.Println("正在测试截图功能...")
     
-    filename, err := SaveScreenshotToFile()
+    filename, err := SaveScreenshotToFile(capturer)
     if err != nil {
         fmt.Printf("截图失败: %v\n", err)
         return
@@ -129,20 +296,133 @@ func testScreenshot() {
     fmt.Printf("截图成功保存至: %s\n", filename)
 }
 
+// validateConfig warns about settings that are valid but probably unwanted;
+// invalid ones are rejected by LoadConfig
 func validateConfig(config *Config) {
-    if config.Server.Port < 1 || config.Server.Port > 65535 {
-        log.Fatalf("无效的端口号: %d", config.Server.Port)
+    if config.Capture.Interval.Seconds() < 1 {
+        log.Printf("警告: 截图间隔过短 (%v)，可能会影响性能", config.Capture.Interval)
+    }
+}
+
+// checkConfigFile implements -check-config: it lists every problem in the
+// config file and reports whether there were none
+func checkConfigFile(filename string, overrides configOverrides) bool {
+    data, err := readConfigFile(filename)
+    if err != nil {
+        fmt.Printf("读取配置文件失败: %v\n", err)
+        return false
     }
     
-    if config.Capture.Mode != "ondemand" && config.Capture.Mode != "realtime" {
-        log.Fatalf("无效的捕获模式: %s，只支持 'ondemand' 或 'realtime'", config.Capture.Mode)
+    _, problems, err := readConfig(data, overrides)
+    if err != nil {
+        fmt.Printf("配置文件 %s 无法解析: %v\n", filename, err)
+        return false
+    }
+    if len(problems) > 0 {
+        fmt.Printf("配置文件 %s 有 %d 处错误:\n", filename, len(problems))
+        for _, problem := range problems {
+            fmt.Printf("  %s\n", problem)
+        }
+        return false
     }
     
-    if config.Capture.Mode == "realtime" && config.Capture.Interval <= 0 {
-        log.Fatalf("实时模式下截图间隔必须大于 0")
+    fmt.Printf("配置文件 %s 检查通过\n", filename)
+    return true
+}
+
+// printEffectiveConfig implements -print-config: it lists every setting with
+// the value in effect and where that value came from
+func printEffectiveConfig(filename string, overrides configOverrides) bool {
+    data, err := readConfigFile(filename)
+    if err != nil {
+        fmt.Printf("读取配置文件失败: %v\n", err)
+        return false
     }
     
-    if config.Capture.Interval.Seconds() < 1 {
-        log.Printf("警告: 截图间隔过短 (%v)，可能会影响性能", config.Capture.Interval)
+    config, problems, err := readConfig(data, overrides)
+    if err != nil {
+        fmt.Printf("配置文件 %s 无法解析: %v\n", filename, err)
+        return false
+    }
+    if err := printConfig(os.Stdout, config, configSources(data, overrides)); err != nil {
+        fmt.Printf("输出配置失败: %v\n", err)
+        return false
+    }
+    if len(problems) > 0 {
+        fmt.Printf("\n配置有 %d 处错误:\n", len(problems))
+        for _, problem := range problems {
+            fmt.Printf("  %s\n", problem)
+        }
+        return false
     }
+    return true
+}
+
+// sendPauseCommand implements -pause and -resume: it asks the server running
+// on this computer to pause capture, for the duration given after -pause or
+// until resumed, or to resume it
+func sendPauseCommand(filename string, overrides configOverrides, resume bool, args []string) bool {
+    var duration time.Duration
+    if resume && len(args) > 0 {
+        fmt.Printf("-resume 不接受参数: %s\n", strings.Join(args, " "))
+        return false
+    }
+    if len(args) > 1 {
+        fmt.Println("-pause 之后只能指定一个时长，其他参数请放在 -pause 之前")
+        return false
+    }
+    if len(args) == 1 {
+        parsed, err := time.ParseDuration(args[0])
+        if err != nil || parsed <= 0 {
+            fmt.Printf("无效的暂停时长 %q，应为正的时长，如 30m 或 1h30m\n", args[0])
+            return false
+        }
+        duration = parsed
+    }
+    
+    data, err := readConfigFile(filename)
+    if err != nil {
+        fmt.Printf("读取配置文件失败: %v\n", err)
+        return false
+    }
+    // Problems elsewhere in the config do not keep the pause control from working
+    config, _, err := readConfig(data, overrides)
+    if err != nil {
+        fmt.Printf("配置文件 %s 无法解析: %v\n", filename, err)
+        return false
+    }
+    if !config.Pause.Enabled {
+        fmt.Println("暂停控制已在配置中关闭 (pause.enabled)")
+        return false
+    }
+    
+    status, err := requestPause(config.Pause, resume, duration)
+    if err != nil {
+        fmt.Printf("无法连接本机的暂停控制 (127.0.0.1:%d)，服务器是否正在运行? %v\n", config.Pause.Port, err)
+        return false
+    }
+    switch {
+    case !status.Paused:
+        fmt.Println("截图已恢复")
+    case status.Until != nil:
+        fmt.Printf("截图已暂停，将于 %s 自动恢复\n", status.Until.Local().Format("2006-01-02 15:04"))
+    default:
+        fmt.Println("截图已暂停，使用 -resume 恢复")
+    }
+    return true
+}
+
+// readConfigFile reads the config file for -check-config and -print-config
+// and converts it to JSON; a missing file stands for the defaults, as when
+// it is created on startup
+func readConfigFile(filename string) ([]byte, error) {
+    data, err := os.ReadFile(filename)
+    if os.IsNotExist(err) {
+        return []byte("{}"), nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    
+    return decodeConfigFile(filename, data)
 }
\ No newline at end of file
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Styles of capture.masks
+const (
+	maskFill     = "fill"
+	maskPixelate = "pixelate"
+	maskBlur     = "blur"
+)
+
+var maskStyles = []string{maskFill, maskPixelate, maskBlur}
+
+const (
+	defaultPixelateSize = 16 // block side in pixels
+	defaultBlurSize     = 12 // blur radius in pixels
+	blurPasses          = 3  // box blur passes, together close to a Gaussian blur
+)
+
+// maskingCapturer hides the privacy masks in every frame the wrapped
+// capturer returns, before anything else sees the pixels, so that no
+// encoding, history, archive, stream or webhook can contain them
+type maskingCapturer struct {
+	Capturer
+	masks func() []MaskConfig // read for every frame so that changes apply at once
+}
+
+func newMaskingCapturer(capturer Capturer, masks func() []MaskConfig) Capturer {
+	return &maskingCapturer{Capturer: capturer, masks: masks}
+}
+
+func (c *maskingCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	screenshot, err := c.Capturer.Capture(region)
+	if err != nil {
+		return nil, err
+	}
+
+	masks := c.masks()
+	if len(masks) == 0 {
+		return screenshot, nil
+	}
+	// The frame starts at the region's corner, unless clamped to the screen
+	origin := image.Point{}
+	if region != nil {
+		origin = image.Pt(max(region.X, 0), max(region.Y, 0))
+	}
+	rects, err := maskRects(c.Capturer, masks)
+	if err != nil {
+		return nil, err // a mask that cannot be placed must not leave its area visible
+	}
+	for i, mask := range masks {
+		applyMask(screenshot, rects[i].Sub(origin), mask)
+	}
+	return screenshot, nil
+}
+
+// Monitors passes the monitor layout of the wrapped capturer through
+func (c *maskingCapturer) Monitors() ([]Monitor, error) {
+	return listMonitors(c.Capturer)
+}
+
+// maskRects places the masks in the coordinates of Capturer.Capture,
+// resolving those given relative to a monitor
+func maskRects(capturer Capturer, masks []MaskConfig) ([]image.Rectangle, error) {
+	rects := make([]image.Rectangle, len(masks))
+	var monitors []Monitor
+	var desktop ScreenRegion
+	for i, mask := range masks {
+		rect := image.Rect(mask.X, mask.Y, mask.X+mask.Width, mask.Y+mask.Height)
+		if mask.Monitor != 0 {
+			if monitors == nil {
+				var err error
+				if monitors, err = listMonitors(capturer); err != nil {
+					return nil, fmt.Errorf("failed to place privacy mask: %v", err)
+				}
+				if desktop, err = capturer.Bounds(); err != nil {
+					return nil, fmt.Errorf("failed to place privacy mask: %v", err)
+				}
+			}
+			monitor, err := findMonitor(monitors, mask.Monitor)
+			if err != nil {
+				return nil, fmt.Errorf("failed to place privacy mask %d: %v", i, err)
+			}
+			rect = rect.Add(image.Pt(monitor.Bounds.X-desktop.X, monitor.Bounds.Y-desktop.Y))
+		}
+		rects[i] = rect
+	}
+	return rects, nil
+}
+
+// applyMask hides rect, in frame coordinates, in the style of mask
+func applyMask(s *Screenshot, rect image.Rectangle, mask MaskConfig) {
+	rect = rect.Intersect(image.Rect(0, 0, s.Width, s.Height))
+	if rect.Empty() {
+		return
+	}
+
+	switch mask.Style {
+	case maskPixelate:
+		size := mask.Size
+		if size <= 0 {
+			size = defaultPixelateSize
+		}
+		pixelate(s, rect, size)
+	case maskBlur:
+		size := mask.Size
+		if size <= 0 {
+			size = defaultBlurSize
+		}
+		for pass := 0; pass < blurPasses; pass++ {
+			boxBlur(s, rect, size, 1, 0)
+			boxBlur(s, rect, size, 0, 1)
+		}
+	default:
+		color, _ := parseMaskColor(mask.Color)
+		fillRect(s, rect, color)
+	}
+}
+
+// fillRect paints rect with a BGRA color
+func fillRect(s *Screenshot, rect image.Rectangle, bgra [4]byte) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := s.Data[(y*s.Width+rect.Min.X)*4 : (y*s.Width+rect.Max.X)*4]
+		for x := 0; x < len(row); x += 4 {
+			copy(row[x:x+4], bgra[:])
+		}
+	}
+}
+
+// pixelate replaces each size x size block of rect with its average color
+func pixelate(s *Screenshot, rect image.Rectangle, size int) {
+	for by := rect.Min.Y; by < rect.Max.Y; by += size {
+		for bx := rect.Min.X; bx < rect.Max.X; bx += size {
+			block := image.Rect(bx, by, bx+size, by+size).Intersect(rect)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					offset := (y*s.Width + x) * 4
+					for c := 0; c < 4; c++ {
+						sum[c] += int(s.Data[offset+c])
+					}
+				}
+			}
+			n := block.Dx() * block.Dy()
+			fillRect(s, block, [4]byte{byte(sum[0] / n), byte(sum[1] / n), byte(sum[2] / n), byte(sum[3] / n)})
+		}
+	}
+}
+
+// boxBlur averages each pixel of rect with its neighbours up to radius away
+// along one axis, (dx, dy) being (1, 0) or (0, 1). Only pixels inside rect
+// are sampled, so nothing outside the mask bleeds in.
+func boxBlur(s *Screenshot, rect image.Rectangle, radius, dx, dy int) {
+	length, lines := rect.Dx(), rect.Dy()
+	if dy == 1 {
+		length, lines = lines, length
+	}
+	line := make([][4]int, length)
+
+	for l := 0; l < lines; l++ {
+		offset := func(i int) int {
+			x, y := rect.Min.X+i*dx+l*dy, rect.Min.Y+i*dy+l*dx
+			return (y*s.Width + x) * 4
+		}
+		for i := range line {
+			o := offset(i)
+			line[i] = [4]int{int(s.Data[o]), int(s.Data[o+1]), int(s.Data[o+2]), int(s.Data[o+3])}
+		}
+
+		// Running sum over the window [i-radius, i+radius], clipped to the line
+		var sum [4]int
+		for i := 0; i <= radius && i < length; i++ {
+			for c := 0; c < 4; c++ {
+				sum[c] += line[i][c]
+			}
+		}
+		for i := 0; i < length; i++ {
+			lo, hi := max(i-radius, 0), min(i+radius, length-1)
+			n := hi - lo + 1
+			o := offset(i)
+			for c := 0; c < 4; c++ {
+				s.Data[o+c] = byte(sum[c] / n)
+			}
+			if next := i + radius + 1; next < length {
+				for c := 0; c < 4; c++ {
+					sum[c] += line[next][c]
+				}
+			}
+			if i-radius >= 0 {
+				for c := 0; c < 4; c++ {
+					sum[c] -= line[i-radius][c]
+				}
+			}
+		}
+	}
+}
+
+// parseMaskColor reads #RRGGBB into BGRA; empty is black
+func parseMaskColor(value string) ([4]byte, bool) {
+	if value == "" {
+		return [4]byte{0, 0, 0, 255}, true
+	}
+	hex, ok := strings.CutPrefix(value, "#")
+	if !ok || len(hex) != 6 {
+		return [4]byte{}, false
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return [4]byte{}, false
+	}
+	return [4]byte{byte(rgb), byte(rgb >> 8), byte(rgb >> 16), 255}, true
+}
+
+func checkMasks(problems *ValidationErrors, path string, masks []MaskConfig) {
+	for i, mask := range masks {
+		maskPath := fmt.Sprintf("%s[%d]", path, i)
+		if mask.Width <= 0 {
+			problems.add(maskPath+".width", "must be > 0")
+		}
+		if mask.Height <= 0 {
+			problems.add(maskPath+".height", "must be > 0")
+		}
+		if mask.Monitor < 0 {
+			problems.add(maskPath+".monitor", "must be >= 0")
+		}
+		if mask.Style != "" && indexOf(maskStyles, mask.Style) < 0 {
+			problems.add(maskPath+".style", "must be one of %s", strings.Join(maskStyles, ", "))
+		}
+		if _, ok := parseMaskColor(mask.Color); !ok {
+			problems.add(maskPath+".color", "must be a color as #RRGGBB")
+		}
+		if mask.Size < 0 {
+			problems.add(maskPath+".size", "must be >= 0")
+		}
+	}
+}
+
+// outlineRect draws a 2 pixel red frame just inside rect so that proposed
+// masks can be told apart in a preview
+func outlineRect(s *Screenshot, rect image.Rectangle) {
+	rect = rect.Intersect(image.Rect(0, 0, s.Width, s.Height))
+	if rect.Empty() {
+		return
+	}
+	red := [4]byte{0, 0, 255, 255}
+	const width = 2
+	fillRect(s, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, min(rect.Min.Y+width, rect.Max.Y)), red)
+	fillRect(s, image.Rect(rect.Min.X, max(rect.Max.Y-width, rect.Min.Y), rect.Max.X, rect.Max.Y), red)
+	fillRect(s, image.Rect(rect.Min.X, rect.Min.Y, min(rect.Min.X+width, rect.Max.X), rect.Max.Y), red)
+	fillRect(s, image.Rect(max(rect.Max.X-width, rect.Min.X), rect.Min.Y, rect.Max.X, rect.Max.Y), red)
+}
+
+// handleMaskPreview renders the whole desktop with proposed masks, posted as
+// {"masks": [...]}, applied on top of the configured ones and outlined, so
+// that they can be placed before they are saved with POST /config
+func (s *Server) handleMaskPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, permConfigure) {
+		return
+	}
+
+	var request struct {
+		Masks []MaskConfig `json:"masks"`
+	}
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	var problems ValidationErrors
+	checkMasks(&problems, "masks", request.Masks)
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "error", "errors": problems})
+		return
+	}
+
+	if s.pause.status().Paused {
+		http.Error(w, "Capture paused for privacy", http.StatusServiceUnavailable)
+		return
+	}
+	if !s.captureAllowed() {
+		writeCapturePaused(w, s.schedule.Load().status())
+		return
+	}
+	screenshot, err := s.capturer.Capture(nil)
+	if err == nil {
+		var rects []image.Rectangle
+		if rects, err = maskRects(s.capturer, request.Masks); err == nil {
+			for i, mask := range request.Masks {
+				applyMask(screenshot, rects[i], mask)
+				outlineRect(screenshot, rects[i])
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to capture preview: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	pngData, err := screenshot.ToPNGBytes()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode preview: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write(pngData)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestMaskingCapturerKeepsSourceFrames(t *testing.T) {
+	source := screenshotFromImage(noiseImage(64, 48))
+	masks := []MaskConfig{
+		{X: 4, Y: 4, Width: 20, Height: 16, Style: maskBlur, Size: 3},
+		{X: 30, Y: 20, Width: 16, Height: 16, Style: maskPixelate, Size: 4},
+		{X: 50, Y: 0, Width: 10, Height: 10},
+	}
+	capturer := newMaskingCapturer(writeReplayFrames(t, noiseImage(64, 48)), func() []MaskConfig { return masks })
+
+	first, err := capturer.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Data, source.Data) {
+		t.Fatal("masks were not applied")
+	}
+	// What /masks/preview does to a frame must not reach later ones either
+	outlineRect(first, image.Rect(0, 0, 64, 48))
+
+	second, err := capturer.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Screenshot{Width: source.Width, Height: source.Height, Data: bytes.Clone(source.Data)}
+	for _, mask := range masks {
+		applyMask(expected, image.Rect(mask.X, mask.Y, mask.X+mask.Width, mask.Y+mask.Height), mask)
+	}
+	if !bytes.Equal(second.Data, expected.Data) {
+		t.Error("second frame differs from the source masked once")
+	}
+
+	masks = nil
+	third, err := capturer.Capture(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(third.Data, source.Data) {
+		t.Error("frame after removing the masks differs from the source")
+	}
+}
+
+func TestMaskingCapturerRegionOffset(t *testing.T) {
+	masks := []MaskConfig{{X: 10, Y: 10, Width: 10, Height: 10, Color: "#FF0000"}}
+	capturer := newMaskingCapturer(writeReplayFrames(t, noiseImage(64, 48)), func() []MaskConfig { return masks })
+
+	frame, err := capturer.Capture(&ScreenRegion{X: 5, Y: 5, Width: 20, Height: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		x, y   int
+		masked bool
+	}{
+		{4, 4, false},
+		{5, 5, true},
+		{14, 14, true},
+		{15, 15, false},
+	}
+	for _, test := range tests {
+		offset := (test.y*frame.Width + test.x) * 4
+		red := frame.Data[offset] == 0 && frame.Data[offset+1] == 0 && frame.Data[offset+2] == 255
+		if red != test.masked {
+			t.Errorf("pixel (%d, %d): masked = %t, want %t", test.x, test.y, red, test.masked)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Monitor describes one physical display within the capturable desktop
+type Monitor struct {
+	ID      int          `json:"id"`      // 1-based, ordered left to right, then top to bottom
+	Name    string       `json:"name"`    // backend-specific name such as \\.\DISPLAY1 or HDMI-1
+	Bounds  ScreenRegion `json:"bounds"`  // in the same coordinates as Capturer.Bounds
+	Primary bool         `json:"primary"` // whether this is the primary display
+	Scale   float64      `json:"scale"`   // DPI scale factor, 1 for 96 DPI
+}
+
+// MonitorLister is implemented by capturers that know how the desktop is
+// split into monitors. Capturers without it are treated as a single monitor.
+type MonitorLister interface {
+	Monitors() ([]Monitor, error)
+}
+
+// listMonitors returns the monitors of the capturer's desktop
+func listMonitors(capturer Capturer) ([]Monitor, error) {
+	if lister, ok := capturer.(MonitorLister); ok {
+		monitors, err := lister.Monitors()
+		if err != nil {
+			return nil, err
+		}
+		if len(monitors) > 0 {
+			return monitors, nil
+		}
+	}
+
+	bounds, err := capturer.Bounds()
+	if err != nil {
+		return nil, err
+	}
+
+	return []Monitor{{ID: 1, Name: "Screen", Bounds: bounds, Primary: true, Scale: 1}}, nil
+}
+
+// numberMonitors sorts monitors left to right, then top to bottom, and
+// assigns their IDs so that they are stable across calls
+func numberMonitors(monitors []Monitor) {
+	sort.SliceStable(monitors, func(i, j int) bool {
+		a, b := monitors[i].Bounds, monitors[j].Bounds
+		if a.X != b.X {
+			return a.X < b.X
+		}
+		return a.Y < b.Y
+	})
+	for i := range monitors {
+		monitors[i].ID = i + 1
+	}
+}
+
+func findMonitor(monitors []Monitor, id int) (Monitor, error) {
+	for _, monitor := range monitors {
+		if monitor.ID == id {
+			return monitor, nil
+		}
+	}
+	return Monitor{}, fmt.Errorf("monitor %d not found (%d available)", id, len(monitors))
+}
+
+// monitorRegion maps a region given relative to a monitor (nil for the whole
+// monitor) onto the desktop, returning it relative to the desktop origin as
+// expected by Capturer.Capture. The result never extends past the monitor.
+func monitorRegion(desktop ScreenRegion, monitor Monitor, region *ScreenRegion) (*ScreenRegion, error) {
+	area := monitor.Bounds
+	if region != nil {
+		clamped, ok := clampRegion(*region, area.Width, area.Height)
+		if !ok {
+			return nil, fmt.Errorf("region %dx%d at (%d, %d) is outside monitor %d", region.Width, region.Height, region.X, region.Y, monitor.ID)
+		}
+		area = ScreenRegion{X: area.X + clamped.X, Y: area.Y + clamped.Y, Width: clamped.Width, Height: clamped.Height}
+	}
+
+	rect := regionRect(area).Intersect(regionRect(desktop))
+	if rect.Empty() {
+		return nil, fmt.Errorf("monitor %d is outside the desktop", monitor.ID)
+	}
+
+	return &ScreenRegion{
+		X:      rect.Min.X - desktop.X,
+		Y:      rect.Min.Y - desktop.Y,
+		Width:  rect.Dx(),
+		Height: rect.Dy(),
+	}, nil
+}
+
+func regionRect(region ScreenRegion) image.Rectangle {
+	return image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height)
+}
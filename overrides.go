@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// envPrefix starts the environment variable for each setting, followed by
+// its path in upper case with dots replaced: DSC_CAPTURE_INTERVAL
+const envPrefix = "DSC_"
+
+// Where the value of a setting came from, from lowest to highest precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configOverride sets one setting from an environment variable or flag
+type configOverride struct {
+	field  string // path in the config file, such as capture.interval
+	value  string
+	source string // sourceEnv or sourceFlag
+	name   string // the variable or flag that set it
+}
+
+// configOverrides are applied in order on top of the config file, so
+// flags, which come after environment variables, take precedence
+type configOverrides []configOverride
+
+// configFieldPaths lists the path of every setting that can be overridden:
+// each scalar, each list of strings, and lists of objects as JSON. Optional
+// objects such as capture.region are created when one of their fields is set.
+func configFieldPaths() []string {
+	var paths []string
+	var walk func(t reflect.Type, path string)
+	walk = func(t reflect.Type, path string) {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			paths = append(paths, path)
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			// The schema version describes the file rather than a setting
+			if path == "" && name == "version" {
+				continue
+			}
+			walk(field.Type, joinFieldPath(path, name))
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return paths
+}
+
+func envName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// configFlag is the flag for one setting; it records the values given on
+// the command line as overrides
+type configFlag struct {
+	path      string
+	isBool    bool
+	overrides *configOverrides
+}
+
+func (f *configFlag) String() string { return "" }
+
+func (f *configFlag) Set(value string) error {
+	*f.overrides = append(*f.overrides, configOverride{field: f.path, value: value, source: sourceFlag, name: "-" + f.path})
+	return nil
+}
+
+// IsBoolFlag lets switches be given without a value, as -archive.enabled,
+// like other boolean flags; -archive.enabled=false turns one off
+func (f *configFlag) IsBoolFlag() bool { return f.isBool }
+
+// registerConfigFlags adds a flag for every setting, named by its path
+// (-server.port 8080), and returns the overrides set on the command line
+// once the flags are parsed
+func registerConfigFlags(fs *flag.FlagSet) *configOverrides {
+	overrides := &configOverrides{}
+	config := reflect.ValueOf(&Config{}).Elem()
+	for _, path := range configFieldPaths() {
+		field, _ := configFieldValue(config, path, true)
+		f := &configFlag{path: path, isBool: field.Kind() == reflect.Bool, overrides: overrides}
+		fs.Var(f, path, "overrides "+path+" in the config file")
+	}
+	return overrides
+}
+
+// envOverrides returns the overrides set by DSC_* environment variables
+func envOverrides() configOverrides {
+	var overrides configOverrides
+	for _, path := range configFieldPaths() {
+		name := envName(path)
+		if value, ok := os.LookupEnv(name); ok {
+			overrides = append(overrides, configOverride{field: path, value: value, source: sourceEnv, name: name})
+		}
+	}
+	return overrides
+}
+
+// apply sets the overridden settings in config; a value that cannot be
+// parsed is reported as a problem with its setting
+func (o configOverrides) apply(config *Config) ValidationErrors {
+	var problems ValidationErrors
+	for _, override := range o {
+		field, err := configFieldValue(reflect.ValueOf(config).Elem(), override.field, true)
+		if err == nil {
+			err = setConfigField(field, override.value)
+		}
+		if err != nil {
+			problems.add(override.field, "invalid value %q from %s: %v", override.value, override.name, err)
+		}
+	}
+	return problems
+}
+
+// configFieldValue finds the setting at path inside a Config value. With
+// create, optional objects on the way are allocated; otherwise an invalid
+// Value is returned when one of them is missing.
+func configFieldValue(v reflect.Value, path string, create bool) (reflect.Value, error) {
+	for _, key := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !create {
+					return reflect.Value{}, nil
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		field, ok := jsonField(v.Type(), key)
+		if !ok || v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown setting %s", path)
+		}
+		v = v.FieldByIndex(field.Index)
+	}
+	return v, nil
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		// Lists of strings may be given as a,b,c; anything else as JSON
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		list := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), list.Interface()); err != nil {
+			return err
+		}
+		field.Set(list.Elem())
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// configSources tells for each setting where its value came from: the
+// defaults, the config file or an override
+func configSources(data []byte, overrides configOverrides) map[string]string {
+	sources := make(map[string]string)
+	for _, path := range configFieldPaths() {
+		sources[path] = sourceDefault
+	}
+
+	var tree interface{}
+	if json.Unmarshal(data, &tree) == nil {
+		for _, path := range configFieldPaths() {
+			if jsonPathPresent(tree, path) {
+				sources[path] = sourceFile
+			}
+		}
+	}
+
+	for _, override := range overrides {
+		sources[override.field] = override.source + " " + override.name
+	}
+	return sources
+}
+
+// jsonPathPresent reports whether a decoded JSON document sets path, with
+// keys matched regardless of case like encoding/json does
+func jsonPathPresent(tree interface{}, path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := tree.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		found := false
+		for name, child := range object {
+			if strings.EqualFold(name, key) {
+				tree, found = child, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// printConfig writes the effective value and source of every setting for
+// -print-config
+func printConfig(w io.Writer, config *Config, sources map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, path := range configFieldPaths() {
+		field, err := configFieldValue(reflect.ValueOf(config).Elem(), path, false)
+		if err != nil {
+			return err
+		}
+
+		value := "null"
+		switch {
+		case !field.IsValid():
+		case field.Type() == durationType:
+			value = time.Duration(field.Int()).String()
+		default:
+			data, err := json.Marshal(field.Interface())
+			if err != nil {
+				return err
+			}
+			value = string(data)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", path, value, sources[path])
+	}
+	return tw.Flush()
+}
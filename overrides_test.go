@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestConfigFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		check   func(*Config) bool
+		rest    []string
+		wantErr bool
+	}{
+		{
+			name:  "switch without a value",
+			args:  []string{"-archive.enabled", "-server.port", "8080"},
+			check: func(c *Config) bool { return c.Archive.Enabled && c.Server.Port == 8080 },
+		},
+		{
+			name:  "switch turned off",
+			args:  []string{"-history.enabled=false", "-change_detection.enabled=0"},
+			check: func(c *Config) bool { return !c.History.Enabled && !c.ChangeDetection.Enabled },
+		},
+		{
+			name:  "switch before arguments",
+			args:  []string{"-pause.enabled", "30m"},
+			check: func(c *Config) bool { return c.Pause.Enabled },
+			rest:  []string{"30m"},
+		},
+		{
+			name:  "later flags win",
+			args:  []string{"-capture.mode", "realtime", "-archive.enabled", "-archive.enabled=false", "-capture.mode=ondemand"},
+			check: func(c *Config) bool { return !c.Archive.Enabled && c.Capture.Mode == "ondemand" },
+		},
+		{
+			name:    "setting that is not a switch needs a value",
+			args:    []string{"-server.port"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			overrides := registerConfigFlags(fs)
+			err := fs.Parse(test.args)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("parsed, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := DefaultConfig()
+			if problems := overrides.apply(config); len(problems) > 0 {
+				t.Fatal(problems)
+			}
+			if !test.check(config) {
+				t.Errorf("overrides %+v not applied as expected", *overrides)
+			}
+			if len(fs.Args()) != len(test.rest) || (len(test.rest) > 0 && fs.Args()[0] != test.rest[0]) {
+				t.Errorf("arguments %q, want %q", fs.Args(), test.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPausePort = 9982
+
+	pausePlaceholderWidth  = 1280
+	pausePlaceholderHeight = 720
+
+	pauseRequestTimeout = 5 * time.Second
+)
+
+// errPrivacyPaused is returned instead of taking a frame while the person at
+// the desk has paused capture
+var errPrivacyPaused = errors.New("capture paused for privacy")
+
+// privacyPause is the pause toggled by the person at the desk through the
+// loopback-only pause control, with -pause and -resume
+type privacyPause struct {
+	mu           sync.Mutex
+	paused       bool
+	since        time.Time
+	until        time.Time   // when capture resumes by itself, zero to stay paused until resumed
+	timer        *time.Timer // ends a pause with a timer
+	placeholders map[string][]byte
+	ended        func() // called with mu held when a pause ends
+	now          func() time.Time
+}
+
+// pauseStatus is reported by the pause control and drawn on the placeholder
+type pauseStatus struct {
+	Paused bool       `json:"paused"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"` // omitted while paused until resumed
+}
+
+func newPrivacyPause(ended func()) *privacyPause {
+	return &privacyPause{placeholders: make(map[string][]byte), ended: ended, now: time.Now}
+}
+
+// pause stops capture, for duration or until resumed when duration is 0,
+// replacing a pause already in effect
+func (p *privacyPause) pause(duration time.Duration) pauseStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopTimer()
+	p.paused = true
+	p.since = p.now()
+	p.until = time.Time{}
+	if duration > 0 {
+		p.until = p.since.Add(duration)
+		// The timer only logs the end of the pause, status ends it on time
+		// even when the timer fires late, as after the computer slept
+		p.timer = time.AfterFunc(duration, func() { p.status() })
+	}
+	clear(p.placeholders)
+	return p.statusLocked()
+}
+
+// resume lets capture continue, reporting whether it was paused
+func (p *privacyPause) resume() (pauseStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	wasPaused := p.paused
+	if wasPaused {
+		p.end()
+	}
+	return p.statusLocked(), wasPaused
+}
+
+func (p *privacyPause) status() pauseStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	return p.statusLocked()
+}
+
+// unlessPaused calls publish with mu held unless capture is paused, so that
+// a frame taken just before a pause cannot replace the placeholder
+func (p *privacyPause) unlessPaused(publish func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	if !p.paused {
+		publish()
+	}
+}
+
+func (p *privacyPause) statusLocked() pauseStatus {
+	if !p.paused {
+		return pauseStatus{}
+	}
+	since := p.since
+	status := pauseStatus{Paused: true, Since: &since}
+	if !p.until.IsZero() {
+		until := p.until
+		status.Until = &until
+	}
+	return status
+}
+
+// expire ends a pause whose time is up. The caller holds mu.
+func (p *privacyPause) expire() {
+	if !p.paused || p.until.IsZero() || p.now().Before(p.until) {
+		return
+	}
+	p.end()
+	fmt.Println("Capture resumed, privacy pause ended")
+}
+
+func (p *privacyPause) end() {
+	p.stopTimer()
+	p.paused = false
+	clear(p.placeholders)
+	p.ended()
+}
+
+func (p *privacyPause) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// placeholder returns the frame served instead of the screen while paused,
+// encoded as opts asks; it is rendered once per pause and format
+func (p *privacyPause) placeholder(status pauseStatus, opts *ScreenshotOptions) ([]byte, string, error) {
+	format, ok := normalizeImageFormat(opts.Format)
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported image format: %s", opts.Format)
+	}
+	key := fmt.Sprintf("%s/%d/%t/%dx%d", format, opts.Quality, opts.Compress, opts.MaxWidth, opts.MaxHeight)
+
+	p.mu.Lock()
+	data, ok := p.placeholders[key]
+	p.mu.Unlock()
+	if ok {
+		return data, imageContentType(format), nil
+	}
+
+	data, err := renderPausePlaceholder(status, time.Now()).EncodeWithOptions(&ScreenshotOptions{
+		Compress:  opts.Compress,
+		MaxWidth:  opts.MaxWidth,
+		MaxHeight: opts.MaxHeight,
+		Format:    format,
+		Quality:   opts.Quality,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.mu.Lock()
+	if p.paused && status.Since != nil && p.since.Equal(*status.Since) {
+		p.placeholders[key] = data
+	}
+	p.mu.Unlock()
+	return data, imageContentType(format), nil
+}
+
+// renderPausePlaceholder draws "capture paused" and when it resumes, in
+// local time with the date only when that is not today
+func renderPausePlaceholder(status pauseStatus, now time.Time) *Screenshot {
+	img := image.NewRGBA(image.Rect(0, 0, pausePlaceholderWidth, pausePlaceholderHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{40, 40, 40, 255}), image.Point{}, draw.Src)
+
+	until := "until resumed at the desk"
+	if status.Until != nil {
+		resumes := status.Until.Local()
+		layout := "15:04"
+		if y, m, d := resumes.Date(); y != now.Year() || m != now.Month() || d != now.Day() {
+			layout = "2006-01-02 15:04"
+		}
+		until = "until " + resumes.Format(layout)
+	}
+	lines := []struct {
+		text  string
+		scale int
+	}{
+		{"Capture paused", 12},
+		{"for privacy", 6},
+		{until, 6},
+	}
+
+	height := 0
+	for _, line := range lines {
+		height += (glyphHeight + 4) * line.scale
+	}
+	y := (pausePlaceholderHeight - height) / 2
+	for _, line := range lines {
+		width, _ := textSize(line.text, line.scale)
+		drawText(img, (pausePlaceholderWidth-width)/2, y, line.text, line.scale, color.White)
+		y += (glyphHeight + 4) * line.scale
+	}
+
+	return screenshotFromImage(img)
+}
+
+// publishPausePlaceholder replaces the latest /stream frame with the
+// placeholder, so that clients watching or joining see the pause rather
+// than the last frame taken before it
+func (s *Server) publishPausePlaceholder(status pauseStatus) {
+	data, _, err := s.pause.placeholder(status, &ScreenshotOptions{Format: formatJPEG})
+	if err != nil {
+		fmt.Printf("Failed to encode pause placeholder: %v\n", err)
+		return
+	}
+	s.stream.publish(streamFrame{data: data, captured: time.Now()})
+}
+
+// writePausePlaceholder answers a request for a frame while paused
+func (s *Server) writePausePlaceholder(w http.ResponseWriter, status pauseStatus, opts *ScreenshotOptions) {
+	data, contentType, err := s.pause.placeholder(status, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode pause placeholder: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("X-Capture-Paused", "privacy")
+	w.Write(data)
+}
+
+// listenPauseControl serves the pause control on the loopback interface, so
+// that only someone on this computer can pause and resume capture
+func (s *Server) listenPauseControl(config PauseConfig) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(config.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen for pause control: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", s.handlePause)
+	mux.HandleFunc("/resume", s.handleResume)
+	server := &http.Server{Handler: localOnly(mux)}
+
+	s.listenMu.Lock()
+	s.pauseControl = server
+	s.listenMu.Unlock()
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Pause control stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("Privacy pause control on %s\n", ln.Addr())
+	return nil
+}
+
+// localOnly refuses requests that did not come from this computer, and
+// those a web page could have sent through the browser: cross-site ones,
+// which carry an Origin, and DNS rebinding, which names another host
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		name := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			name = h
+		}
+		if ip := net.ParseIP(name); name != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePause reports the pause with GET and pauses capture with POST, for
+// the duration given as for=30m or until resumed
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.pause.status())
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var duration time.Duration
+	if value := r.FormValue("for"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid duration, use a positive duration such as 30m", http.StatusBadRequest)
+			return
+		}
+		duration = parsed
+	}
+
+	status := s.pause.pause(duration)
+	if s.change != nil {
+		s.change.forget() // the first frame after the pause is new, not compared with the last one before it
+	}
+	s.publishPausePlaceholder(status)
+
+	var params map[string]interface{}
+	if status.Until != nil {
+		params = map[string]interface{}{"for": duration.String()}
+		fmt.Printf("Capture paused for privacy until %s\n", status.Until.Format(time.RFC3339))
+	} else {
+		fmt.Println("Capture paused for privacy until resumed")
+	}
+	s.recordAuditFrom(r.RemoteAddr, "", "pause", params, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleResume ends the pause
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, wasPaused := s.pause.resume()
+	if wasPaused {
+		fmt.Println("Capture resumed")
+		s.recordAuditFrom(r.RemoteAddr, "", "resume", nil, nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// requestPause sends -pause and -resume to the pause control of the server
+// running on this computer
+func requestPause(config PauseConfig, resume bool, duration time.Duration) (pauseStatus, error) {
+	path, form := "/pause", url.Values{}
+	if resume {
+		path = "/resume"
+	} else if duration > 0 {
+		form.Set("for", duration.String())
+	}
+	endpoint := fmt.Sprintf("http://%s%s", net.JoinHostPort("127.0.0.1", strconv.Itoa(config.Port)), path)
+
+	client := &http.Client{Timeout: pauseRequestTimeout}
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return pauseStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return pauseStatus{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return pauseStatus{}, fmt.Errorf("%s: %s", resp.Status, string(body))
+	}
+	var status pauseStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return pauseStatus{}, fmt.Errorf("invalid response: %v", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivacyPause(t *testing.T) {
+	ended := 0
+	p := newPrivacyPause(func() { ended++ })
+	clock := &testClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	p.now = clock.now
+
+	if status := p.status(); status.Paused {
+		t.Fatalf("paused from the start: %+v", status)
+	}
+	if _, wasPaused := p.resume(); wasPaused || ended != 0 {
+		t.Fatal("resumed without a pause")
+	}
+
+	// Until resumed
+	status := p.pause(0)
+	if !status.Paused || !status.Since.Equal(clock.now()) || status.Until != nil {
+		t.Fatalf("pause: %+v", status)
+	}
+	clock.advance(24 * time.Hour)
+	if !p.status().Paused {
+		t.Fatal("pause without a duration ended by itself")
+	}
+	status, wasPaused := p.resume()
+	if status.Paused || !wasPaused || ended != 1 {
+		t.Fatalf("resume: %+v, was paused %v, ended %d times", status, wasPaused, ended)
+	}
+
+	// For a while, ending on time even without the timer
+	status = p.pause(30 * time.Minute)
+	if !status.Paused || !status.Until.Equal(clock.now().Add(30*time.Minute)) {
+		t.Fatalf("pause for 30m: %+v", status)
+	}
+	clock.advance(30*time.Minute - time.Second)
+	if !p.status().Paused {
+		t.Fatal("pause ended early")
+	}
+	clock.advance(time.Second)
+	if p.status().Paused || ended != 2 {
+		t.Fatalf("pause did not end on time, ended %d times", ended)
+	}
+	if _, wasPaused := p.resume(); wasPaused {
+		t.Error("resumed an expired pause")
+	}
+
+	// A new pause replaces the one in effect
+	p.pause(time.Minute)
+	clock.advance(10 * time.Second)
+	status = p.pause(0)
+	clock.advance(time.Hour)
+	if !p.status().Paused || status.Until != nil || !status.Since.Equal(clock.now().Add(-time.Hour)) {
+		t.Fatalf("replaced pause: %+v", p.status())
+	}
+	if p.timer != nil {
+		t.Error("timer of the replaced pause still running")
+	}
+	p.resume()
+}
+
+func TestPausePlaceholder(t *testing.T) {
+	p := newPrivacyPause(func() {})
+	status := p.pause(0)
+	defer p.resume()
+
+	tests := []struct {
+		opts          ScreenshotOptions
+		contentType   string
+		width, height int
+	}{
+		{ScreenshotOptions{Format: formatJPEG}, "image/jpeg", pausePlaceholderWidth, pausePlaceholderHeight},
+		{ScreenshotOptions{Format: formatPNG}, "image/png", pausePlaceholderWidth, pausePlaceholderHeight},
+		{ScreenshotOptions{Format: formatJPEG, Compress: true, MaxWidth: 640}, "image/jpeg", 640, 360},
+	}
+	for _, test := range tests {
+		data, contentType, err := p.placeholder(status, &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != test.contentType || config.Width != test.width || config.Height != test.height {
+			t.Errorf("%+v: %s %dx%d, want %s %dx%d", test.opts, contentType, config.Width, config.Height, test.contentType, test.width, test.height)
+		}
+
+		// Rendered once per pause and format
+		again, _, err := p.placeholder(status, &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &again[0] != &data[0] {
+			t.Errorf("%+v: placeholder rendered twice", test.opts)
+		}
+	}
+
+	if _, _, err := p.placeholder(status, &ScreenshotOptions{Format: "gif"}); err == nil {
+		t.Error("placeholder encoded as gif")
+	}
+}
+
+func TestPausedServerServesPlaceholder(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	waitForFrame(t, ts)
+
+	s.pause.pause(0)
+	if err := s.updateScreenshot(); err != errPrivacyPaused {
+		t.Fatalf("capture while paused: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	config, _, err := image.DecodeConfig(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("X-Capture-Paused") != "privacy" || config.Width != pausePlaceholderWidth || config.Height != pausePlaceholderHeight {
+		t.Errorf("/last while paused: %q %dx%d", resp.Header.Get("X-Capture-Paused"), config.Width, config.Height)
+	}
+
+	s.pause.resume()
+	if err := s.updateScreenshot(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(ts.URL + "/last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if config, _, err = image.DecodeConfig(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("X-Capture-Paused") != "" || config.Width != 320 || config.Height != 240 {
+		t.Errorf("/last after resuming: %q %dx%d", resp.Header.Get("X-Capture-Paused"), config.Width, config.Height)
+	}
+}
+
+// gatedCapturer holds every capture until it is released
+type gatedCapturer struct {
+	Capturer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *gatedCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return c.Capturer.Capture(region)
+}
+
+func TestPauseDuringCapture(t *testing.T) {
+	config := DefaultConfig()
+	config.Audit.Enabled = false
+	config.Capture.Compression.Format = formatJPEG
+	capturer := &gatedCapturer{newSyntheticCapturer(320, 240, nil), make(chan struct{}), make(chan struct{})}
+	s := NewServer(config, "", capturer)
+	defer s.Stop()
+
+	sub, _ := s.stream.subscribe(1, false)
+	defer s.stream.unsubscribe(sub)
+
+	// The capture starts before the pause and finishes after it
+	done := make(chan error)
+	go func() { done <- s.updateScreenshot() }()
+	<-capturer.started
+	status := s.pause.pause(0)
+	s.publishPausePlaceholder(status)
+	close(capturer.release)
+	if err := <-done; err != errPrivacyPaused {
+		t.Fatalf("capture finished during a pause: %v", err)
+	}
+
+	placeholder, _, err := s.pause.placeholder(status, &ScreenshotOptions{Format: formatJPEG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case frame := <-sub.frames:
+		if !bytes.Equal(frame.data, placeholder) {
+			t.Error("stream shows a frame instead of the placeholder")
+		}
+	default:
+		t.Fatal("nothing streamed")
+	}
+	s.mu.Lock()
+	stored := s.lastScreenshot
+	s.mu.Unlock()
+	if stored != nil {
+		t.Error("frame taken during the pause was kept")
+	}
+
+	// Frames are only published while not paused
+	published := false
+	s.pause.unlessPaused(func() { published = true })
+	if published {
+		t.Error("published while paused")
+	}
+	s.pause.resume()
+	s.pause.unlessPaused(func() { published = true })
+	if !published {
+		t.Error("not published after resuming")
+	}
+}
+
+func TestPauseControl(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", s.handlePause)
+	mux.HandleFunc("/resume", s.handleResume)
+	handler := localOnly(mux)
+
+	request := func(method, target string, configure func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "127.0.0.1:50000"
+		req.Host = "127.0.0.1:9982"
+		if configure != nil {
+			configure(req)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only this computer may pause, and not through a web page
+	tests := []struct {
+		name      string
+		configure func(*http.Request)
+		status    int
+	}{
+		{"loopback", nil, http.StatusOK},
+		{"IPv6 loopback", func(r *http.Request) { r.RemoteAddr = "[::1]:50000"; r.Host = "[::1]:9982" }, http.StatusOK},
+		{"localhost", func(r *http.Request) { r.Host = "localhost:9982" }, http.StatusOK},
+		{"remote address", func(r *http.Request) { r.RemoteAddr = "192.168.1.20:50000" }, http.StatusForbidden},
+		{"cross-site request", func(r *http.Request) { r.Header.Set("Origin", "https://example.com") }, http.StatusForbidden},
+		{"DNS rebinding", func(r *http.Request) { r.Host = "attacker.example:9982" }, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if w := request("GET", "/pause", test.configure); w.Code != test.status {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+	if s.pause.status().Paused {
+		t.Fatal("GET paused capture")
+	}
+
+	for _, duration := range []string{"soon", "-5m", "0s"} {
+		if w := request("POST", "/pause?for="+duration, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("pause for %s: %d", duration, w.Code)
+		}
+	}
+
+	w := request("POST", "/pause?for=30m", nil)
+	var status pauseStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !status.Paused || status.Until == nil || status.Until.Sub(*status.Since) != 30*time.Minute {
+		t.Fatalf("pause for 30m: %d %s", w.Code, w.Body)
+	}
+
+	w = request("POST", "/resume", nil)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"paused":false}` || s.pause.status().Paused {
+		t.Errorf("resume: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestStartWithPausePortTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	config := DefaultConfig()
+	if config.Pause.Enabled {
+		t.Error("pause control enabled by default")
+	}
+	config.Server.Host = "127.0.0.1"
+	config.Server.Port = port
+	config.Capture.Source = "synthetic"
+	config.Audit.Enabled = false
+	config.Pause.Enabled = true
+	config.Pause.Port = taken.Addr().(*net.TCPAddr).Port
+	s := NewServer(config, "", newSyntheticCapturer(320, 240, nil))
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/screen-info", port)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		select {
+		case err := <-started:
+			t.Fatalf("Start returned %v", err)
+		default:
+		}
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not serving: %v", err)
+		}
+	}
+
+	s.Stop()
+	if err := <-started; err != nil {
+		t.Errorf("Start returned %v after Stop", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// pixelChannel locates one colour channel inside a packed pixel value
+type pixelChannel struct {
+	Shift int // bit offset of the least significant bit
+	Bits  int // channel width in bits
+}
+
+// pixelFormat describes a packed true-colour pixel layout as used by X11
+// visuals and Linux framebuffers
+type pixelFormat struct {
+	BitsPerPixel int
+	BigEndian    bool
+	Red          pixelChannel
+	Green        pixelChannel
+	Blue         pixelChannel
+}
+
+// channelFromMask converts a contiguous bit mask such as 0xff0000 into a channel
+func channelFromMask(mask uint32) pixelChannel {
+	if mask == 0 {
+		return pixelChannel{}
+	}
+	shift := bits.TrailingZeros32(mask)
+	return pixelChannel{Shift: shift, Bits: bits.OnesCount32(mask >> shift)}
+}
+
+// isBGRX reports whether pixels are already laid out as the B, G, R, X bytes
+// used by Screenshot, so they can be copied instead of unpacked
+func (f pixelFormat) isBGRX() bool {
+	return f.BitsPerPixel == 32 && !f.BigEndian &&
+		f.Red == pixelChannel{16, 8} && f.Green == pixelChannel{8, 8} && f.Blue == pixelChannel{0, 8}
+}
+
+// toBGRA converts width x height pixels with rows stride bytes apart into the
+// opaque BGRA layout used by Screenshot
+func (f pixelFormat) toBGRA(src []byte, width, height, stride int) ([]byte, error) {
+	bytesPerPixel := f.BitsPerPixel / 8
+	if f.BitsPerPixel%8 != 0 || bytesPerPixel < 1 || bytesPerPixel > 4 {
+		return nil, fmt.Errorf("unsupported bits per pixel: %d", f.BitsPerPixel)
+	}
+	if stride < width*bytesPerPixel {
+		return nil, fmt.Errorf("row stride %d too small for %d pixels of %d bits", stride, width, f.BitsPerPixel)
+	}
+	if height > 0 && len(src) < (height-1)*stride+width*bytesPerPixel {
+		return nil, fmt.Errorf("pixel data too short: %d bytes for %dx%d", len(src), width, height)
+	}
+
+	dst := make([]byte, width*height*4)
+
+	if f.isBGRX() {
+		for y := 0; y < height; y++ {
+			row := dst[y*width*4 : (y+1)*width*4]
+			copy(row, src[y*stride:])
+			for i := 3; i < len(row); i += 4 {
+				row[i] = 255
+			}
+		}
+		return dst, nil
+	}
+
+	for y := 0; y < height; y++ {
+		row := src[y*stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*bytesPerPixel : (x+1)*bytesPerPixel]
+			var v uint32
+			switch {
+			case bytesPerPixel == 4 && f.BigEndian:
+				v = binary.BigEndian.Uint32(p)
+			case bytesPerPixel == 4:
+				v = binary.LittleEndian.Uint32(p)
+			default:
+				for i := range p {
+					if f.BigEndian {
+						v = v<<8 | uint32(p[i])
+					} else {
+						v |= uint32(p[i]) << (8 * i)
+					}
+				}
+			}
+
+			offset := (y*width + x) * 4
+			dst[offset] = f.Blue.extract(v)
+			dst[offset+1] = f.Green.extract(v)
+			dst[offset+2] = f.Red.extract(v)
+			dst[offset+3] = 255
+		}
+	}
+
+	return dst, nil
+}
+
+// extract pulls the channel out of a pixel value and scales it to 8 bits
+func (c pixelChannel) extract(v uint32) uint8 {
+	if c.Bits <= 0 {
+		return 0
+	}
+	value := (v >> c.Shift) & (1<<c.Bits - 1)
+	if c.Bits >= 8 {
+		return uint8(value >> (c.Bits - 8))
+	}
+	// Scale narrow channels so full intensity maps to 255
+	return uint8(value * 255 / (1<<c.Bits - 1))
+}
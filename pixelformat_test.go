@@ -0,0 +1,121 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestPixelFormatToBGRA(t *testing.T) {
+	tests := []struct {
+		name   string
+		format pixelFormat
+		pixel  []byte
+		want   color.RGBA
+	}{
+		{"xrgb8888", framebufferFormats["xrgb8888"], []byte{0x40, 0x80, 0xff, 0x00}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		{"xrgb8888 ignores padding byte", framebufferFormats["xrgb8888"], []byte{0x40, 0x80, 0xff, 0x7f}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		{"xbgr8888", framebufferFormats["xbgr8888"], []byte{0xff, 0x80, 0x40, 0x00}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		{"rgb888", framebufferFormats["rgb888"], []byte{0x40, 0x80, 0xff}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		{"bgr888", framebufferFormats["bgr888"], []byte{0xff, 0x80, 0x40}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		// 0xfc08: red 31, green 32, blue 8
+		{"rgb565", framebufferFormats["rgb565"], []byte{0x08, 0xfc}, color.RGBA{255, 129, 65, 255}},
+		{"rgb565 white", framebufferFormats["rgb565"], []byte{0xff, 0xff}, color.RGBA{255, 255, 255, 255}},
+		{"bgr565", framebufferFormats["bgr565"], []byte{0x08, 0xfc}, color.RGBA{65, 129, 255, 255}},
+		{"big-endian xrgb8888", pixelFormat{BitsPerPixel: 32, BigEndian: true, Red: pixelChannel{16, 8}, Green: pixelChannel{8, 8}, Blue: pixelChannel{0, 8}},
+			[]byte{0x00, 0xff, 0x80, 0x40}, color.RGBA{0xff, 0x80, 0x40, 255}},
+		{"big-endian rgb565", pixelFormat{BitsPerPixel: 16, BigEndian: true, Red: pixelChannel{11, 5}, Green: pixelChannel{5, 6}, Blue: pixelChannel{0, 5}},
+			[]byte{0xfc, 0x08}, color.RGBA{255, 129, 65, 255}},
+		// Depth 30 X11 visuals have 10 bits per channel
+		{"xrgb2101010", pixelFormat{BitsPerPixel: 32, Red: pixelChannel{20, 10}, Green: pixelChannel{10, 10}, Blue: pixelChannel{0, 10}},
+			[]byte{0x00, 0x01, 0xf8, 0x3f}, color.RGBA{255, 128, 64, 255}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := test.format.toBGRA(test.pixel, 1, 1, len(test.pixel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pixelAt(&Screenshot{Width: 1, Height: 1, Data: data}, 0, 0); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPixelFormatStride(t *testing.T) {
+	// Red, green, blue and white in 2x2 pixels, with two bytes of padding
+	// after every row that must not be read as pixels
+	tests := []struct {
+		format string
+		src    []byte
+	}{
+		{"rgb565", []byte{
+			0x00, 0xf8, 0xe0, 0x07, 0xaa, 0xaa,
+			0x1f, 0x00, 0xff, 0xff, 0xaa, 0xaa,
+		}},
+		{"xrgb8888", []byte{
+			0x00, 0x00, 0xff, 0x00, 0x00, 0xff, 0x00, 0x00, 0xaa, 0xaa,
+			0xff, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x00, 0xaa, 0xaa,
+		}},
+	}
+	want := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			data, err := framebufferFormats[test.format].toBGRA(test.src, 2, 2, len(test.src)/2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			screenshot := &Screenshot{Width: 2, Height: 2, Data: data}
+			for i, c := range want {
+				if got := pixelAt(screenshot, i%2, i/2); got != c {
+					t.Errorf("pixel (%d, %d) is %v, want %v", i%2, i/2, got, c)
+				}
+			}
+		})
+	}
+}
+
+func TestPixelFormatErrors(t *testing.T) {
+	rgb565 := framebufferFormats["rgb565"]
+	tests := []struct {
+		name   string
+		format pixelFormat
+		src    []byte
+		stride int
+		err    string
+	}{
+		{"bits per pixel", pixelFormat{BitsPerPixel: 12}, make([]byte, 8), 4, "unsupported bits per pixel: 12"},
+		{"stride", rgb565, make([]byte, 8), 2, "row stride 2 too small"},
+		{"short data", rgb565, make([]byte, 7), 4, "pixel data too short"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.format.toBGRA(test.src, 2, 2, test.stride)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestChannelFromMask(t *testing.T) {
+	tests := []struct {
+		mask uint32
+		want pixelChannel
+	}{
+		{0xff0000, pixelChannel{16, 8}},
+		{0x07e0, pixelChannel{5, 6}},
+		{0x3ff00000, pixelChannel{20, 10}},
+		{0, pixelChannel{}},
+	}
+
+	for _, test := range tests {
+		if got := channelFromMask(test.mask); got != test.want {
+			t.Errorf("mask %#x: got %+v, want %+v", test.mask, got, test.want)
+		}
+	}
+}
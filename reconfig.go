@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const listenerShutdownTimeout = 10 * time.Second
+
+// liveConfigFields are the settings a POST to /config applies right away;
+// changing anything else only takes effect after a restart. Each entry also
+// covers the fields below it.
+var liveConfigFields = []string{
+	"server",
+	"capture.mode",
+	"capture.interval",
+	"capture.monitor",
+	"capture.region",
+	"capture.masks",
+	"capture.compression",
+	"capture.watermark",
+	"capture.schedule",
+	"change_detection.skip_unchanged",
+	"auth",
+}
+
+// restartOnlyComponents are the settings of components built once at startup.
+// A reload of the config file that changes them is rejected, so that the file
+// never claims a state, such as a removed webhook target, that is not in
+// effect.
+var restartOnlyComponents = []string{"webhooks", "audit", "archive"}
+
+// ConfigChange is one setting changed by a POST to /config
+type ConfigChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+	Live  bool        `json:"live"` // false when the change needs a restart
+}
+
+// copyConfig returns a deep copy of config, so that a request can be decoded
+// on top of the current settings without changing them
+func copyConfig(config *Config) (*Config, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	copied := &Config{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// diffConfig lists the settings that differ between two configurations,
+// using the dotted JSON names of the config file
+func diffConfig(oldConfig, newConfig *Config) ([]ConfigChange, error) {
+	oldFields, err := flattenConfig(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flattenConfig(newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for field := range oldFields {
+		fields[field] = true
+	}
+	for field := range newFields {
+		fields[field] = true
+	}
+
+	changes := []ConfigChange{}
+	for field := range fields {
+		if reflect.DeepEqual(oldFields[field], newFields[field]) {
+			continue
+		}
+		changes = append(changes, ConfigChange{
+			Field: field,
+			Old:   redactedConfigValue(field, oldFields[field]),
+			New:   redactedConfigValue(field, newFields[field]),
+			Live:  isLiveConfigField(field),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes, nil
+}
+
+// secretConfigKeys names the key holding a secret in the objects of the
+// lists of settings that have one
+var secretConfigKeys = map[string]string{
+	"auth.users":       "password_hash",
+	"auth.tokens":      "token_hash",
+	"webhooks.targets": "secret",
+}
+
+// redactedConfigValue returns the JSON value of a setting as flattenConfig
+// found it, with the password and token hashes and the webhook secrets in it
+// replaced by redactedSecret, so that changes can be reported and audited
+func redactedConfigValue(field string, value interface{}) interface{} {
+	key, ok := secretConfigKeys[field]
+	list, isList := value.([]interface{})
+	if !ok || !isList {
+		return value
+	}
+
+	redacted := make([]interface{}, len(list))
+	for i, item := range list {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			redacted[i] = item
+			continue
+		}
+		copied := make(map[string]interface{}, len(object))
+		for k, v := range object {
+			copied[k] = v
+		}
+		if secret, _ := copied[key].(string); secret != "" {
+			copied[key] = redactedSecret
+		}
+		redacted[i] = copied
+	}
+	return redacted
+}
+
+// flattenConfig maps the dotted name of every setting to its JSON value;
+// lists are compared as a whole. Secrets are included, so that changing
+// them counts as a change; diffConfig redacts them in what it reports.
+func flattenConfig(config *Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		if object, ok := value.(map[string]interface{}); ok {
+			for key, child := range object {
+				walk(prefix+"."+key, child)
+			}
+			return
+		}
+		fields[prefix] = value
+	}
+	for key, value := range tree {
+		walk(key, value)
+	}
+	return fields, nil
+}
+
+func isLiveConfigField(field string) bool {
+	for _, live := range liveConfigFields {
+		if field == live || strings.HasPrefix(field, live+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// changesPrefix reports whether any change is at or below one of the fields
+func changesPrefix(changes []ConfigChange, fields ...string) bool {
+	for _, change := range changes {
+		for _, field := range fields {
+			if change.Field == field || strings.HasPrefix(change.Field, field+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyConfig makes newConfig the configuration in effect, moving the
+// listener and restarting the capture loop when their settings changed. If
+// the new address cannot be bound the old listener keeps serving and
+// oldConfig is restored. The caller holds configMu.
+func (s *Server) applyConfig(oldConfig, newConfig *Config) ([]ConfigChange, error) {
+	changes, err := diffConfig(oldConfig, newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare config: %v", err)
+	}
+
+	// Built before anything changes, since a bad user or token fails here
+	var auth *authenticator
+	if changesPrefix(changes, "auth") && newConfig.Auth.Enabled {
+		if auth, err = newAuthenticator(newConfig.Auth); err != nil {
+			return nil, fmt.Errorf("failed to set up authentication: %v", err)
+		}
+		auth.keepSessions(s.auth.Load())
+	}
+
+	s.config.Store(newConfig)
+
+	if changesPrefix(changes, "server") && s.listening() {
+		if err := s.listen(newConfig.Server); err != nil {
+			s.config.Store(oldConfig)
+			return nil, fmt.Errorf("failed to listen on %s:%d, configuration rolled back: %v", newConfig.Server.Host, newConfig.Server.Port, err)
+		}
+		fmt.Printf("Server moved to %s:%d\n", newConfig.Server.Host, newConfig.Server.Port)
+	}
+
+	if changesPrefix(changes, "auth") {
+		s.auth.Store(auth)
+		fmt.Println("Authentication settings updated")
+	}
+
+	if changesPrefix(changes, "capture.schedule") {
+		schedule, _ := newCaptureSchedule(newConfig.Capture.Schedule) // validated with the rest of the config
+		s.schedule.Store(schedule)
+	}
+
+	if changesPrefix(changes, "capture.watermark") {
+		watermark, _ := newWatermark(newConfig.Capture.Watermark) // validated with the rest of the config
+		s.watermark.Store(watermark)
+	}
+
+	if changesPrefix(changes, "capture.mode", "capture.interval") {
+		s.startRealtimeCapture()
+	}
+
+	return changes, nil
+}
+
+// restartRequired reports whether some of the changes only take effect
+// after a restart
+func restartRequired(changes []ConfigChange) bool {
+	for _, change := range changes {
+		if !change.Live {
+			return true
+		}
+	}
+	return false
+}
+
+// listener is an http.Server together with the socket it serves on
+type listener struct {
+	server *http.Server
+	ln     net.Listener
+	tls    bool // server.TLSConfig is also set for plain HTTP once it has served
+}
+
+// listen binds the HTTP(S) listener, plus the HTTP to HTTPS redirect
+// listener if configured, and starts serving on them. When it succeeds the
+// previous listeners are shut down; when it fails they keep running.
+func (s *Server) listen(config ServerConfig) error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	var tlsConfig *tls.Config
+	var certificate *selfSignedCertificate
+	if config.TLS.Enabled {
+		var err error
+		if tlsConfig, certificate, err = loadTLSConfig(config.TLS); err != nil {
+			return err
+		}
+	}
+
+	var released []*listener
+	restore := func() {
+		for _, old := range released {
+			if err := s.reopen(old); err != nil {
+				fmt.Printf("Failed to reopen %s: %v\n", old.ln.Addr(), err)
+			}
+		}
+	}
+
+	ln, err := bind(fmt.Sprintf("%s:%d", config.Host, config.Port), s.active, &released)
+	if err != nil {
+		restore()
+		return err
+	}
+	primary := &listener{server: &http.Server{Handler: s.handler, TLSConfig: tlsConfig}, ln: ln, tls: tlsConfig != nil}
+
+	var redirect *listener
+	if config.TLS.Enabled && config.TLS.RedirectPort > 0 {
+		redirectAddr := fmt.Sprintf("%s:%d", config.Host, config.TLS.RedirectPort)
+		redirectLn, err := bind(redirectAddr, s.redirect, &released)
+		if err != nil {
+			ln.Close()
+			restore()
+			return err
+		}
+		redirect = &listener{server: &http.Server{Handler: redirectToHTTPS(config.Port)}, ln: redirectLn}
+		fmt.Printf("Redirecting http://%s to HTTPS\n", redirectAddr)
+	}
+
+	old := []*listener{s.active, s.redirect}
+	s.active, s.redirect = primary, redirect
+	s.certificate.Store(certificate)
+	s.serve(primary)
+	if redirect != nil {
+		s.serve(redirect)
+	}
+
+	// The old listeners finish the requests they are serving, including the
+	// one that asked for the change, before they close
+	for _, l := range old {
+		if l != nil {
+			go shutdownServer(l.server)
+		}
+	}
+
+	return nil
+}
+
+// bind listens on addr. If a listener being replaced holds the same port, as
+// when only the host or the TLS settings change, it is closed and the bind
+// retried; it is added to released so that it can be reopened if the change
+// fails later.
+func bind(addr string, old *listener, released *[]*listener) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err == nil || old == nil {
+		return ln, err
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	_, oldPort, _ := net.SplitHostPort(old.ln.Addr().String())
+	if port != oldPort {
+		return nil, err
+	}
+
+	old.ln.Close()
+	*released = append(*released, old)
+	return net.Listen("tcp", addr)
+}
+
+// reopen binds a released listener's address again and resumes serving
+func (s *Server) reopen(l *listener) error {
+	ln, err := net.Listen("tcp", l.ln.Addr().String())
+	if err != nil {
+		return err
+	}
+	l.ln = ln
+	s.serve(l)
+	return nil
+}
+
+// listening reports whether Start has bound a listener that a config change
+// can move
+func (s *Server) listening() bool {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	return s.active != nil
+}
+
+// serve runs a listener and reports unexpected failures to Start; errors from
+// sockets closed while replacing the listener are expected
+func (s *Server) serve(l *listener) {
+	server, ln := l.server, l.ln
+	go func() {
+		var err error
+		if l.tls {
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			err = server.Serve(ln)
+		}
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+
+		s.listenMu.Lock()
+		current := (s.active != nil && s.active.ln == ln) || (s.redirect != nil && s.redirect.ln == ln)
+		s.listenMu.Unlock()
+		if current {
+			select {
+			case s.serveErr <- err:
+			default:
+			}
+		}
+	}()
+}
+
+func shutdownServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), listenerShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		// Long-lived requests such as /stream are cut off
+		server.Close()
+	}
+}
+
+// closeListeners stops serving when the server shuts down
+func (s *Server) closeListeners() {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	for _, l := range []*listener{s.active, s.redirect} {
+		if l != nil {
+			l.server.Close()
+		}
+	}
+	if s.pauseControl != nil {
+		s.pauseControl.Close()
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfigSecrets(t *testing.T) {
+	base := func() *Config {
+		config := DefaultConfig()
+		config.Auth.Users = []UserConfig{{Username: "ana", PasswordHash: "old-password-hash", Role: "admin"}}
+		config.Auth.Tokens = []TokenConfig{{Name: "ci", TokenHash: "old-token-hash", Role: "viewer"}}
+		config.Webhooks.Targets = []WebhookConfig{{URL: "https://example.com/hook", Secret: "old-webhook-secret"}}
+		return config
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		field  string
+		live   bool
+	}{
+		{"password changed", func(c *Config) { c.Auth.Users[0].PasswordHash = "new-password-hash" }, "auth.users", true},
+		{"user added", func(c *Config) {
+			c.Auth.Users = append(c.Auth.Users, UserConfig{Username: "ben", PasswordHash: "new-password-hash"})
+		}, "auth.users", true},
+		{"token replaced", func(c *Config) { c.Auth.Tokens[0].TokenHash = "new-token-hash" }, "auth.tokens", true},
+		{"tokens removed", func(c *Config) { c.Auth.Tokens = nil }, "auth.tokens", true},
+		{"webhook secret changed", func(c *Config) { c.Webhooks.Targets[0].Secret = "new-webhook-secret" }, "webhooks.targets", false},
+		{"live setting", func(c *Config) { c.Capture.Mode = "realtime" }, "capture.mode", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldConfig, newConfig := base(), base()
+			test.change(newConfig)
+
+			changes, err := diffConfig(oldConfig, newConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 1 || changes[0].Field != test.field || changes[0].Live != test.live {
+				t.Fatalf("changes %+v, want only %s", changes, test.field)
+			}
+			if restartRequired(changes) == test.live {
+				t.Errorf("restart required = %t for %s", !test.live, test.field)
+			}
+
+			reported, err := json.Marshal(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{"-hash", "-secret"} {
+				if strings.Contains(string(reported), secret) {
+					t.Errorf("reported change shows a secret: %s", reported)
+				}
+			}
+		})
+	}
+
+	// Usernames and roles stay visible next to the redacted hashes
+	oldConfig, newConfig := base(), base()
+	newConfig.Auth.Users[0].Role = "viewer"
+	changes, err := diffConfig(oldConfig, newConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, _ := changes[0].New.([]interface{})
+	if len(users) != 1 {
+		t.Fatalf("new users %#v", changes[0].New)
+	}
+	user, _ := users[0].(map[string]interface{})
+	if user["username"] != "ana" || user["role"] != "viewer" || user["password_hash"] != redactedSecret {
+		t.Errorf("reported user %v", user)
+	}
+	if oldConfig.Auth.Users[0].PasswordHash != "old-password-hash" {
+		t.Error("diffing changed the config")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Roles a user or token can have, from least to most privileged
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+var roles = []string{roleViewer, roleOperator, roleAdmin}
+
+// permission is an action guarded by a role
+type permission int
+
+const (
+	permView      permission = iota // screenshots, stream, history, archive, monitors
+	permControl                     // POST /send-text and POST /click
+	permConfigure                   // POST /config
+	permAudit                       // GET /audit
+)
+
+// rolePermissions is the permission matrix; every endpoint not listed under
+// a stronger permission only needs permView
+var rolePermissions = map[string][]permission{
+	roleViewer:   {permView},
+	roleOperator: {permView, permControl},
+	roleAdmin:    {permView, permControl, permConfigure, permAudit},
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// roleAllows reports whether role grants perm; an empty role is a viewer
+func roleAllows(role string, perm permission) bool {
+	if role == "" {
+		role = roleViewer
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the request may perform perm. Without
+// authentication everyone may do everything, as before roles existed.
+func (s *Server) allowed(r *http.Request, perm permission) bool {
+	if s.auth.Load() == nil {
+		return true
+	}
+	identity, ok := r.Context().Value(authUserKey{}).(authIdentity)
+	return ok && roleAllows(identity.Role, perm)
+}
+
+// authorize rejects the request with 403 unless it may perform perm
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, perm permission) bool {
+	if !s.allowed(r, perm) {
+		http.Error(w, "Forbidden: your role does not allow this action", http.StatusForbidden)
+		return false
+	}
+	return true
+}
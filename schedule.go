@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones also on Windows, which has no zoneinfo files
+)
+
+// errCapturePaused is returned instead of taking a frame outside the schedule
+var errCapturePaused = errors.New("capture paused by schedule")
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"} // by time.Weekday
+
+const scheduleDateLayout = "2006-01-02"
+
+// captureSchedule decides when frames may be taken. Capture is allowed
+// inside a window, or at any time without windows, unless a quiet range or
+// a holiday says otherwise.
+type captureSchedule struct {
+	location *time.Location
+	windows  []scheduleRule
+	quiet    []scheduleRule
+	holidays map[string]bool // YYYY-MM-DD dates in location
+	now      func() time.Time
+}
+
+// scheduleRule is a compiled ScheduleRule
+type scheduleRule struct {
+	days       [7]bool // by time.Weekday
+	start, end int     // minutes since midnight, end exclusive
+}
+
+// contains reports whether the minute of the day belongs to the rule. A
+// range ending at or before its start covers the evening of a listed day
+// and the morning after it.
+func (r scheduleRule) contains(day time.Weekday, minute int) bool {
+	if r.start < r.end {
+		return r.days[day] && minute >= r.start && minute < r.end
+	}
+	return (r.days[day] && minute >= r.start) || (r.days[(day+6)%7] && minute < r.end)
+}
+
+// newCaptureSchedule compiles the schedule in config, or returns nil when
+// capture is not scheduled. Problems are reported with the path of the
+// setting, as by checkConfig.
+func newCaptureSchedule(config *ScheduleConfig) (*captureSchedule, ValidationErrors) {
+	if config == nil {
+		return nil, nil
+	}
+	var problems ValidationErrors
+
+	c := &captureSchedule{location: time.Local, holidays: make(map[string]bool), now: time.Now}
+	if config.TimeZone != "" {
+		location, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			problems.add("capture.schedule.time_zone", "unknown time zone %q", config.TimeZone)
+		} else {
+			c.location = location
+		}
+	}
+
+	c.windows = compileScheduleRules(&problems, "capture.schedule.windows", config.Windows)
+	c.quiet = compileScheduleRules(&problems, "capture.schedule.quiet", config.Quiet)
+
+	for i, holiday := range config.Holidays {
+		date, err := time.Parse(scheduleDateLayout, holiday)
+		if err != nil {
+			problems.add(fmt.Sprintf("capture.schedule.holidays[%d]", i), "must be a date as YYYY-MM-DD")
+			continue
+		}
+		c.holidays[date.Format(scheduleDateLayout)] = true
+	}
+
+	return c, problems
+}
+
+func compileScheduleRules(problems *ValidationErrors, path string, rules []ScheduleRule) []scheduleRule {
+	compiled := make([]scheduleRule, 0, len(rules))
+	for i, rule := range rules {
+		rulePath := fmt.Sprintf("%s[%d]", path, i)
+		var r scheduleRule
+		if len(rule.Days) == 0 {
+			r.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		for j, day := range rule.Days {
+			index := indexOf(scheduleDays, strings.ToLower(day))
+			if index < 0 {
+				problems.add(fmt.Sprintf("%s.days[%d]", rulePath, j), "must be one of %s", strings.Join(scheduleDays, ", "))
+				continue
+			}
+			r.days[index] = true
+		}
+
+		var err error
+		if r.start, err = parseClock(rule.Start); err != nil || r.start == 24*60 {
+			problems.add(rulePath+".start", "must be a time of day as HH:MM")
+		}
+		if r.end, err = parseClock(rule.End); err != nil {
+			problems.add(rulePath+".end", "must be a time of day as HH:MM, or 24:00")
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled
+}
+
+// parseClock reads HH:MM as minutes since midnight, allowing 24:00
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || !ok || len(minutes) != 2 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return h*60 + m, nil
+}
+
+func indexOf(list []string, value string) int {
+	for i, item := range list {
+		if item == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// allowedAt reports whether capture is allowed at t
+func (c *captureSchedule) allowedAt(t time.Time) bool {
+	t = t.In(c.location)
+	if c.holidays[t.Format(scheduleDateLayout)] {
+		return false
+	}
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+
+	inWindow := len(c.windows) == 0
+	for _, rule := range c.windows {
+		if rule.contains(day, minute) {
+			inWindow = true
+			break
+		}
+	}
+	if !inWindow {
+		return false
+	}
+	for _, rule := range c.quiet {
+		if rule.contains(day, minute) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextAllowed returns the first time after t at which capture is allowed,
+// or false if there is none within a year. That can only change where a
+// rule starts or ends or at midnight, so only those times are checked.
+func (c *captureSchedule) nextAllowed(t time.Time) (time.Time, bool) {
+	minutes := []int{0}
+	for _, rule := range append(append([]scheduleRule{}, c.windows...), c.quiet...) {
+		minutes = append(minutes, rule.start, rule.end)
+	}
+	sort.Ints(minutes)
+
+	t = t.In(c.location)
+	year, month, day := t.Date()
+	for d := 0; d <= 366; d++ {
+		for _, minute := range minutes {
+			candidate := time.Date(year, month, day+d, 0, minute, 0, 0, c.location)
+			if candidate.After(t) && c.allowedAt(candidate) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// scheduleStatus is reported by GET /schedule and shown in the web interface
+type scheduleStatus struct {
+	Scheduled bool       `json:"scheduled"` // false when capture is allowed at any time
+	Paused    bool       `json:"paused"`
+	Resumes   *time.Time `json:"resumes,omitempty"` // while paused, when capture is next allowed
+	TimeZone  string     `json:"time_zone,omitempty"`
+	checked   time.Time
+}
+
+// status evaluates the schedule at the current time; a nil schedule never
+// pauses capture
+func (c *captureSchedule) status() scheduleStatus {
+	if c == nil {
+		return scheduleStatus{checked: time.Now()}
+	}
+
+	now := c.now()
+	status := scheduleStatus{Scheduled: true, TimeZone: c.location.String(), checked: now}
+	if !c.allowedAt(now) {
+		status.Paused = true
+		if resumes, ok := c.nextAllowed(now); ok {
+			status.Resumes = &resumes
+		}
+	}
+	return status
+}
+
+// captureAllowed reports whether the schedule allows capture now, logging
+// when it pauses or resumes capture
+func (s *Server) captureAllowed() bool {
+	status := s.schedule.Load().status()
+
+	s.mu.Lock()
+	changed := status.Paused != s.schedulePaused
+	s.schedulePaused = status.Paused
+	s.mu.Unlock()
+
+	if changed && status.Paused {
+		fmt.Printf("Capture paused by schedule%s\n", resumesText(status))
+	} else if changed {
+		fmt.Println("Capture resumed by schedule")
+	}
+	return !status.Paused
+}
+
+func resumesText(status scheduleStatus) string {
+	if status.Resumes == nil {
+		return ""
+	}
+	return ", resumes at " + status.Resumes.Format(time.RFC3339)
+}
+
+// writeCapturePaused answers a request for a new frame outside the schedule
+func writeCapturePaused(w http.ResponseWriter, status scheduleStatus) {
+	if status.Resumes != nil {
+		seconds := math.Ceil(status.Resumes.Sub(status.checked).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(seconds, 1))))
+	}
+	http.Error(w, "Capture paused by schedule"+resumesText(status), http.StatusServiceUnavailable)
+}
+
+// handleSchedule reports whether capture is paused by the schedule and
+// when it resumes
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.schedule.Load().status())
+}
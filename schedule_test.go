@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// compileSchedule compiles config for a test, failing on any problem
+func compileSchedule(t *testing.T, config *ScheduleConfig) *captureSchedule {
+	t.Helper()
+	schedule, problems := newCaptureSchedule(config)
+	if len(problems) > 0 {
+		t.Fatalf("invalid schedule: %v", problems)
+	}
+	return schedule
+}
+
+func TestScheduleStatus(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// October 2026: the 12th is a Monday, summer time ends on Sunday the 25th
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name     string
+		config   ScheduleConfig
+		now      time.Time
+		resumes  time.Time // zero when capture is allowed
+		noResume bool      // paused with nothing allowed within a year
+	}{
+		// Office hours on weekdays with a lunch break and a holiday on Wednesday the 14th
+		{name: "before the window", config: officeHours, now: at(12, 7, 59), resumes: at(12, 8, 0)},
+		{name: "window start", config: officeHours, now: at(12, 8, 0)},
+		{name: "in another time zone", config: officeHours, now: time.Date(2026, time.October, 12, 6, 30, 0, 0, time.UTC)},
+		{name: "before lunch", config: officeHours, now: at(12, 11, 59)},
+		{name: "lunch", config: officeHours, now: at(12, 12, 0), resumes: at(12, 13, 0)},
+		{name: "after lunch", config: officeHours, now: at(12, 13, 0)},
+		{name: "last minute of the window", config: officeHours, now: at(12, 17, 59)},
+		{name: "window end", config: officeHours, now: at(12, 18, 0), resumes: at(13, 8, 0)},
+		{name: "evening before the holiday", config: officeHours, now: at(13, 18, 0), resumes: at(15, 8, 0)},
+		{name: "holiday", config: officeHours, now: at(14, 10, 0), resumes: at(15, 8, 0)},
+		{name: "friday evening", config: officeHours, now: at(16, 18, 0), resumes: at(19, 8, 0)},
+		{name: "saturday", config: officeHours, now: at(17, 10, 0), resumes: at(19, 8, 0)},
+		{name: "sunday night", config: officeHours, now: at(18, 23, 59), resumes: at(19, 8, 0)},
+
+		// Quiet from Friday 22:00 to Saturday 06:00, capture at any other time
+		{name: "thursday night", config: fridayNight, now: at(15, 23, 0)},
+		{name: "friday just after midnight", config: fridayNight, now: at(16, 0, 30)},
+		{name: "before quiet", config: fridayNight, now: at(16, 21, 59)},
+		{name: "quiet start", config: fridayNight, now: at(16, 22, 0), resumes: at(17, 6, 0)},
+		{name: "past midnight", config: fridayNight, now: at(17, 5, 59), resumes: at(17, 6, 0)},
+		{name: "quiet end", config: fridayNight, now: at(17, 6, 0)},
+		{name: "saturday night", config: fridayNight, now: at(17, 22, 0)},
+
+		// A window from Saturday 20:00 to Sunday 02:00, with Sunday the 25th a holiday
+		{name: "before the night window", config: saturdayNight, now: at(17, 19, 59), resumes: at(17, 20, 0)},
+		{name: "night window", config: saturdayNight, now: at(17, 23, 0)},
+		{name: "night window past midnight", config: saturdayNight, now: at(18, 1, 59)},
+		{name: "night window end", config: saturdayNight, now: at(18, 2, 0), resumes: at(24, 20, 0)},
+		{name: "night window before a holiday", config: saturdayNight, now: at(24, 23, 59)},
+		{name: "night window on a holiday", config: saturdayNight, now: at(25, 1, 0), resumes: at(31, 20, 0)},
+
+		{name: "never allowed", config: ScheduleConfig{TimeZone: "Europe/Berlin", Quiet: []ScheduleRule{{Start: "00:00", End: "24:00"}}}, now: at(12, 10, 0), noResume: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule := compileSchedule(t, &test.config)
+			schedule.now = func() time.Time { return test.now }
+
+			status := schedule.status()
+			if !status.Scheduled || status.TimeZone != "Europe/Berlin" {
+				t.Errorf("status %+v, want scheduled in Europe/Berlin", status)
+			}
+			wantPaused := test.noResume || !test.resumes.IsZero()
+			if status.Paused != wantPaused {
+				t.Fatalf("paused = %t, want %t", status.Paused, wantPaused)
+			}
+			switch {
+			case !wantPaused || test.noResume:
+				if status.Resumes != nil {
+					t.Errorf("resumes %v, want none", status.Resumes)
+				}
+			case status.Resumes == nil:
+				t.Errorf("no resume time, want %v", test.resumes)
+			case !status.Resumes.Equal(test.resumes):
+				t.Errorf("resumes %v, want %v", status.Resumes.In(berlin), test.resumes)
+			}
+		})
+	}
+}
+
+var (
+	officeHours = ScheduleConfig{
+		TimeZone: "Europe/Berlin",
+		Windows:  []ScheduleRule{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"}},
+		Quiet:    []ScheduleRule{{Start: "12:00", End: "13:00"}},
+		Holidays: []string{"2026-10-14"},
+	}
+	fridayNight = ScheduleConfig{
+		TimeZone: "Europe/Berlin",
+		Quiet:    []ScheduleRule{{Days: []string{"Fri"}, Start: "22:00", End: "06:00"}},
+	}
+	saturdayNight = ScheduleConfig{
+		TimeZone: "Europe/Berlin",
+		Windows:  []ScheduleRule{{Days: []string{"sat"}, Start: "20:00", End: "02:00"}},
+		Holidays: []string{"2026-10-25"},
+	}
+)
+
+func TestUnscheduledStatus(t *testing.T) {
+	var schedule *captureSchedule
+	if status := schedule.status(); status.Scheduled || status.Paused {
+		t.Errorf("status %+v, want unscheduled", status)
+	}
+}
+
+func TestScheduleProblems(t *testing.T) {
+	_, problems := newCaptureSchedule(&ScheduleConfig{
+		TimeZone: "Mars/Olympus",
+		Windows:  []ScheduleRule{{Days: []string{"mon", "someday"}, Start: "24:00", End: "9:5"}},
+		Quiet:    []ScheduleRule{{Start: "12:00", End: "24:01"}},
+		Holidays: []string{"2026-10-14", "14.10.2026"},
+	})
+
+	want := []string{
+		"capture.schedule.time_zone",
+		"capture.schedule.windows[0].days[1]",
+		"capture.schedule.windows[0].start",
+		"capture.schedule.windows[0].end",
+		"capture.schedule.quiet[0].end",
+		"capture.schedule.holidays[1]",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems %v, want %d", problems, len(want))
+	}
+	for i, field := range want {
+		if problems[i].Field != field {
+			t.Errorf("problem %d for %s, want %s", i, problems[i].Field, field)
+		}
+	}
+}
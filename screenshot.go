@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Encodings available for screenshots served over HTTP
+const (
+	formatPNG  = "png"
+	formatJPEG = "jpeg"
+
+	defaultJPEGQuality = 80
+)
+
+type ScreenRegion struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type Screenshot struct {
+	Width  int
+	Height int
+	Data   []byte
+	Region *ScreenRegion // nil for full screen
+}
+
+type ScreenshotOptions struct {
+	Region    *ScreenRegion
+	Monitor   int // 0 for the whole desktop, otherwise a monitor ID; Region is then relative to the monitor
+	Compress  bool
+	MaxWidth  int
+	MaxHeight int
+	Format    string // "png" (default) or "jpeg"
+	Quality   int    // 1-100, only for JPEG; 0 selects the default
+
+	NoWatermark bool            // leave out capture.watermark, only for admins and never stored
+	Watermark   *watermarkLabel // burned in after resizing so that it stays legible, nil for none
+}
+
+func (s *Screenshot) ToImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			offset := (y*s.Width + x) * 4
+			if offset+3 < len(s.Data) {
+				b := s.Data[offset]
+				g := s.Data[offset+1]
+				r := s.Data[offset+2]
+				a := s.Data[offset+3]
+				img.Set(x, y, color.RGBA{r, g, b, a})
+			}
+		}
+	}
+
+	return img
+}
+
+func (s *Screenshot) ToCompressedImage(maxWidth, maxHeight int) image.Image {
+	img := s.ToImage()
+
+	if maxWidth <= 0 && maxHeight <= 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	originalWidth := bounds.Dx()
+	originalHeight := bounds.Dy()
+
+	if maxWidth <= 0 {
+		maxWidth = originalWidth
+	}
+	if maxHeight <= 0 {
+		maxHeight = originalHeight
+	}
+
+	// No compression needed if image is already smaller
+	if originalWidth <= maxWidth && originalHeight <= maxHeight {
+		return img
+	}
+
+	// Calculate aspect ratio preserving dimensions
+	scaleX := float64(maxWidth) / float64(originalWidth)
+	scaleY := float64(maxHeight) / float64(originalHeight)
+	scale := scaleX
+	if scaleY < scaleX {
+		scale = scaleY
+	}
+
+	newWidth := int(float64(originalWidth) * scale)
+	newHeight := int(float64(originalHeight) * scale)
+
+	// Create new image with calculated dimensions
+	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+	// Bilinear interpolation for better quality
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			srcX := float64(x) / scale
+			srcY := float64(y) / scale
+
+			// Get the four surrounding pixels
+			x1 := int(srcX)
+			y1 := int(srcY)
+			x2 := x1 + 1
+			y2 := y1 + 1
+
+			// Clamp coordinates
+			if x1 < 0 {
+				x1 = 0
+			}
+			if y1 < 0 {
+				y1 = 0
+			}
+			if x2 >= originalWidth {
+				x2 = originalWidth - 1
+			}
+			if y2 >= originalHeight {
+				y2 = originalHeight - 1
+			}
+
+			// Calculate interpolation weights
+			dx := srcX - float64(x1)
+			dy := srcY - float64(y1)
+
+			// Get the four pixels
+			p11 := img.At(x1, y1)
+			p12 := img.At(x1, y2)
+			p21 := img.At(x2, y1)
+			p22 := img.At(x2, y2)
+
+			// Convert to RGBA
+			r11, g11, b11, a11 := p11.RGBA()
+			r12, g12, b12, a12 := p12.RGBA()
+			r21, g21, b21, a21 := p21.RGBA()
+			r22, g22, b22, a22 := p22.RGBA()
+
+			// Perform bilinear interpolation
+			r := (1-dx)*(1-dy)*float64(r11) + dx*(1-dy)*float64(r21) + (1-dx)*dy*float64(r12) + dx*dy*float64(r22)
+			g := (1-dx)*(1-dy)*float64(g11) + dx*(1-dy)*float64(g21) + (1-dx)*dy*float64(g12) + dx*dy*float64(g22)
+			b := (1-dx)*(1-dy)*float64(b11) + dx*(1-dy)*float64(b21) + (1-dx)*dy*float64(b12) + dx*dy*float64(b22)
+			a := (1-dx)*(1-dy)*float64(a11) + dx*(1-dy)*float64(a21) + (1-dx)*dy*float64(a12) + dx*dy*float64(a22)
+
+			// Convert back to 8-bit and set pixel
+			resized.Set(x, y, color.RGBA{
+				uint8(r / 257), // Convert from 16-bit to 8-bit
+				uint8(g / 257),
+				uint8(b / 257),
+				uint8(a / 257),
+			})
+		}
+	}
+
+	return resized
+}
+
+func (s *Screenshot) SaveToPNG(filename string) error {
+	img := s.ToImage()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, img)
+}
+
+func (s *Screenshot) ToPNGBytes() ([]byte, error) {
+	img := s.ToImage()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *Screenshot) ToPNGBytesWithOptions(opts *ScreenshotOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, s.imageWithOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EncodeWithOptions resizes the screenshot as requested by opts and encodes
+// it in opts.Format
+func (s *Screenshot) EncodeWithOptions(opts *ScreenshotOptions) ([]byte, error) {
+	format, quality := formatPNG, defaultJPEGQuality
+	if opts != nil {
+		var ok bool
+		if format, ok = normalizeImageFormat(opts.Format); !ok {
+			return nil, fmt.Errorf("unsupported image format: %s", opts.Format)
+		}
+		if opts.Quality > 0 {
+			quality = min(opts.Quality, 100)
+		}
+	}
+
+	if format == formatPNG {
+		return s.ToPNGBytesWithOptions(opts)
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, s.imageWithOptions(opts), &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *Screenshot) imageWithOptions(opts *ScreenshotOptions) image.Image {
+	var img image.Image
+	if opts != nil && opts.Compress && (opts.MaxWidth > 0 || opts.MaxHeight > 0) {
+		img = s.ToCompressedImage(opts.MaxWidth, opts.MaxHeight)
+	} else {
+		img = s.ToImage()
+	}
+
+	if opts != nil && opts.Watermark != nil {
+		if dst, ok := img.(draw.Image); ok {
+			opts.Watermark.draw(dst)
+		}
+	}
+	return img
+}
+
+// normalizeImageFormat maps the accepted spellings of a format name to its
+// canonical form; the empty string selects PNG
+func normalizeImageFormat(format string) (string, bool) {
+	switch strings.ToLower(format) {
+	case "", "png":
+		return formatPNG, true
+	case "jpeg", "jpg":
+		return formatJPEG, true
+	}
+	return "", false
+}
+
+// imageContentType returns the MIME type of a canonical format name
+func imageContentType(format string) string {
+	if format == formatJPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// screenshotFromImage converts any image into the BGRA layout used by Screenshot
+func screenshotFromImage(img image.Image) *Screenshot {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	data := make([]byte, width*height*4)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.RGBA)
+			offset := (y*width + x) * 4
+			data[offset] = c.B
+			data[offset+1] = c.G
+			data[offset+2] = c.R
+			data[offset+3] = c.A
+		}
+	}
+
+	return &Screenshot{
+		Width:  width,
+		Height: height,
+		Data:   data,
+	}
+}
+
+// clampRegion clips a region to a screen of the given size, mirroring the
+// clamping done by the native Windows capture. It reports false if nothing
+// of the region remains visible.
+func clampRegion(region ScreenRegion, width, height int) (ScreenRegion, bool) {
+	if region.X < 0 {
+		region.Width += region.X
+		region.X = 0
+	}
+	if region.Y < 0 {
+		region.Height += region.Y
+		region.Y = 0
+	}
+	if region.X+region.Width > width {
+		region.Width = width - region.X
+	}
+	if region.Y+region.Height > height {
+		region.Height = height - region.Y
+	}
+
+	return region, region.Width > 0 && region.Height > 0
+}
+
+// Crop returns the part of the screenshot covered by region, in the same
+// coordinates as the screenshot itself. A nil region returns s unchanged.
+func (s *Screenshot) Crop(region *ScreenRegion) (*Screenshot, error) {
+	if region == nil {
+		return s, nil
+	}
+
+	clamped, ok := clampRegion(*region, s.Width, s.Height)
+	if !ok {
+		return nil, fmt.Errorf("region %dx%d at (%d, %d) is outside the screen", region.Width, region.Height, region.X, region.Y)
+	}
+
+	data := make([]byte, clamped.Width*clamped.Height*4)
+	for y := 0; y < clamped.Height; y++ {
+		src := ((clamped.Y+y)*s.Width + clamped.X) * 4
+		copy(data[y*clamped.Width*4:(y+1)*clamped.Width*4], s.Data[src:src+clamped.Width*4])
+	}
+
+	return &Screenshot{
+		Width:  clamped.Width,
+		Height: clamped.Height,
+		Data:   data,
+		Region: region,
+	}, nil
+}
+
+// SaveScreenshotToFile captures the full screen with the given capturer and
+// writes it to a timestamped PNG in the working directory.
+func SaveScreenshotToFile(capturer Capturer) (string, error) {
+	screenshot, err := capturer.Capture(nil)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	filename := fmt.Sprintf("screenshot_%s.png", timestamp)
+
+	err = screenshot.SaveToPNG(filename)
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return filename, nil
+	}
+
+	return absPath, nil
+}
@@ -4,31 +4,10 @@ package main
 
 import (
     "fmt"
+    "os"
     "runtime"
 )
 
-type ScreenRegion struct {
-    X      int
-    Y      int
-    Width  int
-    Height int
-}
-
-type Screenshot struct {
-    Width  int
-    Height int
-    Data   []byte
-    Region *ScreenRegion
-}
-
-type ScreenshotOptions struct {
-    Region     *ScreenRegion
-    Compress   bool
-    MaxWidth   int
-    MaxHeight  int
-    Quality    int
-}
-
 func TakeScreenshot() (*Screenshot, error) {
     return nil, fmt.Errorf("screenshot functionality is only supported on Windows, current OS: %s", runtime.GOOS)
 }
@@ -41,20 +20,20 @@ func TakeRegionScreenshot(x, y, width, height int) (*Screenshot, error) {
     return nil, fmt.Errorf("screenshot functionality is only supported on Windows, current OS: %s", runtime.GOOS)
 }
 
-func (s *Screenshot) SaveToPNG(filename string) error {
-    return fmt.Errorf("screenshot functionality is only supported on Windows")
-}
-
-func (s *Screenshot) ToPNGBytes() ([]byte, error) {
-    return nil, fmt.Errorf("screenshot functionality is only supported on Windows")
-}
-
-func (s *Screenshot) ToPNGBytesWithOptions(opts *ScreenshotOptions) ([]byte, error) {
-    return nil, fmt.Errorf("screenshot functionality is only supported on Windows")
-}
-
-func SaveScreenshotToFile() (string, error) {
-    return "", fmt.Errorf("screenshot functionality is only supported on Windows, current OS: %s", runtime.GOOS)
+// newNativeCapturer uses the X server named by $DISPLAY when there is one and
+// falls back to the Linux framebuffer console otherwise
+func newNativeCapturer() (Capturer, error) {
+    if os.Getenv("DISPLAY") != "" {
+        return newX11Capturer("", false), nil
+    }
+    if runtime.GOOS == "linux" {
+        if _, err := os.Stat(defaultFramebufferDevice); err == nil {
+            return newFramebufferCapturer(FramebufferConfig{}), nil
+        }
+    }
+    return &unsupportedCapturer{
+        reason: fmt.Sprintf("no native screen capture available on %s: DISPLAY is not set and there is no %s", runtime.GOOS, defaultFramebufferDevice),
+    }, nil
 }
 
 // SetClipboardText sets text to clipboard (not supported on non-Windows)
@@ -3,7 +3,7 @@
 package main
 
 /*
-#cgo LDFLAGS: -lgdi32 -luser32 -lshcore
+#cgo LDFLAGS: -lgdi32 -luser32
 #include <windows.h>
 #include <wingdi.h>
 
@@ -142,6 +142,98 @@ ScreenshotData* takeRegionScreenshot(int x, int y, int width, int height) {
     return result;
 }
 
+// Get virtual screen bounds, falling back to the primary screen
+void getScreenBounds(int* x, int* y, int* width, int* height) {
+    SetProcessDPIAware();
+    
+    *width = GetSystemMetrics(SM_CXVIRTUALSCREEN);
+    *height = GetSystemMetrics(SM_CYVIRTUALSCREEN);
+    *x = GetSystemMetrics(SM_XVIRTUALSCREEN);
+    *y = GetSystemMetrics(SM_YVIRTUALSCREEN);
+    
+    if (*width == 0 || *height == 0) {
+        *width = GetSystemMetrics(SM_CXSCREEN);
+        *height = GetSystemMetrics(SM_CYSCREEN);
+        *x = 0;
+        *y = 0;
+    }
+}
+
+typedef struct {
+    int x;
+    int y;
+    int width;
+    int height;
+    int primary;
+    int dpi;
+    char name[64];
+} MonitorInfo;
+
+// GetDpiForMonitor is only available from Windows 8.1, so it is looked up at runtime
+typedef HRESULT (WINAPI *GetDpiForMonitorFunc)(HMONITOR, int, UINT*, UINT*);
+
+typedef struct {
+    MonitorInfo* monitors;
+    int count;
+    int capacity;
+    GetDpiForMonitorFunc getDpiForMonitor;
+} MonitorEnumState;
+
+static BOOL CALLBACK monitorEnumProc(HMONITOR hMonitor, HDC hdc, LPRECT rect, LPARAM lParam) {
+    MonitorEnumState* state = (MonitorEnumState*)lParam;
+    if (state->count >= state->capacity) {
+        return FALSE;
+    }
+    
+    MONITORINFOEXW info;
+    info.cbSize = sizeof(info);
+    if (!GetMonitorInfoW(hMonitor, (LPMONITORINFO)&info)) {
+        return TRUE;
+    }
+    
+    MonitorInfo* monitor = &state->monitors[state->count++];
+    monitor->x = info.rcMonitor.left;
+    monitor->y = info.rcMonitor.top;
+    monitor->width = info.rcMonitor.right - info.rcMonitor.left;
+    monitor->height = info.rcMonitor.bottom - info.rcMonitor.top;
+    monitor->primary = (info.dwFlags & MONITORINFOF_PRIMARY) != 0;
+    monitor->dpi = 96;
+    
+    UINT dpiX, dpiY;
+    // 0 is MDT_EFFECTIVE_DPI
+    if (state->getDpiForMonitor && state->getDpiForMonitor(hMonitor, 0, &dpiX, &dpiY) == S_OK) {
+        monitor->dpi = dpiX;
+    }
+    
+    WideCharToMultiByte(CP_UTF8, 0, info.szDevice, -1, monitor->name, sizeof(monitor->name), NULL, NULL);
+    
+    return TRUE;
+}
+
+// Enumerate display monitors in virtual screen coordinates
+int enumMonitors(MonitorInfo* monitors, int capacity) {
+    SetProcessDPIAware();
+    
+    MonitorEnumState state;
+    state.monitors = monitors;
+    state.count = 0;
+    state.capacity = capacity;
+    state.getDpiForMonitor = NULL;
+    
+    HMODULE shcore = LoadLibraryA("shcore.dll");
+    if (shcore) {
+        state.getDpiForMonitor = (GetDpiForMonitorFunc)GetProcAddress(shcore, "GetDpiForMonitor");
+    }
+    
+    EnumDisplayMonitors(NULL, NULL, monitorEnumProc, (LPARAM)&state);
+    
+    if (shcore) {
+        FreeLibrary(shcore);
+    }
+    
+    return state.count;
+}
+
 void freeScreenshot(ScreenshotData* screenshot) {
     if (screenshot) {
         if (screenshot->data) {
@@ -301,39 +393,11 @@ void simulateMouseClick(int x, int y) {
 */
 import "C"
 import (
-    "bytes"
     "fmt"
-    "image"
-    "image/color"
-    "image/png"
-    "os"
-    "path/filepath"
     "time"
     "unsafe"
 )
 
-type ScreenRegion struct {
-    X      int
-    Y      int
-    Width  int
-    Height int
-}
-
-type Screenshot struct {
-    Width  int
-    Height int
-    Data   []byte
-    Region *ScreenRegion // nil for full screen
-}
-
-type ScreenshotOptions struct {
-    Region     *ScreenRegion
-    Compress   bool
-    MaxWidth   int
-    MaxHeight  int
-    Quality    int // 1-100, only for JPEG (not used for PNG but kept for future)
-}
-
 func TakeScreenshot() (*Screenshot, error) {
     return TakeScreenshotWithOptions(&ScreenshotOptions{})
 }
@@ -387,177 +451,58 @@ func TakeRegionScreenshot(x, y, width, height int) (*Screenshot, error) {
     })
 }
 
-func (s *Screenshot) ToImage() *image.RGBA {
-    img := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
-    
-    for y := 0; y < s.Height; y++ {
-        for x := 0; x < s.Width; x++ {
-            offset := (y*s.Width + x) * 4
-            if offset+3 < len(s.Data) {
-                b := s.Data[offset]
-                g := s.Data[offset+1]
-                r := s.Data[offset+2]
-                a := s.Data[offset+3]
-                img.Set(x, y, color.RGBA{r, g, b, a})
-            }
-        }
-    }
-    
-    return img
-}
-
-func (s *Screenshot) ToCompressedImage(maxWidth, maxHeight int) image.Image {
-    img := s.ToImage()
-    
-    if maxWidth <= 0 && maxHeight <= 0 {
-        return img
-    }
-    
-    bounds := img.Bounds()
-    originalWidth := bounds.Dx()
-    originalHeight := bounds.Dy()
-    
-    if maxWidth <= 0 {
-        maxWidth = originalWidth
-    }
-    if maxHeight <= 0 {
-        maxHeight = originalHeight
-    }
-    
-    // No compression needed if image is already smaller
-    if originalWidth <= maxWidth && originalHeight <= maxHeight {
-        return img
-    }
-    
-    // Calculate aspect ratio preserving dimensions
-    scaleX := float64(maxWidth) / float64(originalWidth)
-    scaleY := float64(maxHeight) / float64(originalHeight)
-    scale := scaleX
-    if scaleY < scaleX {
-        scale = scaleY
-    }
-    
-    newWidth := int(float64(originalWidth) * scale)
-    newHeight := int(float64(originalHeight) * scale)
-    
-    // Create new image with calculated dimensions
-    resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-    
-    // Bilinear interpolation for better quality
-    for y := 0; y < newHeight; y++ {
-        for x := 0; x < newWidth; x++ {
-            srcX := float64(x) / scale
-            srcY := float64(y) / scale
-            
-            // Get the four surrounding pixels
-            x1 := int(srcX)
-            y1 := int(srcY)
-            x2 := x1 + 1
-            y2 := y1 + 1
-            
-            // Clamp coordinates
-            if x1 < 0 { x1 = 0 }
-            if y1 < 0 { y1 = 0 }
-            if x2 >= originalWidth { x2 = originalWidth - 1 }
-            if y2 >= originalHeight { y2 = originalHeight - 1 }
-            
-            // Calculate interpolation weights
-            dx := srcX - float64(x1)
-            dy := srcY - float64(y1)
-            
-            // Get the four pixels
-            p11 := img.At(x1, y1)
-            p12 := img.At(x1, y2)
-            p21 := img.At(x2, y1)
-            p22 := img.At(x2, y2)
-            
-            // Convert to RGBA
-            r11, g11, b11, a11 := p11.RGBA()
-            r12, g12, b12, a12 := p12.RGBA()
-            r21, g21, b21, a21 := p21.RGBA()
-            r22, g22, b22, a22 := p22.RGBA()
-            
-            // Perform bilinear interpolation
-            r := (1-dx)*(1-dy)*float64(r11) + dx*(1-dy)*float64(r21) + (1-dx)*dy*float64(r12) + dx*dy*float64(r22)
-            g := (1-dx)*(1-dy)*float64(g11) + dx*(1-dy)*float64(g21) + (1-dx)*dy*float64(g12) + dx*dy*float64(g22)
-            b := (1-dx)*(1-dy)*float64(b11) + dx*(1-dy)*float64(b21) + (1-dx)*dy*float64(b12) + dx*dy*float64(b22)
-            a := (1-dx)*(1-dy)*float64(a11) + dx*(1-dy)*float64(a21) + (1-dx)*dy*float64(a12) + dx*dy*float64(a22)
-            
-            // Convert back to 8-bit and set pixel
-            resized.Set(x, y, color.RGBA{
-                uint8(r / 257), // Convert from 16-bit to 8-bit
-                uint8(g / 257),
-                uint8(b / 257),
-                uint8(a / 257),
-            })
-        }
-    }
-    
-    return resized
-}
+// gdiCapturer captures the Windows virtual screen through GDI
+type gdiCapturer struct{}
 
-func (s *Screenshot) SaveToPNG(filename string) error {
-    img := s.ToImage()
-    
-    file, err := os.Create(filename)
-    if err != nil {
-        return err
-    }
-    defer file.Close()
-    
-    return png.Encode(file, img)
+func newNativeCapturer() (Capturer, error) {
+    return &gdiCapturer{}, nil
 }
 
-func (s *Screenshot) ToPNGBytes() ([]byte, error) {
-    img := s.ToImage()
-    
-    var buf bytes.Buffer
-    err := png.Encode(&buf, img)
-    if err != nil {
-        return nil, err
-    }
-    
-    return buf.Bytes(), nil
+func (c *gdiCapturer) Capture(region *ScreenRegion) (*Screenshot, error) {
+    return TakeScreenshotWithOptions(&ScreenshotOptions{Region: region})
 }
 
-func (s *Screenshot) ToPNGBytesWithOptions(opts *ScreenshotOptions) ([]byte, error) {
-    var img image.Image
+func (c *gdiCapturer) Bounds() (ScreenRegion, error) {
+    var x, y, width, height C.int
+    C.getScreenBounds(&x, &y, &width, &height)
     
-    if opts != nil && opts.Compress && (opts.MaxWidth > 0 || opts.MaxHeight > 0) {
-        img = s.ToCompressedImage(opts.MaxWidth, opts.MaxHeight)
-    } else {
-        img = s.ToImage()
+    if width <= 0 || height <= 0 {
+        return ScreenRegion{}, fmt.Errorf("failed to get screen bounds")
     }
     
-    var buf bytes.Buffer
-    err := png.Encode(&buf, img)
-    if err != nil {
-        return nil, err
-    }
-    
-    return buf.Bytes(), nil
+    return ScreenRegion{
+        X:      int(x),
+        Y:      int(y),
+        Width:  int(width),
+        Height: int(height),
+    }, nil
 }
 
-func SaveScreenshotToFile() (string, error) {
-    screenshot, err := TakeScreenshot()
-    if err != nil {
-        return "", err
+// Monitors lists the display monitors of the virtual screen
+func (c *gdiCapturer) Monitors() ([]Monitor, error) {
+    var infos [16]C.MonitorInfo
+    count := int(C.enumMonitors(&infos[0], C.int(len(infos))))
+    if count <= 0 {
+        return nil, fmt.Errorf("failed to enumerate monitors")
     }
     
-    timestamp := time.Now().Format("20060102_150405")
-    filename := fmt.Sprintf("screenshot_%s.png", timestamp)
-    
-    err = screenshot.SaveToPNG(filename)
-    if err != nil {
-        return "", err
-    }
-    
-    absPath, err := filepath.Abs(filename)
-    if err != nil {
-        return filename, nil
+    monitors := make([]Monitor, 0, count)
+    for _, info := range infos[:count] {
+        monitors = append(monitors, Monitor{
+            Name: C.GoString(&info.name[0]),
+            Bounds: ScreenRegion{
+                X:      int(info.x),
+                Y:      int(info.y),
+                Width:  int(info.width),
+                Height: int(info.height),
+            },
+            Primary: info.primary != 0,
+            Scale:   float64(info.dpi) / 96,
+        })
     }
     
-    return absPath, nil
+    numberMonitors(monitors)
+    return monitors, nil
 }
 
 // SetClipboardText sets text to Windows clipboard
@@ -4,24 +4,56 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server struct {
-	config         *Config
+	config         atomic.Pointer[Config] // replaced as a whole, never modified once stored
+	configMu       sync.Mutex             // serializes configuration updates
+	overrides      configOverrides        // environment variables and flags applied to the config file
 	configFile     string
+	capturer       Capturer
 	lastScreenshot []byte
+	lastType       string // Content-Type of lastScreenshot
 	lastUpdate     time.Time
+	lastID         uint64 // history ID of lastScreenshot, 0 if not recorded
+	history        *frameHistory
+	archive        *frameArchive    // nil when archiving is disabled
+	change         *changeDetector  // nil when change detection is disabled
+	webhooks       *webhookNotifier // nil when no webhooks are configured
+	audit          *auditLog        // nil when the audit log is disabled
+	captureFailing bool             // the last capture failed, so the error was already reported
 	mu             sync.RWMutex
+	auth           atomic.Pointer[authenticator]   // nil when authentication is disabled
+	schedule       atomic.Pointer[captureSchedule] // nil when capture is not scheduled
+	schedulePaused bool                            // the schedule paused capture, which was already logged
+	watermark      atomic.Pointer[watermark]       // nil when capture.watermark is disabled
+	pause          *privacyPause
+	pauseControl   *http.Server // the loopback pause control, nil until Start or when disabled
 	stopChan       chan struct{}
+	loopMu         sync.Mutex
+	captureStop    chan struct{} // stops the running realtime capture loop
+	handler        http.Handler
+	listenMu       sync.Mutex
+	active         *listener // the HTTP(S) listener, nil until Start
+	redirect       *listener // nil unless TLS redirects plain HTTP
+	serveErr       chan error
+	certificate    atomic.Pointer[selfSignedCertificate] // nil unless the HTTPS listener uses a generated certificate
+	stream         *frameHub
 	template       *template.Template
 }
 
-func NewServer(config *Config, configFile string) *Server {
+func NewServer(config *Config, configFile string, capturer Capturer) *Server {
 	tmpl, err := template.ParseFiles("templates/index.html")
 	if err != nil {
 		// If template file doesn't exist, create a basic embedded template
@@ -37,76 +69,210 @@ func NewServer(config *Config, configFile string) *Server {
 </html>`))
 	}
 
-	return &Server{
-		config:     config,
+	maxFrames := 0
+	if config.History.Enabled {
+		maxFrames = config.History.MaxFrames
+	}
+
+	var change *changeDetector
+	if config.ChangeDetection.Enabled {
+		change = newChangeDetector(config.ChangeDetection)
+	}
+
+	s := &Server{
 		configFile: configFile,
+		history:    newFrameHistory(maxFrames, config.History.MaxMB*1024*1024),
+		change:     change,
 		stopChan:   make(chan struct{}),
+		serveErr:   make(chan error, 1),
+		stream:     newFrameHub(),
 		template:   tmpl,
 	}
+	s.config.Store(config)
+	// New /stream clients must not be shown the pause placeholder once it ended
+	s.pause = newPrivacyPause(s.stream.dropLatest)
+	s.capturer = newMaskingCapturer(capturer, func() []MaskConfig {
+		return s.currentConfig().Capture.Masks
+	})
+	schedule, _ := newCaptureSchedule(config.Capture.Schedule) // validated when the config was loaded
+	s.schedule.Store(schedule)
+	watermark, _ := newWatermark(config.Capture.Watermark) // validated when the config was loaded
+	s.watermark.Store(watermark)
+	return s
+}
+
+// currentConfig returns the configuration snapshot in effect. Callers read
+// it once per request or capture so that they see consistent settings, and
+// must not modify it.
+func (s *Server) currentConfig() *Config {
+	return s.config.Load()
 }
 
 func (s *Server) updateScreenshot() error {
-	return s.updateScreenshotWithOptions(nil)
+	return s.updateScreenshotWithOptions(s.currentConfig(), nil)
 }
 
-func (s *Server) updateScreenshotWithOptions(opts *ScreenshotOptions) error {
-	var screenshot *Screenshot
-	var err error
+func (s *Server) updateScreenshotWithOptions(config *Config, opts *ScreenshotOptions) error {
+	if s.pause.status().Paused {
+		return errPrivacyPaused
+	}
+	if !s.captureAllowed() {
+		return errCapturePaused
+	}
 
-	if opts != nil {
-		screenshot, err = TakeScreenshotWithOptions(opts)
-	} else {
+	// Only frames taken with the configured options are streamed
+	isDefault := opts == nil
+	if opts == nil {
 		// Use config settings for default options
-		opts = &ScreenshotOptions{
-			Region:    nil,
-			Compress:  s.config.Capture.Compression.Enabled,
-			MaxWidth:  s.config.Capture.Compression.MaxWidth,
-			MaxHeight: s.config.Capture.Compression.MaxHeight,
-		}
-
-		// Apply region from config if set
-		if s.config.Capture.Region != nil {
-			opts.Region = &ScreenRegion{
-				X:      s.config.Capture.Region.X,
-				Y:      s.config.Capture.Region.Y,
-				Width:  s.config.Capture.Region.Width,
-				Height: s.config.Capture.Region.Height,
-			}
-		}
-
-		screenshot, err = TakeScreenshotWithOptions(opts)
+		opts = defaultScreenshotOptions(config)
 	}
 
+	screenshot, err := s.capture(opts)
+	now := time.Now()
+	if isDefault {
+		// Only the first of a run of failures is reported
+		s.mu.Lock()
+		report := err != nil && !s.captureFailing
+		s.captureFailing = err != nil
+		s.mu.Unlock()
+		if report {
+			s.notify(webhookEventCaptureError, map[string]string{"error": err.Error()}, 0, nil, nil)
+		}
+	}
 	if err != nil {
 		return err
 	}
+	// The screen may have been grabbed after a pause began
+	if s.pause.status().Paused {
+		return errPrivacyPaused
+	}
 
-	pngData, err := screenshot.ToPNGBytesWithOptions(opts)
+	format, ok := normalizeImageFormat(opts.Format)
+	if !ok {
+		return fmt.Errorf("unsupported image format: %s", opts.Format)
+	}
+
+	// Stored frames always carry the watermark, overlay=0 is only served
+	// directly by writeUnmarkedFrame
+	opts.Watermark = s.watermark.Load().label(now, opts.Monitor)
+	imageData, err := screenshot.EncodeWithOptions(opts)
 	if err != nil {
 		return err
 	}
 
+	// Unchanged frames are still served by /last but are not stored or streamed
+	store := true
+	if isDefault && s.change != nil {
+		result := s.change.compare(screenshot, opts, now)
+		if result.Changed && !result.reset {
+			s.notify(webhookEventChange, result, result.Score, screenshot, opts.Watermark)
+		}
+		if !result.Changed && config.ChangeDetection.SkipUnchanged {
+			store = false
+			s.change.skip()
+		}
+	}
+
+	var id uint64
+	if store {
+		id = s.history.add(imageData, imageContentType(format), now)
+		if s.archive != nil {
+			if err := s.archive.store(imageData, imageContentType(format), now); err != nil {
+				fmt.Printf("Failed to archive screenshot: %v\n", err)
+			}
+		}
+	}
+
 	s.mu.Lock()
-	s.lastScreenshot = pngData
-	s.lastUpdate = time.Now()
+	s.lastScreenshot = imageData
+	s.lastType = imageContentType(format)
+	s.lastUpdate = now
+	s.lastID = id
 	s.mu.Unlock()
 
+	if isDefault && store {
+		s.publishStreamFrame(screenshot, opts, imageData, format)
+	}
+
 	return nil
 }
 
+// defaultScreenshotOptions builds the options for a capture from the config
+func defaultScreenshotOptions(config *Config) *ScreenshotOptions {
+	opts := &ScreenshotOptions{
+		Region:    nil,
+		Monitor:   config.Capture.Monitor,
+		Compress:  config.Capture.Compression.Enabled,
+		MaxWidth:  config.Capture.Compression.MaxWidth,
+		MaxHeight: config.Capture.Compression.MaxHeight,
+		Format:    config.Capture.Compression.Format,
+		Quality:   config.Capture.Compression.Quality,
+	}
+
+	// Apply region from config if set
+	if config.Capture.Region != nil {
+		opts.Region = &ScreenRegion{
+			X:      config.Capture.Region.X,
+			Y:      config.Capture.Region.Y,
+			Width:  config.Capture.Region.Width,
+			Height: config.Capture.Region.Height,
+		}
+	}
+
+	return opts
+}
+
+// capture grabs the region and monitor selected by opts
+func (s *Server) capture(opts *ScreenshotOptions) (*Screenshot, error) {
+	if opts.Monitor == 0 {
+		return s.capturer.Capture(opts.Region)
+	}
+
+	monitors, err := listMonitors(s.capturer)
+	if err != nil {
+		return nil, err
+	}
+	monitor, err := findMonitor(monitors, opts.Monitor)
+	if err != nil {
+		return nil, err
+	}
+	desktop, err := s.capturer.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	region, err := monitorRegion(desktop, monitor, opts.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.capturer.Capture(region)
+}
+
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
 	}
 
+	config := s.currentConfig()
+
 	// Prepare template data
 	data := struct {
 		Config          *Config
 		IntervalSeconds int
+		Schedule        scheduleStatus
+		Pause           pauseStatus
+		User            string
+		CanControl      bool
+		CanConfigure    bool
 	}{
-		Config:          s.config,
-		IntervalSeconds: int(s.config.Capture.Interval.Seconds()),
+		Config:          config,
+		IntervalSeconds: int(config.Capture.Interval.Seconds()),
+		Schedule:        s.schedule.Load().status(),
+		Pause:           s.pause.status(),
+		User:            requestUser(r),
+		CanControl:      s.allowed(r, permControl),
+		CanConfigure:    s.allowed(r, permConfigure),
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -117,18 +283,62 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleLast(w http.ResponseWriter, r *http.Request) {
+	// Outside the schedule no frame is served, not even the last one taken
+	if status := s.schedule.Load().status(); status.Paused {
+		writeCapturePaused(w, status)
+		return
+	}
+
+	config := s.currentConfig()
+
 	// Parse query parameters for custom screenshot options
-	opts := s.parseScreenshotOptions(r.URL.Query())
+	opts := parseScreenshotOptions(config, r.URL.Query())
+
+	// Without an explicit format, let the Accept header override the configured one
+	if r.URL.Query().Get("format") == "" {
+		configFormat, _ := normalizeImageFormat(config.Capture.Compression.Format)
+		if format := negotiateImageFormat(r.Header.Get("Accept")); format != "" && format != configFormat {
+			if opts == nil {
+				opts = defaultScreenshotOptions(config)
+			}
+			opts.Format = format
+		}
+	}
+
+	// Frames without the watermark are taken afresh, only for admins, and
+	// never stored
+	if r.URL.Query().Get("overlay") == "0" {
+		if !s.authorize(w, r, permConfigure) {
+			return
+		}
+		if opts == nil {
+			opts = defaultScreenshotOptions(config)
+		}
+		opts.NoWatermark = true
+	}
+
+	// While paused for privacy viewers see that, not the last frame taken
+	if status := s.pause.status(); status.Paused {
+		if opts == nil {
+			opts = defaultScreenshotOptions(config)
+		}
+		s.writePausePlaceholder(w, status, opts)
+		return
+	}
+	if opts != nil && opts.NoWatermark {
+		s.writeUnmarkedFrame(w, opts)
+		return
+	}
 
-	if s.config.Capture.Mode == "ondemand" {
-		err := s.updateScreenshotWithOptions(opts)
+	if config.Capture.Mode == "ondemand" {
+		err := s.updateScreenshotWithOptions(config, opts)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to capture screenshot: %v", err), http.StatusInternalServerError)
 			return
 		}
 	} else if opts != nil {
 		// In realtime mode, if custom options are provided, take a fresh screenshot
-		err := s.updateScreenshotWithOptions(opts)
+		err := s.updateScreenshotWithOptions(config, opts)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to capture screenshot with custom options: %v", err), http.StatusInternalServerError)
 			return
@@ -138,7 +348,9 @@ func (s *Server) handleLast(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	screenshot := make([]byte, len(s.lastScreenshot))
 	copy(screenshot, s.lastScreenshot)
+	contentType := s.lastType
 	lastUpdate := s.lastUpdate
+	lastID := s.lastID
 	s.mu.RUnlock()
 
 	if len(screenshot) == 0 {
@@ -146,19 +358,65 @@ func (s *Server) handleLast(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
 	w.Header().Set("Last-Modified", lastUpdate.UTC().Format(http.TimeFormat))
+	if lastID != 0 {
+		w.Header().Set("X-Frame-Id", strconv.FormatUint(lastID, 10))
+	}
 
 	w.Write(screenshot)
 }
 
-func (s *Server) parseScreenshotOptions(params url.Values) *ScreenshotOptions {
-	opts := &ScreenshotOptions{}
+// writeUnmarkedFrame answers overlay=0 with a fresh frame without the
+// watermark. It is not stored, so history, the archive, /stream and later
+// requests for /last only ever see watermarked frames.
+func (s *Server) writeUnmarkedFrame(w http.ResponseWriter, opts *ScreenshotOptions) {
+	if !s.captureAllowed() {
+		writeCapturePaused(w, s.schedule.Load().status())
+		return
+	}
+
+	screenshot, err := s.capture(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to capture screenshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+	format, ok := normalizeImageFormat(opts.Format)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported image format: %s", opts.Format), http.StatusBadRequest)
+		return
+	}
+	data, err := screenshot.EncodeWithOptions(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode screenshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", imageContentType(format))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Write(data)
+}
+
+func parseScreenshotOptions(config *Config, params url.Values) *ScreenshotOptions {
+	opts := &ScreenshotOptions{
+		Monitor: config.Capture.Monitor,
+		Format:  config.Capture.Compression.Format,
+		Quality: config.Capture.Compression.Quality,
+	}
 	hasCustomOptions := false
 
+	if monitor := params.Get("monitor"); monitor != "" {
+		if monitorVal, err := strconv.Atoi(monitor); err == nil && monitorVal >= 0 {
+			opts.Monitor = monitorVal
+			hasCustomOptions = true
+		}
+	}
+
 	// Parse region parameters
 	if x := params.Get("x"); x != "" {
 		if xVal, err := strconv.Atoi(x); err == nil {
@@ -224,6 +482,21 @@ func (s *Server) parseScreenshotOptions(params url.Values) *ScreenshotOptions {
 		}
 	}
 
+	// Parse encoding parameters
+	if format := params.Get("format"); format != "" {
+		if formatVal, ok := normalizeImageFormat(format); ok {
+			opts.Format = formatVal
+			hasCustomOptions = true
+		}
+	}
+
+	if quality := params.Get("quality"); quality != "" {
+		if qualityVal, err := strconv.Atoi(quality); err == nil && qualityVal >= 1 && qualityVal <= 100 {
+			opts.Quality = qualityVal
+			hasCustomOptions = true
+		}
+	}
+
 	if !hasCustomOptions {
 		return nil
 	}
@@ -231,12 +504,67 @@ func (s *Server) parseScreenshotOptions(params url.Values) *ScreenshotOptions {
 	return opts
 }
 
+// negotiateImageFormat picks PNG or JPEG from an Accept header, returning ""
+// when the header does not prefer one over the other
+func negotiateImageFormat(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		qualities[mediaType] = q
+	}
+
+	// The most specific matching range decides
+	score := func(mediaType string) float64 {
+		for _, candidate := range []string{mediaType, "image/*", "*/*"} {
+			if q, ok := qualities[candidate]; ok {
+				return q
+			}
+		}
+		return 0
+	}
+
+	pngScore, jpegScore := score("image/png"), score("image/jpeg")
+	switch {
+	case jpegScore > pngScore:
+		return formatJPEG
+	case pngScore > jpegScore:
+		return formatPNG
+	}
+	return ""
+}
+
+// startRealtimeCapture starts the capture loop for the current settings,
+// stopping the loop started for the previous ones
 func (s *Server) startRealtimeCapture() {
-	if s.config.Capture.Mode != "realtime" {
+	s.loopMu.Lock()
+	defer s.loopMu.Unlock()
+
+	if s.captureStop != nil {
+		close(s.captureStop)
+		s.captureStop = nil
+	}
+
+	config := s.currentConfig()
+	if config.Capture.Mode != "realtime" {
 		return
 	}
 
-	ticker := time.NewTicker(s.config.Capture.Interval)
+	stop := make(chan struct{})
+	s.captureStop = stop
+	ticker := time.NewTicker(config.Capture.Interval)
 	go func() {
 		defer ticker.Stop()
 
@@ -246,9 +574,11 @@ func (s *Server) startRealtimeCapture() {
 			select {
 			case <-ticker.C:
 				err := s.updateScreenshot()
-				if err != nil {
+				if err != nil && err != errCapturePaused && err != errPrivacyPaused {
 					fmt.Printf("Failed to capture screenshot: %v\n", err)
 				}
+			case <-stop:
+				return
 			case <-s.stopChan:
 				return
 			}
@@ -256,119 +586,319 @@ func (s *Server) startRealtimeCapture() {
 	}()
 }
 
+// Handler returns the HTTP handler serving the web interface and API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
+	mux.HandleFunc("/last", s.handleLast)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/preview", s.handlePreview)
+	mux.HandleFunc("/masks/preview", s.handleMaskPreview)
+	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("/frames", s.handleFrames)
+	mux.HandleFunc("/frames/{id}", s.handleFrame)
+	mux.HandleFunc("/changes", s.handleChanges)
+	mux.HandleFunc("/archive", s.handleArchive)
+	mux.HandleFunc("/archive/files/{path...}", s.handleArchiveFile)
+	mux.HandleFunc("/screen-info", s.handleScreenInfo)
+	mux.HandleFunc("/monitors", s.handleMonitors)
+	mux.HandleFunc("/schedule", s.handleSchedule)
+	mux.HandleFunc("/audit", s.handleAudit)
+	mux.HandleFunc("/send-text", s.handleSendText)
+	mux.HandleFunc("/click", s.handleClick)
+	return s.requireAuth(mux)
+}
+
 func (s *Server) Start() error {
-	http.HandleFunc("/", s.handleIndex)
-	http.HandleFunc("/last", s.handleLast)
-	http.HandleFunc("/config", s.handleConfig)
-	http.HandleFunc("/preview", s.handlePreview)
-	http.HandleFunc("/screen-info", s.handleScreenInfo)
-	http.HandleFunc("/send-text", s.handleSendText)
-	http.HandleFunc("/click", s.handleClick)
+	s.handler = s.Handler()
 
 	s.startRealtimeCapture()
 
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
+	config := s.currentConfig()
+	addr := fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port)
+
+	if s.webhooks != nil {
+		go s.webhooks.run(s.stopChan)
+	}
+	if s.archive != nil {
+		go s.archive.run(s.stopChan)
+	}
+	if s.configFile != "" {
+		go s.watchConfig(s.stopChan)
+	}
+	go s.renewCertificate(s.stopChan)
+	if config.Pause.Enabled {
+		// Capture works without the pause control, only -pause does not
+		if err := s.listenPauseControl(config.Pause); err != nil {
+			fmt.Printf("Privacy pause control unavailable: %v\n", err)
+		}
+	}
+	s.notify(webhookEventStart, map[string]interface{}{"address": addr, "tls": config.Server.TLS.Enabled, "mode": config.Capture.Mode}, 0, nil, nil)
 	fmt.Printf("Starting server on %s\n", addr)
-	fmt.Printf("Mode: %s\n", s.config.Capture.Mode)
-	if s.config.Capture.Mode == "realtime" {
-		fmt.Printf("Capture interval: %v\n", s.config.Capture.Interval)
+	fmt.Printf("Mode: %s\n", config.Capture.Mode)
+	if config.Capture.Mode == "realtime" {
+		fmt.Printf("Capture interval: %v\n", config.Capture.Interval)
 	}
 
-	return http.ListenAndServe(addr, nil)
+	if err := s.listen(config.Server); err != nil {
+		return err
+	}
+	select {
+	case err := <-s.serveErr:
+		return err
+	case <-s.stopChan:
+		return nil
+	}
 }
 
 func (s *Server) handleScreenInfo(w http.ResponseWriter, r *http.Request) {
-	// Take a minimal screenshot to get actual screen dimensions
-	screenshot, err := TakeScreenshot()
+	bounds, err := s.capturer.Bounds()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get screen info: %v", err), http.StatusInternalServerError)
 		return
 	}
-	
+
 	screenInfo := map[string]int{
-		"width":  screenshot.Width,
-		"height": screenshot.Height,
+		"x":      bounds.X,
+		"y":      bounds.Y,
+		"width":  bounds.Width,
+		"height": bounds.Height,
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(screenInfo)
 }
 
+func (s *Server) handleMonitors(w http.ResponseWriter, r *http.Request) {
+	monitors, err := listMonitors(s.capturer)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list monitors: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(monitors)
+}
+
 func (s *Server) Stop() {
+	s.notify(webhookEventStop, nil, 0, nil, nil)
+	if s.webhooks != nil {
+		s.webhooks.flush(webhookFlushTimeout)
+	}
 	close(s.stopChan)
+	s.closeListeners()
 }
 
 func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		// Return current configuration
+		// Return current configuration, without the password and token
+		// hashes and webhook secrets
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(s.config)
+		json.NewEncoder(w).Encode(redactedConfig(s.currentConfig()))
 		return
 	}
 
 	if r.Method == "POST" {
-		// Update configuration
-		var newConfig Config
-		err := json.NewDecoder(r.Body).Decode(&newConfig)
+		if !s.authorize(w, r, permConfigure) {
+			s.recordAudit(r, auditActionConfig, nil, errForbidden)
+			return
+		}
+
+		// One update at a time, so none is lost and a rollback restores
+		// the configuration it replaced
+		s.configMu.Lock()
+		defer s.configMu.Unlock()
+
+		// Settings left out of the request keep their current values
+		oldConfig := s.currentConfig()
+		newConfig, err := copyConfig(oldConfig)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to copy config: %v", err), http.StatusInternalServerError)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, newConfig)
+		}
 		if err != nil {
+			s.recordAudit(r, auditActionConfig, nil, fmt.Errorf("invalid JSON: %v", err))
 			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 			return
 		}
 
-		// Validate the new configuration
-		if newConfig.Server.Port < 1 || newConfig.Server.Port > 65535 {
-			http.Error(w, "Invalid port number", http.StatusBadRequest)
+		// Users and tokens can only be changed in the config file
+		newConfig.Auth = oldConfig.Auth
+
+		// Validate the new configuration, reporting every problem at once
+		problems := append(unknownConfigFields(body), keepWebhookSecrets(oldConfig, newConfig)...)
+		problems = append(problems, checkConfig(newConfig)...)
+		if len(problems) > 0 {
+			s.recordAudit(r, auditActionConfig, redactedConfig(newConfig), problems)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status": "error",
+				"errors": problems,
+			})
 			return
 		}
 
-		if newConfig.Capture.Mode != "ondemand" && newConfig.Capture.Mode != "realtime" {
-			http.Error(w, "Invalid capture mode", http.StatusBadRequest)
+		changes, err := s.applyConfig(oldConfig, newConfig)
+		if err != nil {
+			s.recordAudit(r, auditActionConfig, redactedConfig(newConfig), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Update in-memory configuration
-		s.mu.Lock()
-		oldMode := s.config.Capture.Mode
-		s.config = &newConfig
-		s.mu.Unlock()
-
 		// Save to file if requested
 		if r.URL.Query().Get("save") == "true" {
-			err = SaveConfig(&newConfig, s.configFile)
+			err = s.saveConfigUpdate(body)
 			if err != nil {
+				s.recordAudit(r, auditActionConfig, redactedConfig(newConfig), fmt.Errorf("applied but not saved: %v", err))
 				http.Error(w, fmt.Sprintf("Failed to save config: %v", err), http.StatusInternalServerError)
 				return
 			}
 		}
-
-		// Restart realtime capture if mode changed
-		if oldMode != newConfig.Capture.Mode {
-			// Note: In a more sophisticated implementation, we might restart the capture goroutine
-			// For now, we'll just update the config and let the user restart if needed
-		}
+		s.recordAudit(r, auditActionConfig, map[string]interface{}{
+			"config":  redactedConfig(newConfig),
+			"changes": changes,
+			"saved":   r.URL.Query().Get("save") == "true",
+		}, nil)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":           "success",
+			"changes":          changes,
+			"restart_required": restartRequired(changes),
+		})
 		return
 	}
 
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// saveConfigUpdate writes the settings posted to /config to the config
+// file. They are applied to the settings in the file rather than to those in
+// effect, so that values set by environment variables and flags stay out of
+// the file and keep taking precedence over it.
+func (s *Server) saveConfigUpdate(body []byte) error {
+	data, err := os.ReadFile(s.configFile)
+	if err != nil {
+		return err
+	}
+	data, err = decodeConfigFile(s.configFile, data)
+	if err != nil {
+		return err
+	}
+	fileConfig, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	saved, err := copyConfig(fileConfig)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, saved); err != nil {
+		return err
+	}
+
+	// Users and tokens can only be changed in the config file. Redacted
+	// secrets were checked against the targets in effect; a target whose
+	// secret is not in the file stays without one there.
+	saved.Auth = fileConfig.Auth
+	keepWebhookSecrets(fileConfig, saved)
+
+	return SaveConfig(saved, s.configFile)
+}
+
+// redactedSecret stands in for webhook secrets, and for password and token
+// hashes in reported changes, wherever configuration is shown to clients or
+// recorded in the audit log
+const redactedSecret = "********"
+
+// redactedConfig returns a copy of config without the password and token
+// hashes, and with webhook secrets replaced by redactedSecret
+func redactedConfig(config *Config) Config {
+	redacted := *config
+	redacted.Auth.Users = nil
+	redacted.Auth.Tokens = nil
+	redacted.Webhooks.Targets = slices.Clone(config.Webhooks.Targets)
+	for i := range redacted.Webhooks.Targets {
+		if redacted.Webhooks.Targets[i].Secret != "" {
+			redacted.Webhooks.Targets[i].Secret = redactedSecret
+		}
+	}
+	return redacted
+}
+
+// keepWebhookSecrets puts back the secrets of targets posted with
+// redactedSecret, as GET /config shows them, from the current target with
+// the same URL
+func keepWebhookSecrets(oldConfig, newConfig *Config) ValidationErrors {
+	var problems ValidationErrors
+	for i := range newConfig.Webhooks.Targets {
+		target := &newConfig.Webhooks.Targets[i]
+		if target.Secret != redactedSecret {
+			continue
+		}
+		target.Secret = ""
+		for _, old := range oldConfig.Webhooks.Targets {
+			if old.URL == target.URL && old.Secret != "" {
+				target.Secret = old.Secret
+				break
+			}
+		}
+		if target.Secret == "" {
+			problems.add(fmt.Sprintf("webhooks.targets[%d].secret", i), "is redacted, but no target with this URL has a secret to keep")
+		}
+	}
+	return problems
+}
+
 func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
-	// Take a screenshot for preview purposes (always full screen, compressed)
+	// Take a screenshot for preview purposes (always full screen or monitor, compressed)
 	opts := &ScreenshotOptions{
 		Region:    nil, // Always full screen for preview
+		Monitor:   s.currentConfig().Capture.Monitor,
 		Compress:  true,
 		MaxWidth:  800, // Small preview size
 		MaxHeight: 600,
 	}
 
-	screenshot, err := TakeScreenshotWithOptions(opts)
+	if monitor := r.URL.Query().Get("monitor"); monitor != "" {
+		monitorVal, err := strconv.Atoi(monitor)
+		if err != nil || monitorVal < 0 {
+			http.Error(w, "Invalid monitor", http.StatusBadRequest)
+			return
+		}
+		opts.Monitor = monitorVal
+	}
+	if r.URL.Query().Get("overlay") == "0" {
+		if !s.authorize(w, r, permConfigure) {
+			return
+		}
+		opts.NoWatermark = true
+	}
+
+	if status := s.pause.status(); status.Paused {
+		s.writePausePlaceholder(w, status, opts)
+		return
+	}
+	if !s.captureAllowed() {
+		writeCapturePaused(w, s.schedule.Load().status())
+		return
+	}
+
+	screenshot, err := s.capture(opts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to capture preview: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if !opts.NoWatermark {
+		opts.Watermark = s.watermark.Load().label(time.Now(), opts.Monitor)
+	}
 
 	pngData, err := screenshot.ToPNGBytesWithOptions(opts)
 	if err != nil {
@@ -387,6 +917,10 @@ func (s *Server) handleSendText(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !s.authorize(w, r, permControl) {
+		s.recordAudit(r, auditActionSendText, nil, errForbidden)
+		return
+	}
 
 	type TextRequest struct {
 		Text string `json:"text"`
@@ -395,16 +929,22 @@ func (s *Server) handleSendText(w http.ResponseWriter, r *http.Request) {
 	var req TextRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
+		s.recordAudit(r, auditActionSendText, nil, fmt.Errorf("invalid JSON: %v", err))
 		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	if req.Text == "" {
+		s.recordAudit(r, auditActionSendText, s.auditTextParams(req.Text), fmt.Errorf("text cannot be empty"))
 		http.Error(w, "Text cannot be empty", http.StatusBadRequest)
 		return
 	}
 
+	// The text itself is left out, it may be a password
+	s.notify(webhookEventInput, map[string]interface{}{"action": "text", "length": len([]rune(req.Text)), "user": requestUser(r)}, 0, nil, nil)
+
 	err = SendTextToClipboardAndPaste(req.Text)
+	s.recordAudit(r, auditActionSendText, s.auditTextParams(req.Text), err)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to send text: %v", err), http.StatusInternalServerError)
 		return
@@ -420,6 +960,10 @@ func (s *Server) handleClick(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !s.authorize(w, r, permControl) {
+		s.recordAudit(r, auditActionClick, nil, errForbidden)
+		return
+	}
 
 	type ClickRequest struct {
 		X int `json:"x"`
@@ -429,11 +973,15 @@ func (s *Server) handleClick(w http.ResponseWriter, r *http.Request) {
 	var req ClickRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
+		s.recordAudit(r, auditActionClick, nil, fmt.Errorf("invalid JSON: %v", err))
 		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	s.notify(webhookEventInput, map[string]interface{}{"action": "click", "x": req.X, "y": req.Y, "user": requestUser(r)}, 0, nil, nil)
+
 	SimulateMouseClick(req.X, req.Y)
+	s.recordAudit(r, auditActionClick, map[string]int{"x": req.X, "y": req.Y}, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer serves a synthetic desktop with the default configuration,
+// changed by configure, on a local test listener
+func newTestServer(t *testing.T, configure func(*Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	config := DefaultConfig()
+	config.Capture.Source = "synthetic"
+	config.Audit.Enabled = false
+	if configure != nil {
+		configure(config)
+	}
+	if problems := checkConfig(config); len(problems) > 0 {
+		t.Fatalf("invalid test config: %v", problems)
+	}
+
+	s := NewServer(config, "", newSyntheticCapturer(320, 240, nil))
+	if config.Auth.Enabled {
+		auth, err := newAuthenticator(config.Auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.auth.Store(auth)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Stop()
+	})
+	return s, ts
+}
+
+// waitForFrame waits until /last has a frame to serve
+func waitForFrame(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		resp, err := http.Get(ts.URL + "/last")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return
+		}
+	}
+	t.Fatal("no frame captured")
+}
+
+// TestConfigUpdatesUnderLoad changes the configuration while /last, /preview
+// and /stream are being served and the realtime loop is capturing. Run it
+// with -race: every reader must see one consistent configuration snapshot.
+func TestConfigUpdatesUnderLoad(t *testing.T) {
+	s, ts := newTestServer(t, func(config *Config) {
+		config.Capture.Mode = "realtime"
+		config.Capture.Interval = 5 * time.Millisecond
+	})
+	s.startRealtimeCapture()
+	waitForFrame(t, ts)
+
+	updates := []string{
+		`{"capture": {"compression": {"enabled": true, "format": "jpeg", "quality": 50, "max_width": 160, "max_height": 120}}}`,
+		`{"capture": {"mode": "ondemand", "region": {"x": 10, "y": 10, "width": 100, "height": 80}}}`,
+		`{"capture": {"mode": "realtime", "interval": "3ms", "region": null}}`,
+		`{"capture": {"masks": [{"x": 0, "y": 0, "width": 50, "height": 50, "style": "blur"}]}}`,
+		`{"capture": {"watermark": {"enabled": true}, "compression": {"enabled": false, "format": "png"}}}`,
+		`{"capture": {"masks": [], "watermark": {"enabled": false}}, "change_detection": {"skip_unchanged": false}}`,
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				for _, path := range []string{"/last", "/last?format=jpeg", "/preview", "/frames", "/config"} {
+					resp, err := http.Get(ts.URL + path)
+					if err != nil {
+						report(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						report(fmt.Errorf("GET %s: %s", path, resp.Status))
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := http.Get(ts.URL + "/stream?fps=30")
+		if err != nil {
+			report(err)
+			return
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 32*1024)
+		for time.Now().Before(deadline) {
+			if _, err := resp.Body.Read(buf); err != nil {
+				report(fmt.Errorf("stream: %v", err))
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; time.Now().Before(deadline); i++ {
+			update := updates[i%len(updates)]
+			resp, err := http.Post(ts.URL+"/config", "application/json", strings.NewReader(update))
+			if err != nil {
+				report(err)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				report(fmt.Errorf("POST /config %s: %s %s", update, resp.Status, body))
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConfigHidesWebhookSecrets(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	s, ts := newTestServer(t, func(config *Config) {
+		config.Audit.Enabled = true
+		config.Audit.Path = auditLog
+		config.Webhooks.Targets = []WebhookConfig{{URL: receiver.URL, Secret: "s3cret"}}
+	})
+	audit, err := openAuditLog(s.currentConfig().Audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.audit = audit
+
+	resp, err := http.Get(ts.URL + "/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(shown), "s3cret") {
+		t.Fatalf("GET /config shows the secret: %s", shown)
+	}
+	var config Config
+	if err := json.Unmarshal(shown, &config); err != nil {
+		t.Fatal(err)
+	}
+	if secret := config.Webhooks.Targets[0].Secret; secret != redactedSecret {
+		t.Errorf("secret shown as %q, want %q", secret, redactedSecret)
+	}
+
+	// Posting the webhooks back as shown, with a second target, keeps the secret
+	config.Webhooks.Targets = append(config.Webhooks.Targets, WebhookConfig{URL: receiver.URL + "/other", Secret: "other-s3cret"})
+	update, _ := json.Marshal(map[string]interface{}{"webhooks": config.Webhooks})
+	resp, err = http.Post(ts.URL+"/config", "application/json", bytes.NewReader(update))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /config: %s %s", resp.Status, body)
+	}
+	targets := s.currentConfig().Webhooks.Targets
+	if len(targets) != 2 || targets[0].Secret != "s3cret" || targets[1].Secret != "other-s3cret" {
+		t.Errorf("targets after update %+v, want the secret kept", targets)
+	}
+
+	// A redacted secret for a target without one cannot be kept
+	update = []byte(`{"webhooks": {"targets": [{"url": "` + receiver.URL + `/third", "secret": "********"}]}}`)
+	resp, err = http.Post(ts.URL+"/config", "application/json", bytes.NewReader(update))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(body), "webhooks.targets[0].secret") {
+		t.Errorf("POST /config with an unknown redacted secret: %s %s", resp.Status, body)
+	}
+
+	logged, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(logged), "s3cret") {
+		t.Errorf("audit log shows a secret: %s", logged)
+	}
+}
+
+// cornerGray decodes an encoded frame and returns the red channel of its
+// bottom-right pixel, where the watermark goes by default
+func cornerGray(t *testing.T, data []byte) uint8 {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+	return color.RGBAModel.Convert(img.At(bounds.Max.X-1, bounds.Max.Y-1)).(color.RGBA).R
+}
+
+func TestUnmarkedFramesAreNotStored(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	config := DefaultConfig()
+	config.Audit.Enabled = false
+	config.Capture.Watermark.Enabled = true
+	config.ChangeDetection.SkipUnchanged = false
+	config.Webhooks.Targets = []WebhookConfig{{URL: receiver.URL, Thumbnail: true}}
+	if problems := checkConfig(config); len(problems) > 0 {
+		t.Fatalf("invalid test config: %v", problems)
+	}
+
+	gray, white := color.RGBA{128, 128, 128, 255}, color.RGBA{255, 255, 255, 255}
+	s := NewServer(config, "", writeReplayFrames(t, solidImage(320, 240, gray), solidImage(320, 240, white)))
+	webhooks, err := newWebhookNotifier(WebhooksConfig{Outbox: t.TempDir(), Targets: config.Webhooks.Targets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.webhooks = webhooks
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Stop()
+	})
+
+	get := func(path string) []byte {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s %s", path, resp.Status, data)
+		}
+		return data
+	}
+
+	// The unmarked gray frame goes to the admin who asked for it, and nowhere else
+	if corner := cornerGray(t, get("/last?overlay=0")); corner != 128 {
+		t.Errorf("overlay=0 corner %d, want the unmarked 128", corner)
+	}
+	s.mu.RLock()
+	last := s.lastScreenshot
+	s.mu.RUnlock()
+	if count, _ := s.history.usage(); count != 0 || last != nil {
+		t.Fatalf("overlay=0 frame stored: %d in history, last %d bytes", count, len(last))
+	}
+
+	// Frames taken for everyone else carry the watermark wherever they end up
+	if corner := cornerGray(t, get("/last")); corner >= 128 {
+		t.Errorf("white frame corner %d, want it darkened by the watermark", corner)
+	}
+	get("/last") // the gray frame, a change from the white one
+	s.mu.RLock()
+	last = s.lastScreenshot
+	s.mu.RUnlock()
+	if corner := cornerGray(t, last); corner >= 100 {
+		t.Errorf("stored gray frame corner %d, want it darkened by the watermark", corner)
+	}
+	if count, _ := s.history.usage(); count != 2 {
+		t.Errorf("%d frames in history, want 2", count)
+	}
+
+	webhooks.deliverDue(context.Background())
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d webhook requests, want the change event", len(requests))
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(requests[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, err := base64.StdEncoding.DecodeString(payload.Thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corner := cornerGray(t, thumbnail); corner >= 100 {
+		t.Errorf("thumbnail corner %d, want it darkened by the watermark", corner)
+	}
+}
+
+func TestSaveConfigLeavesOverridesOut(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	fileConfig := DefaultConfig()
+	fileConfig.Server.Port = 8080
+	fileConfig.Capture.Source = "synthetic"
+	fileConfig.History.MaxFrames = 10
+	fileConfig.Audit.Enabled = false
+	if err := SaveConfig(fileConfig, file); err != nil {
+		t.Fatal(err)
+	}
+
+	overrides := configOverrides{
+		{field: "server.port", value: "9999", source: sourceEnv, name: "DSC_SERVER_PORT"},
+		{field: "capture.interval", value: "250ms", source: sourceFlag, name: "-capture.interval"},
+	}
+	config, err := LoadConfig(file, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(config, file, newSyntheticCapturer(320, 240, nil))
+	s.overrides = overrides
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Stop()
+	})
+
+	update := `{"capture": {"mode": "realtime", "compression": {"quality": 40}}}`
+	resp, err := http.Post(ts.URL+"/config?save=true", "application/json", strings.NewReader(update))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /config?save=true: %s %s", resp.Status, body)
+	}
+
+	saved, err := LoadConfig(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Server.Port != 8080 || saved.Capture.Interval != fileConfig.Capture.Interval {
+		t.Errorf("saved port %d and interval %v, want the file's %d and %v", saved.Server.Port, saved.Capture.Interval, 8080, fileConfig.Capture.Interval)
+	}
+	if saved.Capture.Mode != "realtime" || saved.Capture.Compression.Quality != 40 {
+		t.Errorf("saved mode %q and quality %d, want the posted realtime and 40", saved.Capture.Mode, saved.Capture.Compression.Quality)
+	}
+	if saved.History.MaxFrames != 10 {
+		t.Errorf("saved history.max_frames %d, want the file's 10", saved.History.MaxFrames)
+	}
+
+	// The overrides still apply to what is in effect
+	if current := s.currentConfig(); current.Server.Port != 9999 || current.Capture.Interval != 250*time.Millisecond {
+		t.Errorf("in effect port %d and interval %v, want the overrides", current.Server.Port, current.Capture.Interval)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamFPS = 5
+	maxStreamFPS     = 30
+
+	streamBoundary = "frame"
+)
+
+// streamFrame is one JPEG-encoded frame shared by all stream subscribers
+type streamFrame struct {
+	data     []byte
+	captured time.Time
+}
+
+// streamSubscriber receives frames through a channel holding at most one
+// frame; a subscriber that falls behind only ever sees the newest frame
+type streamSubscriber struct {
+	frames chan streamFrame
+	fps    float64
+}
+
+// frameHub fans frames out to /stream clients. Publishing never blocks: a
+// frame waiting for a slow client is replaced by the newer one.
+type frameHub struct {
+	mu          sync.Mutex
+	subscribers map[*streamSubscriber]struct{}
+	latest      *streamFrame
+	producing   bool
+}
+
+func newFrameHub() *frameHub {
+	return &frameHub{subscribers: make(map[*streamSubscriber]struct{})}
+}
+
+// subscribe registers a client, handing it the latest frame right away. When
+// the client needs frames produced for it (no realtime loop is running), it
+// reports whether the caller should start producing them because nobody
+// else is.
+func (h *frameHub) subscribe(fps float64, produce bool) (*streamSubscriber, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &streamSubscriber{frames: make(chan streamFrame, 1), fps: fps}
+	if h.latest != nil {
+		sub.frames <- *h.latest
+	}
+	h.subscribers[sub] = struct{}{}
+
+	start := produce && !h.producing
+	if start {
+		h.producing = true
+	}
+	return sub, start
+}
+
+func (h *frameHub) unsubscribe(sub *streamSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// active reports whether any client is subscribed, so that frames are only
+// encoded for streaming when someone is watching
+func (h *frameHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+func (h *frameHub) publish(frame streamFrame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest = &frame
+	for sub := range h.subscribers {
+		select {
+		case <-sub.frames:
+		default:
+		}
+		sub.frames <- frame
+	}
+}
+
+// dropLatest forgets the latest frame, so that new clients wait for the next
+func (h *frameHub) dropLatest() {
+	h.mu.Lock()
+	h.latest = nil
+	h.mu.Unlock()
+}
+
+// captureInterval returns the delay between frames needed by the fastest
+// subscriber. When there are no subscribers left it returns 0 and marks
+// production as stopped, so the next subscriber starts it again.
+func (h *frameHub) captureInterval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fps := 0.0
+	for sub := range h.subscribers {
+		fps = max(fps, sub.fps)
+	}
+	if fps == 0 {
+		h.producing = false
+		return 0
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// publishStreamFrame encodes a captured frame as JPEG for /stream clients,
+// reusing the stored encoding when it already is JPEG
+func (s *Server) publishStreamFrame(screenshot *Screenshot, opts *ScreenshotOptions, encoded []byte, format string) {
+	if !s.stream.active() {
+		return
+	}
+
+	if format != formatJPEG {
+		jpegOpts := *opts
+		jpegOpts.Format = formatJPEG
+		data, err := screenshot.EncodeWithOptions(&jpegOpts)
+		if err != nil {
+			fmt.Printf("Failed to encode stream frame: %v\n", err)
+			return
+		}
+		encoded = data
+	}
+
+	// A pause may have begun while the frame was encoded
+	s.pause.unlessPaused(func() {
+		s.stream.publish(streamFrame{data: encoded, captured: time.Now()})
+	})
+}
+
+// streamCapture captures frames for /stream clients in ondemand mode, where
+// no realtime loop is running, for as long as anyone is subscribed
+func (s *Server) streamCapture() {
+	for {
+		interval := s.stream.captureInterval()
+		if interval == 0 {
+			return
+		}
+
+		started := time.Now()
+		if err := s.updateScreenshot(); err != nil && err != errCapturePaused && err != errPrivacyPaused {
+			fmt.Printf("Failed to capture stream frame: %v\n", err)
+		}
+
+		select {
+		case <-time.After(interval - time.Since(started)):
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// handleStream serves frames as an MJPEG multipart/x-mixed-replace stream,
+// which browsers play in a plain <img> tag without JavaScript
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	fps := float64(defaultStreamFPS)
+	if value := r.URL.Query().Get("fps"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > maxStreamFPS {
+			http.Error(w, fmt.Sprintf("Invalid fps, must be greater than 0 and at most %d", maxStreamFPS), http.StatusBadRequest)
+			return
+		}
+		fps = parsed
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, start := s.stream.subscribe(fps, s.currentConfig().Capture.Mode != "realtime")
+	defer s.stream.unsubscribe(sub)
+	if start {
+		go s.streamCapture()
+	}
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+streamBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	flusher.Flush()
+
+	minGap := time.Duration(float64(time.Second) / fps)
+	var lastSent time.Time
+
+	for {
+		var frame streamFrame
+		select {
+		case frame = <-sub.frames:
+		case <-r.Context().Done():
+			return
+		case <-s.stopChan:
+			return
+		}
+
+		// Hold back frames arriving faster than requested, then send the newest
+		if wait := minGap - time.Since(lastSent); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			case <-s.stopChan:
+				return
+			}
+			select {
+			case frame = <-sub.frames:
+			default:
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\nLast-Modified: %s\r\n\r\n",
+			streamBoundary, len(frame.data), frame.captured.UTC().Format(http.TimeFormat))
+		if err == nil {
+			_, err = w.Write(frame.data)
+		}
+		if err == nil {
+			_, err = w.Write([]byte("\r\n"))
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		lastSent = time.Now()
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCertDir = "certs"
+
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	leafCertFile = "cert.pem"
+	leafKeyFile  = "key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 397 * 24 * time.Hour // the longest browsers accept
+	leafRenewal  = 30 * 24 * time.Hour  // renew this long before expiry
+
+	// selfSignedCheckEvery is how often a running server checks whether the
+	// generated certificate needs renewing
+	selfSignedCheckEvery = time.Hour
+)
+
+// loadCertificate returns the configured certificate, or the generated
+// self-signed one when no cert_file/key_file are set
+func loadCertificate(config TLSConfig) (tls.Certificate, error) {
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		return cert, nil
+	}
+
+	return loadOrCreateSelfSigned(certDir(config), certificateHosts(config.Hosts), time.Now())
+}
+
+func certDir(config TLSConfig) string {
+	if config.CertDir == "" {
+		return defaultCertDir
+	}
+	return config.CertDir
+}
+
+// selfSignedCertificate serves the generated certificate to TLS handshakes.
+// renew replaces it from the background while the server runs, so handshakes
+// never wait for key generation or disk writes.
+type selfSignedCertificate struct {
+	dir   string
+	hosts []string // extra hosts from the config
+	now   func() time.Time
+	cert  atomic.Pointer[tls.Certificate]
+}
+
+func newSelfSignedCertificate(config TLSConfig, cert tls.Certificate) *selfSignedCertificate {
+	c := &selfSignedCertificate{dir: certDir(config), hosts: config.Hosts, now: time.Now}
+	c.cert.Store(&cert)
+	return c
+}
+
+// getCertificate is the tls.Config GetCertificate hook
+func (c *selfSignedCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// renew replaces the certificate when it nears expiry or no longer covers the
+// host's addresses. The current one keeps being served if that fails.
+func (c *selfSignedCertificate) renew() {
+	cert, err := loadOrCreateSelfSigned(c.dir, certificateHosts(c.hosts), c.now())
+	if err != nil {
+		fmt.Printf("Failed to renew TLS certificate: %v\n", err)
+		return
+	}
+	if !cert.Leaf.Equal(c.cert.Load().Leaf) {
+		fmt.Printf("TLS certificate SHA-256 fingerprint: %s, valid until %s\n", certificateFingerprint(cert.Leaf.Raw), cert.Leaf.NotAfter.Format("2006-01-02"))
+	}
+	c.cert.Store(&cert)
+}
+
+// renewCertificate checks the generated certificate of the HTTPS listener
+// every selfSignedCheckEvery
+func (s *Server) renewCertificate(stop <-chan struct{}) {
+	ticker := time.NewTicker(selfSignedCheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c := s.certificate.Load(); c != nil {
+				c.renew()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// certificateHosts lists the names and addresses a generated certificate has
+// to cover: localhost, the host name, every interface address and any extra
+// hosts from the config
+func certificateHosts(extra []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	hosts = append(hosts, extra...)
+
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
+
+// loadOrCreateSelfSigned keeps a private CA and a certificate signed by it in
+// dir. The CA is created once, so clients that trust or pin it keep working
+// when the certificate is renewed because it is about to expire or the
+// host's addresses changed.
+func loadOrCreateSelfSigned(dir string, hosts []string, now time.Time) (tls.Certificate, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	caCert, caKey, err := loadKeyPair(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return tls.Certificate{}, fmt.Errorf("failed to load CA: %v", err)
+		}
+		caCert, caKey, err = createCA(now)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := saveKeyPair(dir, caCertFile, caKeyFile, caCert, caKey); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Printf("Generated TLS CA in %s\n", dir)
+	}
+
+	leaf, leafKey, err := loadKeyPair(filepath.Join(dir, leafCertFile), filepath.Join(dir, leafKeyFile))
+	if err != nil && !os.IsNotExist(err) {
+		return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if err != nil || !leafUsable(leaf, caCert, hosts, now) {
+		leaf, leafKey, err = createLeaf(caCert, caKey, hosts, now)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := saveKeyPair(dir, leafCertFile, leafKeyFile, leaf, leafKey); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Printf("Generated TLS certificate for %s\n", strings.Join(hosts, ", "))
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, caCert.Raw},
+		PrivateKey:  leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// leafUsable reports whether a stored certificate is signed by the CA, is
+// already valid and not close to expiry, and still covers every host
+func leafUsable(leaf, ca *x509.Certificate, hosts []string, now time.Time) bool {
+	if leaf.CheckSignatureFrom(ca) != nil || now.Before(leaf.NotBefore) || now.Add(leafRenewal).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func createCA(now time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Desktop Surveillance Camera CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	return signCertificate(template, nil, key, key)
+}
+
+func createLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, now time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Desktop Surveillance Camera"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return signCertificate(template, ca, key, caKey)
+}
+
+// signCertificate signs template with signer, or self-signs it when parent is nil
+func signCertificate(template, parent *x509.Certificate, key, signer *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func loadKeyPair(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s or %s is not PEM encoded", certPath, keyPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func saveKeyPair(dir, certName, keyName string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyName), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to save private key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, certName), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %v", err)
+	}
+	return nil
+}
+
+// certificateFingerprint formats the SHA-256 of a DER certificate the way
+// browsers show it, AB:CD:...
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host on the HTTPS port
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		if port != 443 {
+			host += ":" + strconv.Itoa(port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// loadTLSConfig loads the certificate for the HTTPS listener and prints its
+// fingerprints so clients can check them. For a generated certificate it also
+// returns the holder that renews it, nil otherwise.
+func loadTLSConfig(config TLSConfig) (*tls.Config, *selfSignedCertificate, error) {
+	cert, err := loadCertificate(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse TLS certificate: %v", err)
+		}
+	}
+	fmt.Printf("TLS certificate SHA-256 fingerprint: %s\n", certificateFingerprint(leaf.Raw))
+	if config.CertFile == "" {
+		fmt.Printf("TLS CA SHA-256 fingerprint: %s\n", certificateFingerprint(cert.Certificate[len(cert.Certificate)-1]))
+	}
+	fmt.Printf("Certificate valid until %s\n", leaf.NotAfter.Format("2006-01-02"))
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CertFile != "" || config.KeyFile != "" {
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		return tlsConfig, nil, nil
+	}
+	generated := newSelfSignedCertificate(config, cert)
+	tlsConfig.GetCertificate = generated.getCertificate
+	return tlsConfig, generated, nil
+}
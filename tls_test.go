@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedRenewal(t *testing.T) {
+	config := TLSConfig{CertDir: t.TempDir(), Hosts: []string{"camera.example"}}
+	clock := &testClock{t: time.Now()}
+	cert, err := loadOrCreateSelfSigned(config.CertDir, certificateHosts(config.Hosts), clock.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewer := newSelfSignedCertificate(config, cert)
+	renewer.now = clock.now
+	first := cert.Leaf
+
+	steps := []struct {
+		advance time.Duration
+		renewed bool
+	}{
+		{selfSignedCheckEvery, false},
+		{leafValidity - leafRenewal - 2*selfSignedCheckEvery, false},
+		{2 * selfSignedCheckEvery, true}, // within leafRenewal of expiry
+	}
+	for i, step := range steps {
+		clock.advance(step.advance)
+		renewer.renew()
+		got, err := renewer.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renewed := !got.Leaf.Equal(first); renewed != step.renewed {
+			t.Fatalf("step %d: renewed %v, want %v", i, renewed, step.renewed)
+		}
+	}
+
+	renewed, _ := renewer.getCertificate(nil)
+	ca, _, err := loadKeyPair(filepath.Join(config.CertDir, caCertFile), filepath.Join(config.CertDir, caKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := renewed.Leaf.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("renewed certificate is not signed by the CA: %v", err)
+	}
+	if !renewed.Leaf.NotAfter.After(first.NotAfter) || renewed.Leaf.VerifyHostname("camera.example") != nil {
+		t.Errorf("renewed certificate valid until %v for %v", renewed.Leaf.NotAfter, renewed.Leaf.DNSNames)
+	}
+	stored, _, err := loadKeyPair(filepath.Join(config.CertDir, leafCertFile), filepath.Join(config.CertDir, leafKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Equal(renewed.Leaf) {
+		t.Error("renewed certificate was not saved")
+	}
+}
+
+func TestLoadTLSConfigGeneratedCertificate(t *testing.T) {
+	tlsConfig, generated, err := loadTLSConfig(TLSConfig{CertDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated == nil || tlsConfig.GetCertificate == nil || len(tlsConfig.Certificates) != 0 {
+		t.Fatal("generated certificate is not served through GetCertificate")
+	}
+
+	// A renewed certificate is served to the next handshake
+	renewed := *generated.cert.Load()
+	generated.cert.Store(&renewed)
+	if cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{}); err != nil || cert != &renewed {
+		t.Errorf("handshake got %v, %v; want the renewed certificate", cert, err)
+	}
+}
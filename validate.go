@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldError is a problem with one setting, named by its path in the config
+// file such as capture.region.width or webhooks.targets[0].url
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a configuration
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// readConfig parses a config file converted to JSON by decodeConfigFile,
+// applies the overrides and checks the result. A file that cannot be
+// decoded only yields err; otherwise every problem is listed, fields the
+// program does not know and override values that cannot be parsed included.
+func readConfig(data []byte, overrides configOverrides) (*Config, ValidationErrors, error) {
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	problems := unknownConfigFields(data)
+	problems = append(problems, overrides.apply(config)...)
+	problems = append(problems, checkConfig(config)...)
+	return config, problems, nil
+}
+
+// unknownConfigFields lists the keys in a JSON config, complete or partial,
+// that do not match any setting, usually because of a typo
+func unknownConfigFields(data []byte) ValidationErrors {
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil // reported when decoding into a Config
+	}
+	var problems ValidationErrors
+	findUnknownFields(reflect.TypeOf(Config{}), tree, "", &problems)
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+	return problems
+}
+
+func findUnknownFields(t reflect.Type, value interface{}, path string, problems *ValidationErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, child := range object {
+			field, ok := jsonField(t, key)
+			if !ok {
+				problems.add(joinFieldPath(path, key), "unknown field")
+				continue
+			}
+			findUnknownFields(field.Type, child, joinFieldPath(path, key), problems)
+		}
+	case reflect.Slice:
+		items, _ := value.([]interface{})
+		for i, item := range items {
+			findUnknownFields(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+}
+
+// jsonField finds the struct field encoding/json decodes key into; like the
+// decoder it ignores case
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkConfig returns every problem with the settings in config, or nil
+func checkConfig(config *Config) ValidationErrors {
+	var problems ValidationErrors
+
+	if config.Version != currentConfigVersion {
+		problems.add("version", "must be %d", currentConfigVersion)
+	}
+	checkServerConfig(&problems, config.Server)
+	checkPauseConfig(&problems, config.Pause, config.Server)
+	checkCaptureConfig(&problems, config.Capture)
+
+	if config.History.Enabled && config.History.MaxFrames < 1 {
+		problems.add("history.max_frames", "must be > 0")
+	}
+	if config.History.MaxMB < 0 {
+		problems.add("history.max_mb", "must be >= 0")
+	}
+
+	if config.Archive.MaxAgeDays < 0 {
+		problems.add("archive.max_age_days", "must be >= 0")
+	}
+	if config.Archive.MaxMB < 0 {
+		problems.add("archive.max_mb", "must be >= 0")
+	}
+
+	if change := config.ChangeDetection; change.Enabled {
+		if change.BlockSize < 1 {
+			problems.add("change_detection.block_size", "must be > 0")
+		}
+		if change.Threshold < 0 || change.Threshold > 255 {
+			problems.add("change_detection.threshold", "must be between 0 and 255")
+		}
+		for i, region := range change.Ignore {
+			checkRegionSize(&problems, fmt.Sprintf("change_detection.ignore[%d]", i), region)
+		}
+	}
+
+	checkWebhooksConfig(&problems, config.Webhooks)
+	checkAuthConfig(&problems, config.Auth)
+
+	return problems
+}
+
+func checkServerConfig(problems *ValidationErrors, server ServerConfig) {
+	if server.Port < 1 || server.Port > 65535 {
+		problems.add("server.port", "must be between 1 and 65535")
+	}
+
+	if tls := server.TLS; tls.Enabled {
+		if tls.CertFile != "" && tls.KeyFile == "" {
+			problems.add("server.tls.key_file", "must be set together with cert_file")
+		}
+		if tls.KeyFile != "" && tls.CertFile == "" {
+			problems.add("server.tls.cert_file", "must be set together with key_file")
+		}
+		if tls.RedirectPort < 0 || tls.RedirectPort > 65535 {
+			problems.add("server.tls.redirect_port", "must be between 0 and 65535")
+		} else if tls.RedirectPort == server.Port {
+			problems.add("server.tls.redirect_port", "must differ from server.port")
+		}
+	}
+}
+
+func checkPauseConfig(problems *ValidationErrors, pause PauseConfig, server ServerConfig) {
+	if !pause.Enabled {
+		return
+	}
+	if pause.Port < 1 || pause.Port > 65535 {
+		problems.add("pause.port", "must be between 1 and 65535")
+	} else if pause.Port == server.Port {
+		problems.add("pause.port", "must differ from server.port")
+	} else if server.TLS.Enabled && pause.Port == server.TLS.RedirectPort {
+		problems.add("pause.port", "must differ from server.tls.redirect_port")
+	}
+}
+
+func checkCaptureConfig(problems *ValidationErrors, capture CaptureConfig) {
+	if capture.Mode != "ondemand" && capture.Mode != "realtime" {
+		problems.add("capture.mode", `must be "ondemand" or "realtime"`)
+	}
+	if capture.Mode == "realtime" && capture.Interval <= 0 {
+		problems.add("capture.interval", "must be > 0 in realtime mode")
+	} else if capture.Interval < 0 {
+		problems.add("capture.interval", "must be >= 0")
+	}
+	if !isValidCaptureSource(capture.Source) {
+		problems.add("capture.source", "must be one of %s", strings.Join(captureSources, ", "))
+	}
+	if capture.Monitor < 0 {
+		problems.add("capture.monitor", "must be >= 0")
+	}
+
+	if region := capture.Region; region != nil {
+		checkRegionSize(problems, "capture.region", *region)
+		// Relative to a monitor the region starts inside it; for the whole
+		// desktop negative coordinates can be valid, as on Windows
+		if capture.Monitor > 0 {
+			if region.X < 0 {
+				problems.add("capture.region.x", "must be >= 0 when a monitor is selected")
+			}
+			if region.Y < 0 {
+				problems.add("capture.region.y", "must be >= 0 when a monitor is selected")
+			}
+		}
+	}
+
+	checkMasks(problems, "capture.masks", capture.Masks)
+
+	_, watermarkProblems := newWatermark(capture.Watermark)
+	*problems = append(*problems, watermarkProblems...)
+	_, scheduleProblems := newCaptureSchedule(capture.Schedule)
+	*problems = append(*problems, scheduleProblems...)
+
+	compression := capture.Compression
+	if _, ok := normalizeImageFormat(compression.Format); !ok {
+		problems.add("capture.compression.format", `must be "png" or "jpeg"`)
+	}
+	if compression.Quality < 0 || compression.Quality > 100 {
+		problems.add("capture.compression.quality", "must be between 1 and 100, or 0 for the default")
+	}
+	if compression.MaxWidth < 0 {
+		problems.add("capture.compression.max_width", "must be >= 0")
+	}
+	if compression.MaxHeight < 0 {
+		problems.add("capture.compression.max_height", "must be >= 0")
+	}
+
+	if synthetic := capture.Synthetic; synthetic != nil {
+		if synthetic.Width < 0 {
+			problems.add("capture.synthetic.width", "must be >= 0")
+		}
+		if synthetic.Height < 0 {
+			problems.add("capture.synthetic.height", "must be >= 0")
+		}
+		for i, monitor := range synthetic.Monitors {
+			checkRegionSize(problems, fmt.Sprintf("capture.synthetic.monitors[%d]", i), monitor)
+		}
+	}
+
+	if fb := capture.Framebuffer; fb != nil {
+		if fb.Width < 0 {
+			problems.add("capture.framebuffer.width", "must be >= 0")
+		}
+		if fb.Height < 0 {
+			problems.add("capture.framebuffer.height", "must be >= 0")
+		}
+		if fb.Stride < 0 {
+			problems.add("capture.framebuffer.stride", "must be >= 0")
+		}
+		if _, ok := framebufferDefaultFormats[fb.BitsPerPixel]; fb.BitsPerPixel != 0 && !ok {
+			problems.add("capture.framebuffer.bits_per_pixel", "must be 16, 24 or 32")
+		}
+		if _, ok := framebufferFormats[strings.ToLower(fb.Format)]; fb.Format != "" && !ok {
+			problems.add("capture.framebuffer.format", "unknown pixel format %q", fb.Format)
+		}
+	}
+}
+
+func checkRegionSize(problems *ValidationErrors, path string, region RegionConfig) {
+	if region.Width <= 0 {
+		problems.add(path+".width", "must be > 0")
+	}
+	if region.Height <= 0 {
+		problems.add(path+".height", "must be > 0")
+	}
+}
+
+func checkWebhooksConfig(problems *ValidationErrors, webhooks WebhooksConfig) {
+	if webhooks.MaxAttempts < 0 {
+		problems.add("webhooks.max_attempts", "must be >= 0")
+	}
+	if webhooks.MaxPending < 0 {
+		problems.add("webhooks.max_pending", "must be >= 0")
+	}
+	for i, target := range webhooks.Targets {
+		path := fmt.Sprintf("webhooks.targets[%d]", i)
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add(path+".url", "must be an http or https URL")
+		}
+		for j, event := range target.Events {
+			if !validWebhookEvent(event) {
+				problems.add(fmt.Sprintf("%s.events[%d]", path, j), "must be one of %s", strings.Join(webhookEvents, ", "))
+			}
+		}
+		if target.MinScore < 0 || target.MinScore > 1 {
+			problems.add(path+".min_score", "must be between 0 and 1")
+		}
+	}
+}
+
+func checkAuthConfig(problems *ValidationErrors, auth AuthConfig) {
+	if auth.Enabled && len(auth.Users) == 0 && len(auth.Tokens) == 0 {
+		problems.add("auth", "users or tokens are required when enabled")
+	}
+	if auth.SessionHours < 0 {
+		problems.add("auth.session_hours", "must be >= 0")
+	}
+
+	usernames := make(map[string]bool)
+	for i, user := range auth.Users {
+		path := fmt.Sprintf("auth.users[%d]", i)
+		if user.Username == "" {
+			problems.add(path+".username", "must not be empty")
+		} else if usernames[user.Username] {
+			problems.add(path+".username", "duplicate user %q", user.Username)
+		}
+		usernames[user.Username] = true
+		if _, _, _, err := parsePasswordHash(user.PasswordHash); err != nil {
+			problems.add(path+".password_hash", "%v", err)
+		}
+		if user.Role != "" && !validRole(user.Role) {
+			problems.add(path+".role", "must be one of %s", strings.Join(roles, ", "))
+		}
+	}
+	for i, token := range auth.Tokens {
+		path := fmt.Sprintf("auth.tokens[%d]", i)
+		if !strings.HasPrefix(token.TokenHash, tokenHashPrefix) {
+			problems.add(path+".token_hash", "must start with %s, as printed by -new-token", tokenHashPrefix)
+		}
+		if token.Role != "" && !validRole(token.Role) {
+			problems.add(path+".role", "must be one of %s", strings.Join(roles, ", "))
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      []string
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"port out of range", func(c *Config) { c.Server.Port = 70000 }, []string{
+			"server.port: must be between 1 and 65535",
+		}},
+		{"redirect to the same port", func(c *Config) {
+			c.Server.TLS = TLSConfig{Enabled: true, RedirectPort: c.Server.Port}
+		}, []string{
+			"server.tls.redirect_port: must differ from server.port",
+		}},
+		{"unknown mode", func(c *Config) { c.Capture.Mode = "sometimes" }, []string{
+			`capture.mode: must be "ondemand" or "realtime"`,
+		}},
+		{"realtime without interval", func(c *Config) {
+			c.Capture.Mode = "realtime"
+			c.Capture.Interval = 0
+		}, []string{
+			"capture.interval: must be > 0 in realtime mode",
+		}},
+		{"unknown source", func(c *Config) { c.Capture.Source = "webcam" }, []string{
+			"capture.source: must be one of native, x11, framebuffer, synthetic, replay",
+		}},
+		{"empty region", func(c *Config) { c.Capture.Region = &RegionConfig{X: 10, Y: 10} }, []string{
+			"capture.region.width: must be > 0",
+			"capture.region.height: must be > 0",
+		}},
+		{"negative region on the desktop", func(c *Config) {
+			c.Capture.Region = &RegionConfig{X: -1920, Y: -100, Width: 800, Height: 600}
+		}, nil},
+		{"negative region on a monitor", func(c *Config) {
+			c.Capture.Monitor = 2
+			c.Capture.Region = &RegionConfig{X: -1, Y: 0, Width: 800, Height: 600}
+		}, []string{
+			"capture.region.x: must be >= 0 when a monitor is selected",
+		}},
+		{"compression", func(c *Config) {
+			c.Capture.Compression = CompressionConfig{Format: "gif", Quality: 101, MaxWidth: -1, MaxHeight: -1}
+		}, []string{
+			`capture.compression.format: must be "png" or "jpeg"`,
+			"capture.compression.quality: must be between 1 and 100, or 0 for the default",
+			"capture.compression.max_width: must be >= 0",
+			"capture.compression.max_height: must be >= 0",
+		}},
+		{"bad masks", func(c *Config) {
+			c.Capture.Masks = []MaskConfig{
+				{Width: 10, Height: 10},
+				{Width: 0, Height: 10, Style: "smudge", Color: "red"},
+			}
+		}, []string{
+			"capture.masks[1].width: must be > 0",
+			"capture.masks[1].style: must be one of fill, pixelate, blur",
+			"capture.masks[1].color: must be a color as #RRGGBB",
+		}},
+		// Masks are drawn one after the other, so overlaps are harmless
+		{"overlapping masks", func(c *Config) {
+			c.Capture.Masks = []MaskConfig{
+				{X: 0, Y: 0, Width: 100, Height: 100},
+				{X: 50, Y: 50, Width: 100, Height: 100, Style: "blur"},
+				{X: 0, Y: 0, Width: 100, Height: 100, Style: "pixelate"},
+			}
+		}, nil},
+		{"bad schedule", func(c *Config) {
+			c.Capture.Schedule = &ScheduleConfig{
+				TimeZone: "Mars/Olympus_Mons",
+				Windows:  []ScheduleRule{{Days: []string{"mon", "someday"}, Start: "24:00", End: "25:00"}},
+				Quiet:    []ScheduleRule{{Start: "9", End: "10:00"}},
+				Holidays: []string{"2026-02-30"},
+			}
+		}, []string{
+			`capture.schedule.time_zone: unknown time zone "Mars/Olympus_Mons"`,
+			"capture.schedule.windows[0].days[1]: must be one of sun, mon, tue, wed, thu, fri, sat",
+			"capture.schedule.windows[0].start: must be a time of day as HH:MM",
+			"capture.schedule.windows[0].end: must be a time of day as HH:MM, or 24:00",
+			"capture.schedule.quiet[0].start: must be a time of day as HH:MM",
+			"capture.schedule.holidays[0]: must be a date as YYYY-MM-DD",
+		}},
+		{"framebuffer", func(c *Config) {
+			c.Capture.Framebuffer = &FramebufferConfig{Width: -1, BitsPerPixel: 8, Format: "yuv"}
+		}, []string{
+			"capture.framebuffer.width: must be >= 0",
+			"capture.framebuffer.bits_per_pixel: must be 16, 24 or 32",
+			`capture.framebuffer.format: unknown pixel format "yuv"`,
+		}},
+		{"history", func(c *Config) {
+			c.History.Enabled = true
+			c.History.MaxFrames = 0
+			c.History.MaxMB = -1
+		}, []string{
+			"history.max_frames: must be > 0",
+			"history.max_mb: must be >= 0",
+		}},
+		{"webhooks", func(c *Config) {
+			c.Webhooks.Targets = []WebhookConfig{{URL: "ftp://example.com/", Events: []string{"change", "explosion"}, MinScore: 2}}
+		}, []string{
+			"webhooks.targets[0].url: must be an http or https URL",
+			"webhooks.targets[0].events[1]: must be one of " + strings.Join(webhookEvents, ", "),
+			"webhooks.targets[0].min_score: must be between 0 and 1",
+		}},
+		{"auth without users", func(c *Config) { c.Auth.Enabled = true }, []string{
+			"auth: users or tokens are required when enabled",
+		}},
+		{"wrong version", func(c *Config) { c.Version = 1 }, []string{
+			"version: must be 2",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			test.configure(config)
+
+			var got []string
+			for _, problem := range checkConfig(config) {
+				got = append(got, problem.Error())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnknownConfigFields(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{"known fields", `{"server": {"port": 80}, "capture": {"masks": [{"x": 1}]}}`, nil},
+		{"any case", `{"Server": {"PORT": 80}}`, nil},
+		{"top level", `{"sever": {}}`, []string{"sever"}},
+		{"nested", `{"capture": {"region": {"widht": 1}}}`, []string{"capture.region.widht"}},
+		{"in a list", `{"capture": {"masks": [{"x": 1}, {"colour": "#000000"}]}}`, []string{"capture.masks[1].colour"}},
+		{"sorted", `{"zeta": 1, "capture": {"beta": 1}, "alpha": 1}`, []string{"alpha", "capture.beta", "zeta"}},
+		{"not JSON", `{`, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, problem := range unknownConfigFields([]byte(test.json)) {
+				if problem.Message != "unknown field" {
+					t.Errorf("%s: %s", problem.Field, problem.Message)
+				}
+				got = append(got, problem.Field)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		ok   bool
+	}{
+		{"valid.json", `{"server": {"port": 8080}}`, true},
+		{"valid.yaml", "capture:\n  mode: realtime\n  interval: 2s\n", true},
+		{"invalid.json", `{"server": {"port": 0}, "capture": {"mdoe": "realtime"}}`, false},
+		{"unreadable.json", `{"server": `, false},
+		// A missing file stands for the defaults, as on startup
+		{"missing.json", "", true},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+			if test.file != "" {
+				if err := os.WriteFile(path, []byte(test.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if ok := checkConfigFile(path, nil); ok != test.ok {
+				t.Errorf("check passed: %v, want %v", ok, test.ok)
+			}
+		})
+	}
+
+	// Overrides are checked with the file
+	path := filepath.Join(dir, "valid.json")
+	if checkConfigFile(path, configOverrides{{field: "server.port", value: "not a port", source: sourceFlag, name: "-server.port"}}) {
+		t.Error("check passed with an invalid override")
+	}
+}
+
+func TestConfigPostValidation(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	update := `{"server": {"port": 0}, "capture": {"mode": "realtime", "interval": "0s", "region": {"x": 0, "y": 0, "width": 0, "height": 10}, "qualty": 5}}`
+	resp, err := http.Post(ts.URL+"/config", "application/json", strings.NewReader(update))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("status %s, want 422", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type %q", contentType)
+	}
+
+	var body struct {
+		Status string       `json:"status"`
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldError{
+		{"capture.qualty", "unknown field"},
+		{"server.port", "must be between 1 and 65535"},
+		{"capture.interval", "must be > 0 in realtime mode"},
+		{"capture.region.width", "must be > 0"},
+	}
+	if body.Status != "error" || !reflect.DeepEqual(body.Errors, want) {
+		t.Errorf("got %+v\nwant %+v", body, want)
+	}
+
+	// Nothing of a rejected update is applied
+	resp, err = http.Get(ts.URL + "/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var config Config
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port == 0 || config.Capture.Region != nil {
+		t.Errorf("rejected update applied: port %d, region %+v", config.Server.Port, config.Capture.Region)
+	}
+}
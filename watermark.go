@@ -0,0 +1,143 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Corners for capture.watermark.position
+const (
+	watermarkTopLeft     = "top-left"
+	watermarkTopRight    = "top-right"
+	watermarkBottomLeft  = "bottom-left"
+	watermarkBottomRight = "bottom-right"
+)
+
+var watermarkPositions = []string{watermarkTopLeft, watermarkTopRight, watermarkBottomLeft, watermarkBottomRight}
+
+const (
+	defaultWatermarkText    = "{hostname} {time}"
+	defaultWatermarkScale   = 2
+	defaultWatermarkOpacity = 0.6
+	maxWatermarkScale       = 20
+
+	watermarkTimeLayout = "2006-01-02 15:04:05 -07:00"
+)
+
+// watermark is a compiled WatermarkConfig
+type watermark struct {
+	text     string
+	location *time.Location
+	position string
+	scale    int
+	alpha    uint8 // of the background
+	hostname string
+}
+
+// watermarkLabel is the watermark text for one frame
+type watermarkLabel struct {
+	*watermark
+	lines []string
+}
+
+// newWatermark compiles the watermark in config, or returns nil when it is
+// disabled. Problems are reported with the path of the setting, as by
+// checkConfig.
+func newWatermark(config WatermarkConfig) (*watermark, ValidationErrors) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	var problems ValidationErrors
+
+	w := &watermark{text: config.Text, location: time.Local, position: config.Position, scale: config.Scale}
+	if config.TimeZone != "" {
+		location, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			problems.add("capture.watermark.time_zone", "unknown time zone %q", config.TimeZone)
+		} else {
+			w.location = location
+		}
+	}
+	if indexOf(watermarkPositions, config.Position) < 0 {
+		problems.add("capture.watermark.position", "must be one of %s", strings.Join(watermarkPositions, ", "))
+	}
+	if config.Scale < 1 || config.Scale > maxWatermarkScale {
+		problems.add("capture.watermark.scale", "must be between 1 and %d", maxWatermarkScale)
+	}
+	if config.Opacity < 0 || config.Opacity > 1 {
+		problems.add("capture.watermark.opacity", "must be between 0 and 1")
+	}
+	w.alpha = uint8(math.Round(config.Opacity * 255))
+
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "unknown"
+	}
+
+	return w, problems
+}
+
+// label fills in the text for a frame captured at the given time from a
+// monitor, 0 being the whole desktop; a nil watermark has no label
+func (w *watermark) label(captured time.Time, monitor int) *watermarkLabel {
+	if w == nil {
+		return nil
+	}
+
+	monitorText := "desktop"
+	if monitor != 0 {
+		monitorText = strconv.Itoa(monitor)
+	}
+	text := strings.NewReplacer(
+		"{hostname}", w.hostname,
+		"{time}", captured.In(w.location).Format(watermarkTimeLayout),
+		"{monitor}", monitorText,
+	).Replace(w.text)
+
+	return &watermarkLabel{watermark: w, lines: strings.Split(text, "\n")}
+}
+
+// small returns the label in the smallest font, for webhook thumbnails
+// that are too small for the configured one
+func (l *watermarkLabel) small() *watermarkLabel {
+	if l == nil {
+		return nil
+	}
+	w := *l.watermark
+	w.scale = 1
+	return &watermarkLabel{watermark: &w, lines: l.lines}
+}
+
+// draw burns the label into the corner of img on a translucent background
+func (l *watermarkLabel) draw(img draw.Image) {
+	scale := l.scale
+	padding := 2 * scale
+	lineHeight := (glyphHeight + 3) * scale
+	width := 0
+	for _, line := range l.lines {
+		w, _ := textSize(line, scale)
+		width = max(width, w)
+	}
+	size := image.Pt(width+2*padding, len(l.lines)*lineHeight+padding)
+
+	bounds := img.Bounds()
+	corner := bounds.Min
+	if l.position == watermarkTopRight || l.position == watermarkBottomRight {
+		corner.X = bounds.Max.X - size.X
+	}
+	if l.position == watermarkBottomLeft || l.position == watermarkBottomRight {
+		corner.Y = bounds.Max.Y - size.Y
+	}
+	box := image.Rectangle{Min: corner, Max: corner.Add(size)}
+
+	draw.Draw(img, box, image.NewUniform(color.NRGBA{0, 0, 0, l.alpha}), image.Point{}, draw.Over)
+	for i, line := range l.lines {
+		drawText(img, box.Min.X+padding, box.Min.Y+padding+i*lineHeight, line, scale, color.White)
+	}
+}
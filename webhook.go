@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Events a webhook can subscribe to
+const (
+	webhookEventChange       = "change"
+	webhookEventCaptureError = "capture_error"
+	webhookEventInput        = "input"
+	webhookEventStart        = "start"
+	webhookEventStop         = "stop"
+)
+
+var webhookEvents = []string{webhookEventChange, webhookEventCaptureError, webhookEventInput, webhookEventStart, webhookEventStop}
+
+const (
+	defaultWebhookMaxAttempts = 10
+	defaultWebhookMaxPending  = 1000
+	webhookRetryBase          = time.Second
+	webhookRetryMax           = 5 * time.Minute
+	webhookTimeout            = 10 * time.Second
+	webhookFlushTimeout       = 5 * time.Second
+
+	webhookThumbnailWidth  = 320
+	webhookThumbnailHeight = 180
+)
+
+// webhookPayload is the JSON body posted to webhook targets
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data,omitempty"`
+	Thumbnail string      `json:"thumbnail,omitempty"` // base64 JPEG of the frame, if requested
+}
+
+// webhookDelivery is a payload waiting to be delivered to one target. Each
+// delivery is kept as a file in the outbox until it succeeds or runs out of
+// attempts, so pending alerts survive a restart.
+type webhookDelivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// webhookNotifier posts events to the configured targets. Payloads are
+// signed with HMAC-SHA256 over the body using the target's secret, sent in
+// the X-Webhook-Signature header as "sha256=<hex>". Failed deliveries are
+// retried with exponential backoff.
+type webhookNotifier struct {
+	targets     []WebhookConfig
+	outbox      string
+	maxAttempts int
+	maxPending  int
+	client      *http.Client
+	now         func() time.Time
+
+	mu      sync.Mutex
+	pending []*webhookDelivery // oldest first
+	seq     uint64
+	wake    chan struct{}
+
+	sending sync.Mutex // serialises delivery rounds
+}
+
+func newWebhookNotifier(config WebhooksConfig) (*webhookNotifier, error) {
+	outbox := config.Outbox
+	if outbox == "" {
+		outbox = "webhook-outbox"
+	}
+	if err := os.MkdirAll(outbox, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create webhook outbox: %v", err)
+	}
+
+	n := &webhookNotifier{
+		targets:     config.Targets,
+		outbox:      outbox,
+		maxAttempts: config.MaxAttempts,
+		maxPending:  config.MaxPending,
+		client:      &http.Client{Timeout: webhookTimeout},
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+	if n.maxAttempts <= 0 {
+		n.maxAttempts = defaultWebhookMaxAttempts
+	}
+	if n.maxPending <= 0 {
+		n.maxPending = defaultWebhookMaxPending
+	}
+
+	// Pick up deliveries left over from the previous run
+	files, err := filepath.Glob(filepath.Join(outbox, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook outbox: %v", err)
+		}
+		var delivery webhookDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil || delivery.ID == "" {
+			fmt.Printf("Webhook: discarding unreadable outbox entry %s\n", file)
+			os.Remove(file)
+			continue
+		}
+		n.pending = append(n.pending, &delivery)
+	}
+	sort.Slice(n.pending, func(i, j int) bool { return n.pending[i].ID < n.pending[j].ID })
+	n.discard(n.trim())
+
+	return n, nil
+}
+
+// wants reports whether a target subscribes to an event
+func (t *WebhookConfig) wants(event string, score float64) bool {
+	if len(t.Events) > 0 {
+		found := false
+		for _, e := range t.Events {
+			if e == event {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return event != webhookEventChange || score >= t.MinScore
+}
+
+// notify queues an event for every target subscribed to it. score is only
+// used for change events; screenshot, if given, is attached as a thumbnail
+// for targets that ask for one, with label burned in as on the stored frame.
+func (n *webhookNotifier) notify(event string, data interface{}, score float64, screenshot *Screenshot, label *watermarkLabel) {
+	now := n.now()
+
+	n.mu.Lock()
+	n.seq++
+	id := fmt.Sprintf("%016x%06x", now.UnixNano(), n.seq&0xffffff)
+	n.mu.Unlock()
+
+	var thumbnail string
+	queued := false
+	for i := range n.targets {
+		target := &n.targets[i]
+		if !target.wants(event, score) {
+			continue
+		}
+
+		payload := webhookPayload{ID: id, Event: event, Time: now, Data: data}
+		if target.Thumbnail && screenshot != nil {
+			if thumbnail == "" {
+				encoded, err := screenshot.EncodeWithOptions(&ScreenshotOptions{
+					Compress:  true,
+					MaxWidth:  webhookThumbnailWidth,
+					MaxHeight: webhookThumbnailHeight,
+					Format:    formatJPEG,
+					Watermark: label.small(),
+				})
+				if err != nil {
+					fmt.Printf("Webhook: failed to encode thumbnail: %v\n", err)
+				} else {
+					thumbnail = base64.StdEncoding.EncodeToString(encoded)
+				}
+			}
+			payload.Thumbnail = thumbnail
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Printf("Webhook: failed to encode %s event: %v\n", event, err)
+			continue
+		}
+
+		delivery := &webhookDelivery{
+			ID:          fmt.Sprintf("%s-%d", id, i),
+			URL:         target.URL,
+			Event:       event,
+			Payload:     body,
+			NextAttempt: now,
+		}
+		n.mu.Lock()
+		n.pending = append(n.pending, delivery)
+		dropped := n.trim()
+		n.mu.Unlock()
+		n.discard(dropped)
+
+		if err := n.save(delivery); err != nil {
+			fmt.Printf("Webhook: failed to write outbox: %v\n", err)
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run delivers queued events until stop is closed
+func (n *webhookNotifier) run(stop <-chan struct{}) {
+	for {
+		n.deliverDue(context.Background())
+
+		timer := time.NewTimer(n.nextWait())
+		select {
+		case <-n.wake:
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// flush makes one attempt at every due delivery, giving up after timeout;
+// whatever is left stays in the outbox for the next run
+func (n *webhookNotifier) flush(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	n.deliverDue(ctx)
+}
+
+// nextWait returns the time until the earliest retry is due
+func (n *webhookNotifier) nextWait() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	wait := webhookRetryMax
+	now := n.now()
+	for _, delivery := range n.pending {
+		wait = min(wait, delivery.NextAttempt.Sub(now))
+	}
+	return max(wait, 0)
+}
+
+func (n *webhookNotifier) deliverDue(ctx context.Context) {
+	n.sending.Lock()
+	defer n.sending.Unlock()
+
+	n.mu.Lock()
+	var due []*webhookDelivery
+	now := n.now()
+	for _, delivery := range n.pending {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		target := n.target(delivery.URL)
+		if target == nil {
+			// The target was removed from the configuration
+			n.remove(delivery)
+			continue
+		}
+
+		err := n.send(ctx, target, delivery)
+		if err == nil {
+			n.remove(delivery)
+			continue
+		}
+
+		n.mu.Lock()
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = n.now().Add(webhookBackoff(delivery.Attempts))
+		attempts := delivery.Attempts
+		n.mu.Unlock()
+
+		if attempts >= n.maxAttempts {
+			fmt.Printf("Webhook: giving up on %s event for %s after %d attempts: %v\n", delivery.Event, delivery.URL, attempts, err)
+			n.remove(delivery)
+			continue
+		}
+		if err := n.save(delivery); err != nil {
+			fmt.Printf("Webhook: failed to write outbox: %v\n", err)
+		}
+	}
+}
+
+// webhookBackoff doubles the delay after every failed attempt
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+func (n *webhookNotifier) target(url string) *WebhookConfig {
+	for i := range n.targets {
+		if n.targets[i].URL == url {
+			return &n.targets[i]
+		}
+	}
+	return nil
+}
+
+func (n *webhookNotifier) send(ctx context.Context, target *WebhookConfig, delivery *webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	if target.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(target.Secret, delivery.Payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *webhookNotifier) remove(delivery *webhookDelivery) {
+	n.mu.Lock()
+	for i, d := range n.pending {
+		if d == delivery {
+			n.pending = append(n.pending[:i], n.pending[i+1:]...)
+			break
+		}
+	}
+	n.mu.Unlock()
+
+	if err := os.Remove(n.path(delivery)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Webhook: failed to remove outbox entry: %v\n", err)
+	}
+}
+
+// trim drops the oldest deliveries beyond maxPending, so that the outbox
+// does not grow without limit while a target is down. The caller holds mu
+// and removes the dropped deliveries from the outbox with discard.
+func (n *webhookNotifier) trim() []*webhookDelivery {
+	excess := len(n.pending) - n.maxPending
+	if excess <= 0 {
+		return nil
+	}
+	dropped := slices.Clone(n.pending[:excess])
+	n.pending = slices.Delete(n.pending, 0, excess)
+	return dropped
+}
+
+func (n *webhookNotifier) discard(dropped []*webhookDelivery) {
+	if len(dropped) == 0 {
+		return
+	}
+	fmt.Printf("Webhook: outbox full, dropping %d undelivered events\n", len(dropped))
+	for _, delivery := range dropped {
+		if err := os.Remove(n.path(delivery)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Webhook: failed to remove outbox entry: %v\n", err)
+		}
+	}
+}
+
+// save writes a pending delivery to the outbox, replacing the previous
+// version atomically. Deliveries removed or dropped meanwhile are not
+// written back.
+func (n *webhookNotifier) save(delivery *webhookDelivery) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !slices.Contains(n.pending, delivery) {
+		return nil
+	}
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	tmp := n.path(delivery) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, n.path(delivery))
+}
+
+func (n *webhookNotifier) path(delivery *webhookDelivery) string {
+	return filepath.Join(n.outbox, delivery.ID+".json")
+}
+
+// notify sends an event to the webhooks, if any are configured
+func (s *Server) notify(event string, data interface{}, score float64, screenshot *Screenshot, label *watermarkLabel) {
+	if s.webhooks != nil {
+		s.webhooks.notify(event, data, score, screenshot, label)
+	}
+}
+
+// validWebhookEvent reports whether event is one targets can subscribe to
+func validWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests posted to it and answers with the
+// status codes in statuses, then with 200 OK
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+		}
+		receiver.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// testClock is a settable clock for the notifier
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestNotifier(t *testing.T, config WebhooksConfig, clock *testClock) *webhookNotifier {
+	t.Helper()
+	n, err := newWebhookNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.now = clock.now
+	return n
+}
+
+// outboxEntries reads the deliveries stored in an outbox directory
+func outboxEntries(t *testing.T, dir string) []webhookDelivery {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []webhookDelivery
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delivery webhookDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+func TestWebhookSignature(t *testing.T) {
+	signed, unsigned := newWebhookReceiver(t), newWebhookReceiver(t)
+	clock := &testClock{t: time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)}
+	n := newTestNotifier(t, WebhooksConfig{
+		Outbox: t.TempDir(),
+		Targets: []WebhookConfig{
+			{URL: signed.URL, Secret: "s3cret"},
+			{URL: unsigned.URL, Events: []string{webhookEventChange}},
+		},
+	}, clock)
+
+	n.notify(webhookEventStart, map[string]string{"version": "test"}, 0, nil, nil)
+	n.notify(webhookEventChange, map[string]float64{"score": 0.5}, 0.5, nil, nil)
+	n.deliverDue(context.Background())
+
+	requests := signed.received()
+	if len(requests) != 2 {
+		t.Fatalf("signed target got %d requests, want 2", len(requests))
+	}
+	for i, event := range []string{webhookEventStart, webhookEventChange} {
+		request := requests[i]
+		if got, want := request.header.Get("X-Webhook-Signature"), "sha256="+webhookSignature("s3cret", request.body); got != want {
+			t.Errorf("%s: signature %q, want %q", event, got, want)
+		}
+		if got := request.header.Get("X-Webhook-Event"); got != event {
+			t.Errorf("event header %q, want %q", got, event)
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(request.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Event != event || !payload.Time.Equal(clock.now()) || request.header.Get("X-Webhook-Id") != payload.ID+"-0" {
+			t.Errorf("payload %+v with ID header %q", payload, request.header.Get("X-Webhook-Id"))
+		}
+	}
+
+	// The second target only wants change events and has no secret
+	requests = unsigned.received()
+	if len(requests) != 1 {
+		t.Fatalf("unsigned target got %d requests, want 1", len(requests))
+	}
+	if signature := requests[0].header.Get("X-Webhook-Signature"); signature != "" {
+		t.Errorf("unsigned target got signature %q", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	clock := &testClock{t: time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)}
+	outbox := t.TempDir()
+	n := newTestNotifier(t, WebhooksConfig{Outbox: outbox, Targets: []WebhookConfig{{URL: receiver.URL}}}, clock)
+
+	n.notify(webhookEventCaptureError, nil, 0, nil, nil)
+	if entries := outboxEntries(t, outbox); len(entries) != 1 || entries[0].Attempts != 0 {
+		t.Fatalf("outbox %+v, want the new delivery", entries)
+	}
+
+	steps := []struct {
+		advance  time.Duration
+		requests int
+		attempts int // of the delivery left in the outbox, -1 once delivered
+		wait     time.Duration
+	}{
+		{0, 1, 1, time.Second},
+		{500 * time.Millisecond, 1, 1, 500 * time.Millisecond}, // not due yet
+		{500 * time.Millisecond, 2, 2, 2 * time.Second},
+		{2 * time.Second, 3, -1, webhookRetryMax},
+	}
+	for i, step := range steps {
+		clock.advance(step.advance)
+		n.deliverDue(context.Background())
+
+		if got := len(receiver.received()); got != step.requests {
+			t.Errorf("step %d: %d requests, want %d", i, got, step.requests)
+		}
+		entries := outboxEntries(t, outbox)
+		if step.attempts < 0 {
+			if len(entries) != 0 {
+				t.Errorf("step %d: outbox %+v, want it empty", i, entries)
+			}
+		} else if len(entries) != 1 || entries[0].Attempts != step.attempts || !entries[0].NextAttempt.Equal(clock.now().Add(step.wait)) || entries[0].LastError == "" {
+			t.Errorf("step %d: outbox %+v, want %d attempts with the next in %v", i, entries, step.attempts, step.wait)
+		}
+		if wait := n.nextWait(); wait != step.wait {
+			t.Errorf("step %d: next round in %v, want %v", i, wait, step.wait)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	clock := &testClock{t: time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)}
+	outbox := t.TempDir()
+	n := newTestNotifier(t, WebhooksConfig{Outbox: outbox, MaxAttempts: 2, Targets: []WebhookConfig{{URL: receiver.URL}}}, clock)
+
+	n.notify(webhookEventStop, nil, 0, nil, nil)
+	for i := 0; i < 3; i++ {
+		n.deliverDue(context.Background())
+		clock.advance(time.Hour)
+	}
+	if got := len(receiver.received()); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+	if entries := outboxEntries(t, outbox); len(entries) != 0 {
+		t.Errorf("outbox %+v, want it empty", entries)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{9, 256 * time.Second},
+		{10, webhookRetryMax},
+		{100, webhookRetryMax},
+	}
+	for _, test := range tests {
+		if got := webhookBackoff(test.attempts); got != test.delay {
+			t.Errorf("after %d attempts: %v, want %v", test.attempts, got, test.delay)
+		}
+	}
+}
+
+func TestWebhookRedeliveryAfterRestart(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	clock := &testClock{t: time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)}
+	config := WebhooksConfig{Outbox: t.TempDir(), Targets: []WebhookConfig{{URL: receiver.URL, Secret: "s3cret"}}}
+
+	n := newTestNotifier(t, config, clock)
+	n.notify(webhookEventChange, map[string]float64{"score": 0.9}, 0.9, nil, nil)
+	n.deliverDue(context.Background())
+	first := receiver.received()
+	if len(first) != 1 {
+		t.Fatalf("%d requests before the restart, want 1", len(first))
+	}
+
+	restarted := newTestNotifier(t, config, clock)
+	if len(restarted.pending) != 1 || restarted.pending[0].Attempts != 1 {
+		t.Fatalf("pending after restart %+v, want the failed delivery", restarted.pending)
+	}
+	restarted.deliverDue(context.Background())
+	if got := len(receiver.received()); got != 1 {
+		t.Fatalf("%d requests before the retry is due, want 1", got)
+	}
+
+	clock.advance(time.Second)
+	restarted.deliverDue(context.Background())
+	requests := receiver.received()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests after the retry, want 2", len(requests))
+	}
+	if string(requests[1].body) != string(first[0].body) || requests[1].header.Get("X-Webhook-Id") != first[0].header.Get("X-Webhook-Id") {
+		t.Error("redelivered event differs from the first attempt")
+	}
+	if got, want := requests[1].header.Get("X-Webhook-Signature"), "sha256="+webhookSignature("s3cret", requests[1].body); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	if entries := outboxEntries(t, config.Outbox); len(entries) != 0 {
+		t.Errorf("outbox %+v, want it empty", entries)
+	}
+}
+
+func TestWebhookOutboxLimit(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	receiver.Close() // down for the whole test
+	clock := &testClock{t: time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)}
+	config := WebhooksConfig{Outbox: t.TempDir(), MaxPending: 3, Targets: []WebhookConfig{{URL: receiver.URL}}}
+
+	n := newTestNotifier(t, config, clock)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		clock.advance(time.Second)
+		n.notify(webhookEventInput, map[string]int{"n": i}, 0, nil, nil)
+		ids = append(ids, n.pending[len(n.pending)-1].ID)
+		n.deliverDue(context.Background())
+	}
+
+	entries := outboxEntries(t, config.Outbox)
+	if len(entries) != 3 || len(n.pending) != 3 {
+		t.Fatalf("%d in the outbox and %d pending, want 3", len(entries), len(n.pending))
+	}
+	for i, delivery := range n.pending {
+		if delivery.ID != ids[i+2] {
+			t.Errorf("pending %d is %s, want %s: the oldest are dropped", i, delivery.ID, ids[i+2])
+		}
+	}
+
+	// A smaller limit also applies to what is left from the previous run
+	config.MaxPending = 1
+	restarted := newTestNotifier(t, config, clock)
+	if len(restarted.pending) != 1 || restarted.pending[0].ID != ids[4] {
+		t.Errorf("pending after restart %+v, want only %s", restarted.pending, ids[4])
+	}
+	if entries := outboxEntries(t, config.Outbox); len(entries) != 1 {
+		t.Errorf("%d in the outbox after restart, want 1", len(entries))
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux && (amd64 || arm64 || arm || riscv64 || loong64)
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ipcPrivate = 0
+	ipcCreat   = 0o1000
+	ipcRmid    = 0
+)
+
+// shmCreate allocates and maps a System V shared memory segment for MIT-SHM
+func shmCreate(size int) (int, []byte, error) {
+	id, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate, uintptr(size), ipcCreat|0o600)
+	if errno != 0 {
+		return 0, nil, fmt.Errorf("shmget: %v", errno)
+	}
+
+	addr, _, errno := syscall.Syscall(syscall.SYS_SHMAT, id, 0, 0)
+	if errno != 0 {
+		shmRemove(int(id))
+		return 0, nil, fmt.Errorf("shmat: %v", errno)
+	}
+
+	// The mapping is outside the Go heap, so converting the address is safe
+	var ptr unsafe.Pointer
+	*(*uintptr)(unsafe.Pointer(&ptr)) = addr
+	return int(id), unsafe.Slice((*byte)(ptr), size), nil
+}
+
+// shmRemove marks a segment for deletion once every process has detached
+func shmRemove(id int) {
+	syscall.Syscall(syscall.SYS_SHMCTL, uintptr(id), ipcRmid, 0)
+}
+
+func shmDetach(data []byte) {
+	if len(data) > 0 {
+		syscall.Syscall(syscall.SYS_SHMDT, uintptr(unsafe.Pointer(&data[0])), 0, 0)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64 || arm || riscv64 || loong64)
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func shmCreate(size int) (int, []byte, error) {
+	return 0, nil, fmt.Errorf("shared memory capture is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func shmRemove(id int) {}
+
+func shmDetach(data []byte) {}